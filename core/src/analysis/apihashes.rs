@@ -0,0 +1,209 @@
+/// recognize the API name hashes that shellcode and stagers use to resolve
+/// imports at runtime, such as `PUSH 0xEC0E4E8E ; CALL resolve`,
+/// and comment the instructions with the API name.
+///
+/// this is a static scan of instruction immediates,
+/// so hashes computed or decoded at runtime are not recognized.
+use std::collections::HashMap;
+
+use failure::Error;
+use log::debug;
+use rust_embed::RustEmbed;
+use zydis;
+
+use super::{
+    super::{arch::RVA, comment::CommentType, workspace::Workspace, x86::get_operands},
+    AnalysisPhase, Analyzer,
+};
+
+#[derive(RustEmbed)]
+#[folder = "$CARGO_MANIFEST_DIR/src/analysis/data"]
+struct Assets;
+
+#[derive(Debug, Copy, Clone, PartialEq, Eq, Hash)]
+pub enum HashAlgorithm {
+    /// rotate right by 13 bits, then add each byte. used by Metasploit and
+    /// many others.
+    Ror13,
+    /// CRC-32 (IEEE).
+    Crc32,
+    /// Bernstein's djb2: multiply by 33, then add each byte.
+    Djb2,
+}
+
+impl HashAlgorithm {
+    pub fn name(self) -> &'static str {
+        match self {
+            HashAlgorithm::Ror13 => "ror13",
+            HashAlgorithm::Crc32 => "crc32",
+            HashAlgorithm::Djb2 => "djb2",
+        }
+    }
+
+    /// ```
+    /// use lancelot::analysis::apihashes::HashAlgorithm;
+    ///
+    /// assert_eq!(HashAlgorithm::Ror13.hash("LoadLibraryA"), 0xEC0E_4E8E);
+    /// assert_eq!(HashAlgorithm::Crc32.hash("LoadLibraryA"), 0x3FC1_BD8D);
+    /// assert_eq!(HashAlgorithm::Djb2.hash("LoadLibraryA"), 0x5FBF_F0FB);
+    /// ```
+    pub fn hash(self, name: &str) -> u32 {
+        match self {
+            HashAlgorithm::Ror13 => name
+                .bytes()
+                .fold(0u32, |h, b| h.rotate_right(13).wrapping_add(u32::from(b))),
+            HashAlgorithm::Crc32 => {
+                let crc = name.bytes().fold(0xFFFF_FFFFu32, |mut crc, b| {
+                    crc ^= u32::from(b);
+                    for _ in 0..8 {
+                        crc = if crc & 1 == 1 {
+                            (crc >> 1) ^ 0xEDB8_8320
+                        } else {
+                            crc >> 1
+                        };
+                    }
+                    crc
+                });
+                !crc
+            }
+            HashAlgorithm::Djb2 => name
+                .bytes()
+                .fold(5381u32, |h, b| h.wrapping_mul(33).wrapping_add(u32::from(b))),
+        }
+    }
+}
+
+const ALGORITHMS: [HashAlgorithm; 3] = [HashAlgorithm::Ror13, HashAlgorithm::Crc32, HashAlgorithm::Djb2];
+
+/// maps the hashes of API names, under each supported algorithm, back to the
+/// names.
+pub struct ApiHashDatabase {
+    hashes: HashMap<u32, Vec<(HashAlgorithm, String)>>,
+}
+
+impl ApiHashDatabase {
+    /// Create a database of the API names bundled with lancelot.
+    pub fn new() -> ApiHashDatabase {
+        let buf = Assets::get("apinames.txt").unwrap();
+        let names = String::from_utf8_lossy(&buf);
+
+        let mut db = ApiHashDatabase { hashes: HashMap::new() };
+        for name in names.lines().map(str::trim) {
+            if name.is_empty() || name.starts_with('#') {
+                continue;
+            }
+            db.add_name(name);
+        }
+        db
+    }
+
+    /// Add the given API name, under each supported algorithm.
+    pub fn add_name(&mut self, name: &str) {
+        for &algo in ALGORITHMS.iter() {
+            self.hashes
+                .entry(algo.hash(name))
+                .or_insert_with(Vec::new)
+                .push((algo, name.to_string()));
+        }
+    }
+
+    /// ```
+    /// use lancelot::analysis::apihashes::{ApiHashDatabase, HashAlgorithm};
+    ///
+    /// let db = ApiHashDatabase::new();
+    /// assert_eq!(db.lookup(0xEC0E_4E8E), vec![(HashAlgorithm::Ror13, "LoadLibraryA")]);
+    /// assert!(db.lookup(0x1234_5678).is_empty());
+    /// ```
+    pub fn lookup(&self, hash: u32) -> Vec<(HashAlgorithm, &str)> {
+        match self.hashes.get(&hash) {
+            Some(names) => names.iter().map(|(algo, name)| (*algo, name.as_str())).collect(),
+            None => vec![],
+        }
+    }
+}
+
+impl Default for ApiHashDatabase {
+    fn default() -> ApiHashDatabase {
+        ApiHashDatabase::new()
+    }
+}
+
+pub struct ApiHashAnalyzer {
+    db: ApiHashDatabase,
+}
+
+impl ApiHashAnalyzer {
+    pub fn new(db: ApiHashDatabase) -> ApiHashAnalyzer {
+        ApiHashAnalyzer { db }
+    }
+
+    /// render the names that match any of the immediates of the given
+    /// instruction, like `ror13(LoadLibraryA)`.
+    fn get_matches(&self, insn: &zydis::DecodedInstruction) -> Vec<String> {
+        get_operands(insn)
+            .into_iter()
+            .filter(|op| op.ty == zydis::OperandType::IMMEDIATE && !op.imm.is_relative)
+            // hashes are 32 bits, though a sign-extended immediate may appear wider.
+            .flat_map(|op| self.db.lookup(op.imm.value as u32))
+            .map(|(algo, name)| format!("{}({})", algo.name(), name))
+            .collect()
+    }
+}
+
+impl Analyzer for ApiHashAnalyzer {
+    fn get_name(&self) -> String {
+        "API hash analyzer".to_string()
+    }
+
+    fn get_phase(&self) -> AnalysisPhase {
+        AnalysisPhase::Functions
+    }
+
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::comment::CommentType;
+    /// use lancelot::analysis::Analyzer;
+    /// use lancelot::analysis::apihashes::{ApiHashAnalyzer, ApiHashDatabase};
+    ///
+    /// // 0: 68 8E 4E 0E EC  PUSH 0xEC0E4E8E
+    /// // 5: C3              RETN
+    /// let mut ws = test::get_shellcode32_workspace(b"\x68\x8E\x4E\x0E\xEC\xC3");
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// ApiHashAnalyzer::new(ApiHashDatabase::new()).analyze(&mut ws).unwrap();
+    /// assert_eq!(ws.get_comment(RVA(0x0), CommentType::Inline).unwrap(), "ror13(LoadLibraryA)");
+    /// ```
+    fn analyze(&self, ws: &mut Workspace) -> Result<(), Error> {
+        let mut comments: Vec<(RVA, String)> = vec![];
+
+        for section in ws.module.sections.iter().filter(|section| section.is_executable()) {
+            let insns: Vec<RVA> = ws
+                .get_metas(section.addr, section.size as usize)?
+                .iter()
+                .enumerate()
+                .filter(|(_, meta)| meta.is_insn())
+                .map(|(j, _)| section.addr + RVA::from(j))
+                .collect();
+
+            for rva in insns.into_iter() {
+                let insn = match ws.read_insn(rva) {
+                    Ok(insn) => insn,
+                    Err(_) => continue,
+                };
+
+                let matches = self.get_matches(&insn);
+                if !matches.is_empty() {
+                    debug!("API hash: {}: {}", rva, matches.join(", "));
+                    comments.push((rva, matches.join(", ")));
+                }
+            }
+        }
+
+        for (rva, text) in comments.into_iter() {
+            ws.make_comment(rva, CommentType::Inline, &text)?;
+        }
+        ws.analyze()
+    }
+}
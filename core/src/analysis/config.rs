@@ -1,6 +1,34 @@
+use std::collections::HashSet;
+
 use super::pe::flirt::FlirtConfig;
 
+/// What to do when the flow of an instruction can't be resolved,
+/// such as when it has an unsupported operand.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum ErrorPolicy {
+    /// keep the instruction without the unresolved flow, and continue.
+    FailPath,
+    /// drop the functions that contain the instruction, and continue.
+    SkipFunction,
+    /// stop the analysis with the error.
+    AbortAll,
+}
+
+impl Default for ErrorPolicy {
+    fn default() -> ErrorPolicy {
+        ErrorPolicy::FailPath
+    }
+}
+
 #[derive(Default, Debug)]
 pub struct AnalysisConfig {
-    pub flirt: FlirtConfig,
+    pub flirt:              FlirtConfig,
+    pub error_policy:       ErrorPolicy,
+    /// names of the optional analyzers to run, like "string analyzer",
+    /// in addition to those suggested by the loader.
+    /// see `analysis::get_optional_analyzers`.
+    pub enabled_analyzers:  HashSet<String>,
+    /// names of the analyzers that should not be run,
+    /// even if the loader suggests them.
+    pub disabled_analyzers: HashSet<String>,
 }
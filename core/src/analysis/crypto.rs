@@ -0,0 +1,372 @@
+/// recognize cryptographic algorithms by their well-known constants,
+/// such as the AES S-box in data or the SHA-1 round constants in
+/// instruction immediates, and tag the functions that use them with the
+/// suspected algorithm, like `crypto:aes`.
+///
+/// RC4 has no constants, so its key scheduling is recognized by a loop that's
+/// bounded by 0x100 and swaps two elements of a byte array.
+///
+/// this should run after code analysis, so that the references can be found.
+use std::collections::{BTreeSet, HashMap};
+
+use failure::Error;
+use log::debug;
+use zydis;
+
+use super::{
+    super::{arch::RVA, basicblock::BasicBlock, comment::CommentType, workspace::Workspace, x86::get_operands},
+    strings::find_references,
+    AnalysisPhase, Analyzer,
+};
+
+/// the prefix of the tag applied to functions that use a cryptographic
+/// algorithm.
+pub const CRYPTO_TAG_PREFIX: &str = "crypto:";
+
+/// well-known tables and strings, found in data: algorithm, description,
+/// and the leading bytes.
+const DATA_CONSTANTS: [(&str, &str, &[u8]); 9] = [
+    (
+        "aes",
+        "AES S-box",
+        b"\x63\x7C\x77\x7B\xF2\x6B\x6F\xC5\x30\x01\x67\x2B\xFE\xD7\xAB\x76",
+    ),
+    (
+        "aes",
+        "AES inverse S-box",
+        b"\x52\x09\x6A\xD5\x30\x36\xA5\x38\xBF\x40\xA3\x9E\x81\xF3\xD7\xFB",
+    ),
+    (
+        "aes",
+        "AES T-table",
+        b"\xA5\x63\x63\xC6\x84\x7C\x7C\xF8\x99\x77\x77\xEE\x8D\x7B\x7B\xF6",
+    ),
+    (
+        "crc32",
+        "CRC-32 table",
+        b"\x00\x00\x00\x00\x96\x30\x07\x77\x2C\x61\x0E\xEE\xBA\x51\x09\x99",
+    ),
+    (
+        "md5",
+        "MD5 sine table",
+        b"\x78\xA4\x6A\xD7\x56\xB7\xC7\xE8\xDB\x70\x20\x24\xEE\xCE\xBD\xC1",
+    ),
+    (
+        "sha256",
+        "SHA-256 initial hash value",
+        b"\x67\xE6\x09\x6A\x85\xAE\x67\xBB\x72\xF3\x6E\x3C\x3A\xF5\x4F\xA5",
+    ),
+    (
+        "sha256",
+        "SHA-256 round constants",
+        b"\x98\x2F\x8A\x42\x91\x44\x37\x71\xCF\xFB\xC0\xB5\xA5\xDB\xB5\xE9",
+    ),
+    ("chacha20", "ChaCha20/Salsa20 constant", b"expand 32-byte k"),
+    ("chacha20", "ChaCha20/Salsa20 constant", b"expand 16-byte k"),
+];
+
+/// well-known 32-bit values, found in instruction immediates:
+/// algorithm, description, and value.
+///
+/// the MD5 and SHA-1 initial hash values are shared, so they're not included.
+const IMMEDIATE_CONSTANTS: [(&str, &str, u32); 12] = [
+    ("md5", "MD5 sine table", 0xD76A_A478),
+    ("md5", "MD5 sine table", 0xE8C7_B756),
+    ("sha1", "SHA-1 round constant", 0x5A82_7999),
+    ("sha1", "SHA-1 round constant", 0x6ED9_EBA1),
+    ("sha1", "SHA-1 initial hash value", 0xC3D2_E1F0),
+    ("sha256", "SHA-256 initial hash value", 0x6A09_E667),
+    ("sha256", "SHA-256 initial hash value", 0xBB67_AE85),
+    ("sha256", "SHA-256 round constant", 0x428A_2F98),
+    ("crc32", "CRC-32 polynomial", 0xEDB8_8320),
+    ("tea", "TEA delta", 0x9E37_79B9),
+    // "expa" and "nd 3", when the constant is built from immediates.
+    ("chacha20", "ChaCha20/Salsa20 constant", 0x6170_7865),
+    ("chacha20", "ChaCha20/Salsa20 constant", 0x3320_646E),
+];
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct CryptoConstant {
+    /// the address of the data, or of the instruction with the immediate.
+    pub rva:         RVA,
+    pub algorithm:   &'static str,
+    pub description: &'static str,
+}
+
+/// Find the well-known cryptographic constants in the data of all sections,
+/// and in the immediates of all instructions, sorted by address.
+///
+/// ```
+/// use lancelot::test;
+/// use lancelot::arch::RVA;
+/// use lancelot::analysis::crypto::{self, CryptoConstant};
+///
+/// // 0: B8 20 83 B8 ED  MOV EAX, 0xEDB88320
+/// // 5: C3              RETN
+/// // 6: "expand 32-byte k"
+/// let mut ws = test::get_shellcode32_workspace(b"\xB8\x20\x83\xB8\xED\xC3expand 32-byte k");
+/// ws.make_function(RVA(0x0)).unwrap();
+/// ws.analyze().unwrap();
+///
+/// let constants = crypto::find_constants(&ws).unwrap();
+/// assert_eq!(
+///     constants,
+///     vec![
+///         CryptoConstant {
+///             rva:         RVA(0x0),
+///             algorithm:   "crc32",
+///             description: "CRC-32 polynomial",
+///         },
+///         CryptoConstant {
+///             rva:         RVA(0x6),
+///             algorithm:   "chacha20",
+///             description: "ChaCha20/Salsa20 constant",
+///         },
+///     ]
+/// );
+/// ```
+pub fn find_constants(ws: &Workspace) -> Result<Vec<CryptoConstant>, Error> {
+    let mut constants = vec![];
+
+    for &(algorithm, description, bytes) in DATA_CONSTANTS.iter() {
+        for rva in ws.search_bytes(bytes, &vec![0xFF; bytes.len()])?.into_iter() {
+            constants.push(CryptoConstant {
+                rva,
+                algorithm,
+                description,
+            });
+        }
+    }
+
+    let immediates: HashMap<u32, (&'static str, &'static str)> = IMMEDIATE_CONSTANTS
+        .iter()
+        .map(|&(algorithm, description, value)| (value, (algorithm, description)))
+        .collect();
+
+    for section in ws.module.sections.iter().filter(|section| section.is_executable()) {
+        let insns: Vec<RVA> = ws
+            .get_metas(section.addr, section.size as usize)?
+            .iter()
+            .enumerate()
+            .filter(|(_, meta)| meta.is_insn())
+            .map(|(j, _)| section.addr + RVA::from(j))
+            .collect();
+
+        for rva in insns.into_iter() {
+            let insn = match ws.read_insn(rva) {
+                Ok(insn) => insn,
+                Err(_) => continue,
+            };
+
+            for op in get_operands(&insn)
+                .into_iter()
+                .filter(|op| op.ty == zydis::OperandType::IMMEDIATE && !op.imm.is_relative)
+            {
+                // constants are 32 bits, though a sign-extended immediate may appear wider.
+                if let Some(&(algorithm, description)) = immediates.get(&(op.imm.value as u32)) {
+                    constants.push(CryptoConstant {
+                        rva,
+                        algorithm,
+                        description,
+                    });
+                }
+            }
+        }
+    }
+
+    constants.sort_by(|a, b| a.rva.cmp(&b.rva));
+    Ok(constants)
+}
+
+/// is the given instruction a loop bound of 0x100, like `CMP EAX, 0x100`?
+fn is_rc4_bound(insn: &zydis::DecodedInstruction) -> bool {
+    insn.mnemonic == zydis::Mnemonic::CMP
+        && insn.operands[1].ty == zydis::OperandType::IMMEDIATE
+        && insn.operands[1].imm.value == 0x100
+}
+
+/// is the given operand an element of a byte array, like `[ECX+EAX]`?
+fn is_byte_element(op: &zydis::DecodedOperand) -> bool {
+    op.ty == zydis::OperandType::MEMORY
+        && op.size == 8
+        && op.mem.base != zydis::Register::NONE
+        && op.mem.index != zydis::Register::NONE
+}
+
+/// is the given instruction a store to a byte array, like
+/// `MOV BYTE PTR [ECX+EAX], DL`?
+fn is_rc4_store(insn: &zydis::DecodedInstruction) -> bool {
+    insn.mnemonic == zydis::Mnemonic::MOV && is_byte_element(&insn.operands[0])
+}
+
+/// is the given instruction a load from a byte array, like
+/// `MOV DL, BYTE PTR [ECX+EAX]` or `MOVZX EDX, BYTE PTR [ECX+EAX]`?
+fn is_rc4_load(insn: &zydis::DecodedInstruction) -> bool {
+    (insn.mnemonic == zydis::Mnemonic::MOV || insn.mnemonic == zydis::Mnemonic::MOVZX)
+        && is_byte_element(&insn.operands[1])
+}
+
+/// the natural loops of a function, as the blocks of each:
+/// for each back edge, which jumps to a block at or before its source,
+/// the header and the blocks that reach the source without passing the header.
+fn get_loops(bbs: &[BasicBlock]) -> Vec<Vec<&BasicBlock>> {
+    let blocks: HashMap<RVA, &BasicBlock> = bbs.iter().map(|bb| (bb.addr, bb)).collect();
+
+    let mut loops = vec![];
+    for bb in bbs.iter() {
+        for &header in bb.successors.iter().filter(|&&succ| succ <= bb.addr) {
+            let mut body: BTreeSet<RVA> = BTreeSet::new();
+            body.insert(header);
+            let mut queue = vec![bb.addr];
+            while let Some(addr) = queue.pop() {
+                if body.insert(addr) {
+                    if let Some(block) = blocks.get(&addr) {
+                        queue.extend(block.predecessors.iter().cloned());
+                    }
+                }
+            }
+            loops.push(body.iter().filter_map(|addr| blocks.get(addr).cloned()).collect());
+        }
+    }
+    loops
+}
+
+/// is the given loop RC4 key scheduling: bounded by 0x100, and swapping two
+/// elements of a byte array, like `S[i], S[j] = S[j], S[i]`?
+fn is_rc4_loop(ws: &Workspace, body: &[&BasicBlock]) -> bool {
+    let mut has_bound = false;
+    let mut loads = 0;
+    let mut stores = 0;
+    for &rva in body.iter().flat_map(|bb| bb.insns.iter()) {
+        if let Ok(insn) = ws.read_insn(rva) {
+            has_bound |= is_rc4_bound(&insn);
+            if is_rc4_load(&insn) {
+                loads += 1;
+            }
+            if is_rc4_store(&insn) {
+                stores += 1;
+            }
+        }
+    }
+    has_bound && loads >= 2 && stores >= 2
+}
+
+pub struct CryptoAnalyzer {}
+
+impl CryptoAnalyzer {
+    #[allow(clippy::new_without_default)]
+    pub fn new() -> CryptoAnalyzer {
+        CryptoAnalyzer {}
+    }
+}
+
+impl Analyzer for CryptoAnalyzer {
+    fn get_name(&self) -> String {
+        "crypto analyzer".to_string()
+    }
+
+    fn get_phase(&self) -> AnalysisPhase {
+        AnalysisPhase::Functions
+    }
+
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::comment::CommentType;
+    /// use lancelot::analysis::Analyzer;
+    /// use lancelot::analysis::crypto::CryptoAnalyzer;
+    ///
+    /// // 0: 31 C0           XOR EAX, EAX
+    /// // 2: 31 DB           XOR EBX, EBX
+    /// // 4: 8A 14 01        MOV DL, BYTE PTR [ECX+EAX]
+    /// // 7: 00 D3           ADD BL, DL
+    /// // 9: 8A 34 19        MOV DH, BYTE PTR [ECX+EBX]
+    /// // C: 88 34 01        MOV BYTE PTR [ECX+EAX], DH
+    /// // F: 88 14 19        MOV BYTE PTR [ECX+EBX], DL
+    /// // 12: 40             INC EAX
+    /// // 13: 3D 00 01 00 00 CMP EAX, 0x100
+    /// // 18: 75 EA          JNZ 0x4
+    /// // 1A: C3             RETN
+    /// //
+    /// // 1B: B8 B9 79 37 9E MOV EAX, 0x9E3779B9
+    /// // 20: C3             RETN
+    /// //
+    /// // 21: 31 C0          XOR EAX, EAX
+    /// // 23: 88 04 01       MOV BYTE PTR [ECX+EAX], AL
+    /// // 26: 40             INC EAX
+    /// // 27: 3D 00 01 00 00 CMP EAX, 0x100
+    /// // 2C: 75 F5          JNZ 0x23
+    /// // 2E: C3             RETN
+    /// let mut ws = test::get_shellcode32_workspace(
+    ///     b"\x31\xC0\x31\xDB\x8A\x14\x01\x00\xD3\x8A\x34\x19\x88\x34\x01\x88\
+    ///       \x14\x19\x40\x3D\x00\x01\x00\x00\x75\xEA\xC3\xB8\xB9\x79\x37\x9E\
+    ///       \xC3\x31\xC0\x88\x04\x01\x40\x3D\x00\x01\x00\x00\x75\xF5\xC3",
+    /// );
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.make_function(RVA(0x1B)).unwrap();
+    /// ws.make_function(RVA(0x21)).unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// CryptoAnalyzer::new().analyze(&mut ws).unwrap();
+    /// assert_eq!(ws.find_tagged("crypto:tea"), vec![RVA(0x1B)]);
+    /// assert_eq!(ws.get_comment(RVA(0x1B), CommentType::Inline).unwrap(), "TEA delta");
+    ///
+    /// // the loop at 0x21 fills a table of 0x100 bytes, but doesn't swap them.
+    /// assert_eq!(ws.find_tagged("crypto:rc4"), vec![RVA(0x0)]);
+    /// ```
+    fn analyze(&self, ws: &mut Workspace) -> Result<(), Error> {
+        let references = find_references(ws)?;
+
+        // index the functions by their instructions,
+        // and recognize RC4 key scheduling along the way.
+        let mut containing: HashMap<RVA, Vec<RVA>> = HashMap::new();
+        let mut tags: BTreeSet<(RVA, String)> = BTreeSet::new();
+        for &function in ws.get_functions() {
+            let bbs = match ws.get_basic_blocks(function) {
+                Ok(bbs) => bbs,
+                Err(_) => continue,
+            };
+
+            for bb in bbs.iter() {
+                for &rva in bb.insns.iter() {
+                    containing.entry(rva).or_insert_with(Vec::new).push(function);
+                }
+            }
+
+            if get_loops(&bbs).iter().any(|body| is_rc4_loop(ws, body)) {
+                tags.insert((function, format!("{}rc4", CRYPTO_TAG_PREFIX)));
+            }
+        }
+
+        let mut comments: Vec<(RVA, CommentType, &str)> = vec![];
+        for constant in find_constants(ws)?.into_iter() {
+            debug!("crypto constant: {}: {}", constant.rva, constant.description);
+            let tag = format!("{}{}", CRYPTO_TAG_PREFIX, constant.algorithm);
+
+            if containing.contains_key(&constant.rva) {
+                // an immediate, so tag the function that contains it.
+                comments.push((constant.rva, CommentType::Inline, constant.description));
+                for &function in containing[&constant.rva].iter() {
+                    tags.insert((function, tag.clone()));
+                }
+            } else {
+                // data, so tag the functions that reference it.
+                comments.push((constant.rva, CommentType::Pre, constant.description));
+                for insn in references.get(&constant.rva).into_iter().flatten() {
+                    for &function in containing.get(insn).into_iter().flatten() {
+                        tags.insert((function, tag.clone()));
+                    }
+                }
+            }
+        }
+
+        for (rva, typ, text) in comments.into_iter() {
+            ws.make_comment(rva, typ, text)?;
+        }
+        for (function, tag) in tags.into_iter() {
+            debug!("crypto function: {}: {}", function, tag);
+            ws.make_tag(function, &tag)?;
+        }
+        ws.analyze()
+    }
+}
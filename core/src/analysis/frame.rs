@@ -0,0 +1,256 @@
+/// recover the stack frame of each function: its locals, saved registers,
+/// and arguments, from the accesses relative to the stack and frame pointers.
+///
+/// the frame is recorded in the function metadata, along with its size
+/// and the number of stack arguments, so that listings can render symbolic
+/// names like `var_8` and `arg_0` rather than `[EBP-0x4]` and `[EBP+0x8]`.
+///
+/// slots are named by their offset from the stack pointer on entry:
+/// locals by their distance below it, and arguments by their distance
+/// above the return address.
+use std::collections::BTreeMap;
+
+use failure::Error;
+use log::debug;
+use zydis;
+
+use super::{
+    super::{
+        arch::RVA,
+        function::{SlotKind, StackFrame, StackSlot},
+        ir::{
+            dataflow::{self, Definition, Location, Site},
+            lift, Stmt, Value,
+        },
+        workspace::Workspace,
+        x86::{get_operands, register_name},
+    },
+    AnalysisPhase, Analyzer,
+};
+
+/// registers whose entry values are preserved by the callee.
+const CALLEE_SAVED: [&str; 12] = [
+    "ebx", "esi", "edi", "ebp", "rbx", "rbp", "rsi", "rdi", "r12", "r13", "r14", "r15",
+];
+
+fn get_slot_name(offset: i64, kind: &SlotKind, pointer_size: i64) -> String {
+    match kind {
+        SlotKind::Local => format!("var_{:x}", -offset),
+        SlotKind::SavedRegister(register) => format!("saved_{}", register),
+        SlotKind::ReturnAddress => "return_address".to_string(),
+        SlotKind::Argument => format!("arg_{:x}", offset - pointer_size),
+    }
+}
+
+/// record an access of the given size to the slot at the given offset.
+fn add_slot(slots: &mut BTreeMap<i64, StackSlot>, offset: i64, size: u8, kind: SlotKind, pointer_size: i64) {
+    let slot = slots.entry(offset).or_insert_with(|| StackSlot {
+        offset,
+        size,
+        name: get_slot_name(offset, &kind, pointer_size),
+        kind,
+    });
+    if size > slot.size {
+        slot.size = size;
+    }
+}
+
+/// Recover the stack frame of the function that starts at the given address.
+///
+/// ```
+/// use lancelot::test;
+/// use lancelot::arch::RVA;
+/// use lancelot::function::SlotKind;
+/// use lancelot::analysis::frame;
+///
+/// // 0: 55        PUSH EBP
+/// // 1: 8B EC     MOV EBP, ESP
+/// // 3: 8B 45 08  MOV EAX, [EBP+0x8]
+/// // 6: 89 45 FC  MOV [EBP-0x4], EAX
+/// // 9: 8B 4D FC  MOV ECX, [EBP-0x4]
+/// // C: 5D        POP EBP
+/// // D: C3        RETN
+/// let mut ws = test::get_shellcode32_workspace(
+///     b"\x55\x8B\xEC\x8B\x45\x08\x89\x45\xFC\x8B\x4D\xFC\x5D\xC3",
+/// );
+/// ws.make_function(RVA(0x0)).unwrap();
+/// ws.analyze().unwrap();
+///
+/// let frame = frame::recover_frame(&ws, RVA(0x0)).unwrap();
+/// let names: Vec<&str> = frame.slots.iter().map(|slot| slot.name.as_str()).collect();
+/// assert_eq!(names, vec!["var_8", "saved_ebp", "arg_0"]);
+/// assert_eq!(frame.get_slot(-0x4).unwrap().kind, SlotKind::SavedRegister("ebp".to_string()));
+/// assert_eq!(frame.get_reference(RVA(0x9)).unwrap().name, "var_8");
+/// assert_eq!(frame.get_frame_size(), 0x8);
+/// assert_eq!(frame.get_argument_count(4), 1);
+/// ```
+///
+/// Errors: same as `get_def_use`.
+pub fn recover_frame(ws: &Workspace, rva: RVA) -> Result<StackFrame, Error> {
+    let arch = ws.loader.get_arch();
+    let pointer_size = i64::from(arch.get_pointer_size());
+
+    let du = ws.get_def_use(rva)?;
+    let offsets = dataflow::get_stack_offsets(&du.function, lift::get_stack_pointer(arch));
+
+    let mut slots: BTreeMap<i64, StackSlot> = BTreeMap::new();
+    let mut references: BTreeMap<RVA, i64> = BTreeMap::new();
+
+    for (&addr, block) in du.function.blocks.iter() {
+        // saved registers: the entry values of callee-saved registers, stored
+        // to the stack, such as by `PUSH EBP`.
+        for (index, (_, stmt)) in block.stmts.iter().enumerate() {
+            let site = Site { block: addr, index };
+            if let (
+                Stmt::Store {
+                    size,
+                    value: Value::Var(var),
+                    ..
+                },
+                Some(&Location::Stack(offset)),
+            ) = (stmt, du.get_def(site))
+            {
+                let location = Location::Var(var.name.clone());
+                if CALLEE_SAVED.contains(&var.name.as_str())
+                    && du.get_reaching_definitions(site, &location) == vec![Definition::Entry]
+                {
+                    let kind = SlotKind::SavedRegister(var.name.clone());
+                    add_slot(&mut slots, offset, *size, kind, pointer_size);
+                }
+            }
+        }
+    }
+
+    for (&addr, block) in du.function.blocks.iter() {
+        // explicit memory operands relative to a register with a known offset,
+        // like `[EBP-0x4]`, using the offsets before the instruction's first
+        // statement.
+        let mut previous: Option<RVA> = None;
+        for (index, &(insn, _)) in block.stmts.iter().enumerate() {
+            if previous == Some(insn) {
+                continue;
+            }
+            previous = Some(insn);
+
+            let state = match offsets.get(&Site { block: addr, index }) {
+                Some(state) => state,
+                None => continue,
+            };
+
+            let decoded = ws.read_insn(insn)?;
+            for op in get_operands(&decoded)
+                .into_iter()
+                .filter(|op| op.ty == zydis::OperandType::MEMORY && op.mem.index == zydis::Register::NONE)
+            {
+                let base = match register_name(op.mem.base).and_then(|base| state.get(&base).cloned()) {
+                    Some(base) => base,
+                    None => continue,
+                };
+                let offset = base + op.mem.disp.displacement;
+
+                let kind = if offset < 0 {
+                    SlotKind::Local
+                } else if offset < pointer_size {
+                    SlotKind::ReturnAddress
+                } else {
+                    SlotKind::Argument
+                };
+                // LEA only computes the address.
+                let size = if decoded.mnemonic == zydis::Mnemonic::LEA {
+                    0
+                } else {
+                    (op.size / 8) as u8
+                };
+
+                add_slot(&mut slots, offset, size, kind, pointer_size);
+                references.insert(insn, offset);
+            }
+        }
+    }
+
+    Ok(StackFrame {
+        slots: slots.into_iter().map(|(_, slot)| slot).collect(),
+        references,
+    })
+}
+
+pub struct FrameAnalyzer {}
+
+impl FrameAnalyzer {
+    #[allow(clippy::new_without_default)]
+    pub fn new() -> FrameAnalyzer {
+        FrameAnalyzer {}
+    }
+}
+
+impl Analyzer for FrameAnalyzer {
+    fn get_name(&self) -> String {
+        "stack frame analyzer".to_string()
+    }
+
+    fn get_phase(&self) -> AnalysisPhase {
+        AnalysisPhase::Functions
+    }
+
+    /// record the stack frame of each function in its metadata,
+    /// along with the frame size and, if not already known, the argument count.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::analysis::Analyzer;
+    /// use lancelot::analysis::frame::FrameAnalyzer;
+    ///
+    /// // 0: 55        PUSH EBP
+    /// // 1: 8B EC     MOV EBP, ESP
+    /// // 3: 8B 45 08  MOV EAX, [EBP+0x8]
+    /// // 6: 89 45 FC  MOV [EBP-0x4], EAX
+    /// // 9: 8B 4D FC  MOV ECX, [EBP-0x4]
+    /// // C: 5D        POP EBP
+    /// // D: C3        RETN
+    /// let mut ws = test::get_shellcode32_workspace(
+    ///     b"\x55\x8B\xEC\x8B\x45\x08\x89\x45\xFC\x8B\x4D\xFC\x5D\xC3",
+    /// );
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// FrameAnalyzer::new().analyze(&mut ws).unwrap();
+    /// let meta = ws.get_function_meta(RVA(0x0)).unwrap();
+    /// assert_eq!(meta.frame_size, Some(0x8));
+    /// assert_eq!(meta.argument_count, Some(1));
+    /// assert_eq!(
+    ///     ws.get_pseudocode(RVA(0x0)).unwrap(),
+    ///     "sub_0:\n    push(ebp)\n    ebp = esp\n    eax = arg_0\n    var_8 = eax\n    \
+    ///      ecx = var_8\n    ebp = pop()\n    return\n"
+    /// );
+    /// ```
+    fn analyze(&self, ws: &mut Workspace) -> Result<(), Error> {
+        let pointer_size = ws.loader.get_arch().get_pointer_size();
+        let functions: Vec<RVA> = ws.get_functions().cloned().collect();
+
+        for function in functions.into_iter() {
+            let frame = match recover_frame(ws, function) {
+                Ok(frame) => frame,
+                Err(e) => {
+                    debug!("failed to recover frame: {}: {}", function, e);
+                    continue;
+                }
+            };
+
+            let mut meta = match ws.get_function_meta(function) {
+                Some(meta) => meta.clone(),
+                None => continue,
+            };
+            meta.frame_size = Some(frame.get_frame_size());
+            let argument_count = frame.get_argument_count(pointer_size);
+            if meta.argument_count.is_none() && argument_count > 0 {
+                meta.argument_count = Some(argument_count);
+            }
+            meta.frame = Some(frame);
+
+            ws.set_function_meta(function, meta)?;
+        }
+
+        Ok(())
+    }
+}
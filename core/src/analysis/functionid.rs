@@ -0,0 +1,205 @@
+//! Recognize library functions by the hash of all their instructions,
+//!  in the style of Ghidra's Function ID, as a complement to FLIRT and
+//!  signatures, which only consider the start of a function.
+//!
+//! Immediates and displacements are masked before hashing (see
+//!  `Workspace::md5_function`), so the hashes do not depend on where the
+//!  function or its data is located.
+//!
+//! Databases are built from workspaces with named functions, like a library
+//!  with symbols, and then applied to other workspaces with the
+//!  `FunctionIdAnalyzer`. The document layout is:
+//!
+//! ```json
+//! {
+//!   "functions": [{"hash": "0f343b0931126a20f133d67c2b018a3b", "name": "memcpy"}]
+//! }
+//! ```
+use std::collections::{BTreeMap, BTreeSet};
+
+use failure::{Error, Fail};
+use log::debug;
+use serde_json::{json, Value};
+
+use super::{
+    super::{arch::RVA, symbol::SymbolSource, workspace::Workspace},
+    pe::flirt::LIBRARY_TAG,
+    AnalysisPhase, Analyzer,
+};
+
+/// the minimum number of instructions in a hashed function,
+/// so that short or generic functions, like thunks, don't produce false
+/// positives.
+pub const MIN_INSTRUCTIONS: usize = 5;
+
+#[derive(Debug, Fail)]
+pub enum FunctionIdError {
+    #[fail(display = "Invalid function ID document")]
+    InvalidDocument,
+}
+
+/// Hash the function at the given address, or return `None` if it is too
+/// short to be recognized reliably.
+fn hash_function(ws: &Workspace, rva: RVA) -> Result<Option<String>, Error> {
+    let count: usize = ws.get_basic_blocks(rva)?.iter().map(|bb| bb.insns.len()).sum();
+    if count < MIN_INSTRUCTIONS {
+        return Ok(None);
+    }
+
+    Ok(Some(ws.md5_function(rva)?))
+}
+
+/// maps function hashes to the names of the functions with that hash.
+#[derive(Default)]
+pub struct FunctionIdDatabase {
+    hashes: BTreeMap<String, BTreeSet<String>>,
+}
+
+impl FunctionIdDatabase {
+    pub fn new() -> FunctionIdDatabase {
+        FunctionIdDatabase::default()
+    }
+
+    pub fn add(&mut self, hash: &str, name: &str) {
+        self.hashes
+            .entry(hash.to_string())
+            .or_insert_with(BTreeSet::new)
+            .insert(name.to_string());
+    }
+
+    /// Add the named functions from the given workspace,
+    ///  and return the number of functions added.
+    pub fn add_workspace(&mut self, ws: &Workspace) -> Result<usize, Error> {
+        let mut count = 0;
+        for &rva in ws.get_functions() {
+            let name = match ws.get_symbol(rva) {
+                Some(name) => name,
+                None => continue,
+            };
+
+            if let Some(hash) = hash_function(ws, rva)? {
+                self.add(&hash, name);
+                count += 1;
+            }
+        }
+
+        debug!("added {} functions to function ID database", count);
+        Ok(count)
+    }
+
+    pub fn lookup(&self, hash: &str) -> Vec<&str> {
+        match self.hashes.get(hash) {
+            Some(names) => names.iter().map(String::as_str).collect(),
+            None => vec![],
+        }
+    }
+
+    pub fn render(&self) -> Value {
+        let functions: Vec<Value> = self
+            .hashes
+            .iter()
+            .flat_map(|(hash, names)| names.iter().map(move |name| json!({"hash": hash, "name": name})))
+            .collect();
+
+        json!({ "functions": functions })
+    }
+
+    /// ```
+    /// use serde_json::json;
+    /// use lancelot::analysis::functionid::FunctionIdDatabase;
+    ///
+    /// let doc = json!({"functions": [{"hash": "0f343b0931126a20f133d67c2b018a3b", "name": "memcpy"}]});
+    /// let db = FunctionIdDatabase::parse(&doc).unwrap();
+    /// assert_eq!(db.lookup("0f343b0931126a20f133d67c2b018a3b"), vec!["memcpy"]);
+    /// assert_eq!(db.render(), doc);
+    /// ```
+    pub fn parse(doc: &Value) -> Result<FunctionIdDatabase, Error> {
+        let functions = doc["functions"].as_array().ok_or(FunctionIdError::InvalidDocument)?;
+
+        let mut db = FunctionIdDatabase::new();
+        for function in functions.iter() {
+            let hash = function["hash"].as_str().ok_or(FunctionIdError::InvalidDocument)?;
+            let name = function["name"].as_str().ok_or(FunctionIdError::InvalidDocument)?;
+            db.add(hash, name);
+        }
+        Ok(db)
+    }
+}
+
+pub struct FunctionIdAnalyzer {
+    db: FunctionIdDatabase,
+}
+
+impl FunctionIdAnalyzer {
+    pub fn new(db: FunctionIdDatabase) -> FunctionIdAnalyzer {
+        FunctionIdAnalyzer { db }
+    }
+}
+
+impl Analyzer for FunctionIdAnalyzer {
+    fn get_name(&self) -> String {
+        "function ID analyzer".to_string()
+    }
+
+    fn get_phase(&self) -> AnalysisPhase {
+        AnalysisPhase::Functions
+    }
+
+    /// name the functions without symbols whose hash matches exactly one
+    /// name, and tag them as library code.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::analysis::Analyzer;
+    /// use lancelot::analysis::functionid::{FunctionIdAnalyzer, FunctionIdDatabase};
+    ///
+    /// // 0: 55              PUSH EBP
+    /// // 1: 8B EC           MOV EBP, ESP
+    /// // 3: B8 01 00 00 00  MOV EAX, 1
+    /// // 8: 5D              POP EBP
+    /// // 9: C3              RETN
+    /// let mut lib = test::get_shellcode32_workspace(b"\x55\x8B\xEC\xB8\x01\x00\x00\x00\x5D\xC3");
+    /// lib.make_function(RVA(0x0)).unwrap();
+    /// lib.make_symbol(RVA(0x0), "one").unwrap();
+    /// lib.analyze().unwrap();
+    ///
+    /// let mut db = FunctionIdDatabase::new();
+    /// assert_eq!(db.add_workspace(&lib).unwrap(), 1);
+    ///
+    /// // same as above, but with MOV EAX, 2
+    /// let mut ws = test::get_shellcode32_workspace(b"\x55\x8B\xEC\xB8\x02\x00\x00\x00\x5D\xC3");
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// FunctionIdAnalyzer::new(db).analyze(&mut ws).unwrap();
+    /// assert_eq!(ws.get_symbol(RVA(0x0)).unwrap(), "one");
+    /// ```
+    fn analyze(&self, ws: &mut Workspace) -> Result<(), Error> {
+        let functions: Vec<RVA> = ws
+            .get_functions()
+            .filter(|&&rva| ws.get_symbol(rva).is_none())
+            .cloned()
+            .collect();
+
+        for rva in functions.into_iter() {
+            let hash = match hash_function(ws, rva)? {
+                Some(hash) => hash,
+                None => continue,
+            };
+
+            let names = self.db.lookup(&hash);
+            match names.len() {
+                0 => {}
+                1 => {
+                    debug!("function ID match: {} {}", rva, names[0]);
+                    ws.make_symbol_from(rva, names[0], SymbolSource::Signature)?;
+                    ws.make_tag(rva, LIBRARY_TAG)?;
+                }
+                _ => debug!("ambiguous function ID match: {}: {:?}", rva, names),
+            }
+        }
+
+        ws.analyze()
+    }
+}
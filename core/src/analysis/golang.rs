@@ -0,0 +1,239 @@
+/// recover the names and addresses of functions in Go binaries from the
+/// pclntab, the table the Go runtime uses to render stack traces.
+///
+/// Go binaries are typically stripped of other symbols, but the runtime
+/// requires the pclntab, so it is present even in release builds.
+///
+/// supports the layouts used by Go 1.2 through 1.20+.
+use failure::Error;
+use log::debug;
+
+use super::{
+    super::{
+        arch::{RVA, VA},
+        symbol::SymbolSource,
+        workspace::Workspace,
+    },
+    Analyzer,
+};
+
+#[derive(Debug, Copy, Clone, PartialEq)]
+enum Version {
+    /// Go 1.2 through 1.15.
+    V12,
+    /// Go 1.16 and 1.17.
+    V116,
+    /// Go 1.18 and later.
+    V118,
+}
+
+fn version_from_magic(magic: u32) -> Option<Version> {
+    match magic {
+        0xFFFF_FFFB => Some(Version::V12),
+        0xFFFF_FFFA => Some(Version::V116),
+        0xFFFF_FFF0 | 0xFFFF_FFF1 => Some(Version::V118),
+        _ => None,
+    }
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct GoFunction {
+    pub rva:  RVA,
+    pub name: String,
+}
+
+/// the pclntab header, and how to read its variable width fields.
+struct Pclntab {
+    rva:     RVA,
+    version: Version,
+    psize:   usize,
+}
+
+impl Pclntab {
+    fn read_uintptr(&self, ws: &Workspace, rva: RVA) -> Result<u64, Error> {
+        if self.psize == 8 {
+            ws.read_u64(rva)
+        } else {
+            Ok(u64::from(ws.read_u32(rva)?))
+        }
+    }
+
+    /// read the `index`th pointer-sized field following the fixed header.
+    fn read_field(&self, ws: &Workspace, index: usize) -> Result<u64, Error> {
+        self.read_uintptr(ws, self.rva + 8 + index * self.psize)
+    }
+
+    fn get_function_count(&self, ws: &Workspace) -> Result<u64, Error> {
+        self.read_field(ws, 0)
+    }
+
+    /// compute the address of the function table, and the base to which
+    /// function name offsets are relative.
+    fn get_tables(&self, ws: &Workspace) -> Result<(RVA, RVA), Error> {
+        match self.version {
+            Version::V12 => Ok((self.rva + 8 + self.psize, self.rva)),
+            Version::V116 => {
+                let names = self.read_field(ws, 2)?;
+                let functab = self.read_field(ws, 6)?;
+                Ok((self.rva + functab as usize, self.rva + names as usize))
+            }
+            Version::V118 => {
+                let names = self.read_field(ws, 3)?;
+                let functab = self.read_field(ws, 7)?;
+                Ok((self.rva + functab as usize, self.rva + names as usize))
+            }
+        }
+    }
+
+    /// read the address of the `index`th function, and the address of its
+    /// `_func` structure.
+    fn read_function_entry(&self, ws: &Workspace, functab: RVA, index: usize) -> Result<(Option<RVA>, RVA), Error> {
+        match self.version {
+            Version::V12 | Version::V116 => {
+                let entry = functab + index * 2 * self.psize;
+                let pc = self.read_uintptr(ws, entry)?;
+                let funcoff = self.read_uintptr(ws, entry + self.psize)?;
+                // in Go 1.2, `funcoff` is relative to the start of the pclntab.
+                let base = if self.version == Version::V12 {
+                    self.rva
+                } else {
+                    functab
+                };
+                Ok((ws.rva(VA::from(pc)), base + funcoff as usize))
+            }
+            Version::V118 => {
+                let entry = functab + index * 8;
+                let text = self.read_field(ws, 2)?;
+                let pc = text + u64::from(ws.read_u32(entry)?);
+                let funcoff = ws.read_u32(entry + 4)?;
+                Ok((ws.rva(VA::from(pc)), functab + funcoff as usize))
+            }
+        }
+    }
+
+    /// read the name offset from the given `_func` structure,
+    /// which follows the entry field.
+    fn read_name_offset(&self, ws: &Workspace, func: RVA) -> Result<i32, Error> {
+        match self.version {
+            Version::V12 | Version::V116 => ws.read_i32(func + self.psize),
+            Version::V118 => ws.read_i32(func + 4),
+        }
+    }
+}
+
+/// check that the header at the given address looks like a pclntab,
+/// and that its first function is in executable memory.
+fn parse_header(ws: &Workspace, rva: RVA) -> Option<Pclntab> {
+    let version = version_from_magic(ws.read_u32(rva).ok()?)?;
+    let quantum = ws.read_u8(rva + 6).ok()?;
+    let psize = ws.read_u8(rva + 7).ok()? as usize;
+
+    if ![1, 2, 4].contains(&quantum) || psize != ws.loader.get_arch().get_pointer_size() as usize {
+        return None;
+    }
+
+    let pclntab = Pclntab { rva, version, psize };
+    let count = pclntab.get_function_count(ws).ok()?;
+    if count == 0 || count > 0x10_0000 {
+        return None;
+    }
+
+    let (functab, _) = pclntab.get_tables(ws).ok()?;
+    let (entry, _) = pclntab.read_function_entry(ws, functab, 0).ok()?;
+    if !ws.get_section(entry?)?.is_executable() {
+        return None;
+    }
+
+    Some(pclntab)
+}
+
+/// Find the address of the pclntab, if this is a Go binary.
+pub fn find_pclntab(ws: &Workspace) -> Option<RVA> {
+    // the magics are 0xFFFFFFF0 through 0xFFFFFFFB, followed by two zero bytes.
+    let candidates = ws
+        .search_bytes(b"\xF0\xFF\xFF\xFF\x00\x00", b"\xF0\xFF\xFF\xFF\xFF\xFF")
+        .ok()?;
+
+    candidates.into_iter().find(|&rva| parse_header(ws, rva).is_some())
+}
+
+/// Read the functions described by the pclntab at the given address.
+///
+/// ```
+/// use lancelot::test;
+/// use lancelot::arch::RVA;
+/// use lancelot::analysis::golang::{self, GoFunction};
+///
+/// // 0x00: C3                       RETN
+/// // 0x10: FB FF FF FF 00 00 01 04  Go 1.2 magic, quantum: 1, ptrsize: 4
+/// // 0x18: 01 00 00 00              function count: 1
+/// // 0x1C: 00 00 00 00 18 00 00 00  entry: 0x0, _func offset: 0x18
+/// // 0x24: 01 00 00 00              end of last function
+/// // 0x28: 00 00 00 00 20 00 00 00  _func: entry: 0x0, name offset: 0x20
+/// // 0x30: "main.main"
+/// let ws = test::get_shellcode32_workspace(
+///     b"\xC3\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\
+///       \xFB\xFF\xFF\xFF\x00\x00\x01\x04\x01\x00\x00\x00\
+///       \x00\x00\x00\x00\x18\x00\x00\x00\x01\x00\x00\x00\
+///       \x00\x00\x00\x00\x20\x00\x00\x00main.main\x00",
+/// );
+/// let pclntab = golang::find_pclntab(&ws).unwrap();
+/// assert_eq!(pclntab, RVA(0x10));
+/// assert_eq!(
+///     golang::get_functions(&ws, pclntab).unwrap(),
+///     vec![GoFunction { rva: RVA(0x0), name: "main.main".to_string() }]
+/// );
+/// ```
+pub fn get_functions(ws: &Workspace, rva: RVA) -> Result<Vec<GoFunction>, Error> {
+    let pclntab = match parse_header(ws, rva) {
+        Some(pclntab) => pclntab,
+        None => return Ok(vec![]),
+    };
+
+    let count = pclntab.get_function_count(ws)? as usize;
+    let (functab, names) = pclntab.get_tables(ws)?;
+    debug!("pclntab: {} {:?} with {} functions", rva, pclntab.version, count);
+
+    let mut functions = vec![];
+    for i in 0..count {
+        let (entry, func) = pclntab.read_function_entry(ws, functab, i)?;
+        let entry = match entry {
+            Some(entry) => entry,
+            None => continue,
+        };
+
+        let name = ws.read_utf8(names + RVA::from(pclntab.read_name_offset(ws, func)?))?;
+        functions.push(GoFunction { rva: entry, name });
+    }
+
+    Ok(functions)
+}
+
+pub struct GoPclntabAnalyzer {}
+
+impl GoPclntabAnalyzer {
+    #[allow(clippy::new_without_default)]
+    pub fn new() -> GoPclntabAnalyzer {
+        GoPclntabAnalyzer {}
+    }
+}
+
+impl Analyzer for GoPclntabAnalyzer {
+    fn get_name(&self) -> String {
+        "Go pclntab analyzer".to_string()
+    }
+
+    fn analyze(&self, ws: &mut Workspace) -> Result<(), Error> {
+        let pclntab = match find_pclntab(ws) {
+            Some(pclntab) => pclntab,
+            None => return Ok(()),
+        };
+
+        for function in get_functions(ws, pclntab)?.into_iter() {
+            debug!("Go function: {} {}", function.rva, function.name);
+            ws.make_function_from(function.rva, &self.get_name())?;
+            ws.make_symbol_from(function.rva, &function.name, SymbolSource::DebugInfo)?;
+        }
+        ws.analyze()
+    }
+}
@@ -0,0 +1,121 @@
+/// record the MD5 and SHA-256 hashes of the mapped image, its sections,
+/// and its functions,
+/// so that they can be deduplicated and correlated across samples
+/// without recomputing them, see the `hashes` module.
+///
+/// the image and section hashes are properties of the module,
+/// while the hash of each function is recorded in its metadata.
+use failure::Error;
+use log::debug;
+
+use super::{
+    super::{arch::RVA, workspace::Workspace},
+    AnalysisPhase, Analyzer,
+};
+
+/// the name of the module property that records the MD5 of the mapped image.
+pub const IMAGE_MD5_PROPERTY: &str = "image md5";
+/// the name of the module property that records the SHA-256 of the mapped
+/// image.
+pub const IMAGE_SHA256_PROPERTY: &str = "image sha256";
+/// the prefix of the module properties that record the MD5 of each section,
+/// like `section md5 .text`.
+pub const SECTION_MD5_PROPERTY_PREFIX: &str = "section md5 ";
+/// the prefix of the module properties that record the SHA-256 of each
+/// section, like `section sha256 .text`.
+pub const SECTION_SHA256_PROPERTY_PREFIX: &str = "section sha256 ";
+
+pub struct ImageHashAnalyzer {}
+
+impl ImageHashAnalyzer {
+    #[allow(clippy::new_without_default)]
+    pub fn new() -> ImageHashAnalyzer {
+        ImageHashAnalyzer {}
+    }
+}
+
+impl Analyzer for ImageHashAnalyzer {
+    fn get_name(&self) -> String {
+        "image hash analyzer".to_string()
+    }
+
+    fn get_phase(&self) -> AnalysisPhase {
+        AnalysisPhase::Functions
+    }
+
+    /// record the hashes of the image and each section as properties of the
+    /// module, and the hash of each function in its metadata.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::analysis::Analyzer;
+    /// use lancelot::analysis::hashes::{self, ImageHashAnalyzer};
+    ///
+    /// // 0: B8 01 00 00 00  MOV EAX, 1
+    /// // 5: C3              RETN
+    /// let mut ws = test::get_shellcode32_workspace(b"\xB8\x01\x00\x00\x00\xC3");
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// ImageHashAnalyzer::new().analyze(&mut ws).unwrap();
+    /// assert_eq!(ws.get_property(hashes::IMAGE_MD5_PROPERTY).unwrap(), &ws.md5_image().unwrap());
+    /// assert_eq!(ws.get_property(hashes::IMAGE_SHA256_PROPERTY).unwrap(), &ws.sha256_image().unwrap());
+    ///
+    /// let section = &ws.module.sections[0];
+    /// let name = format!("{}{}", hashes::SECTION_MD5_PROPERTY_PREFIX, section.name);
+    /// assert_eq!(ws.get_property(&name).unwrap(), &ws.md5_section(section).unwrap());
+    /// let name = format!("{}{}", hashes::SECTION_SHA256_PROPERTY_PREFIX, section.name);
+    /// assert_eq!(ws.get_property(&name).unwrap(), &ws.sha256_section(section).unwrap());
+    ///
+    /// let meta = ws.get_function_meta(RVA(0x0)).unwrap();
+    /// assert_eq!(meta.md5, Some(ws.md5_function(RVA(0x0)).unwrap()));
+    /// assert_eq!(meta.sha256, Some(ws.sha256_function(RVA(0x0)).unwrap()));
+    /// ```
+    fn analyze(&self, ws: &mut Workspace) -> Result<(), Error> {
+        let md5 = ws.md5_image()?;
+        ws.set_property(IMAGE_MD5_PROPERTY, &md5);
+        let sha256 = ws.sha256_image()?;
+        ws.set_property(IMAGE_SHA256_PROPERTY, &sha256);
+
+        let mut sections: Vec<(String, String)> = vec![];
+        for section in ws.module.sections.iter() {
+            sections.push((
+                format!("{}{}", SECTION_MD5_PROPERTY_PREFIX, section.name),
+                ws.md5_section(section)?,
+            ));
+            sections.push((
+                format!("{}{}", SECTION_SHA256_PROPERTY_PREFIX, section.name),
+                ws.sha256_section(section)?,
+            ));
+        }
+        for (name, hash) in sections.into_iter() {
+            ws.set_property(&name, &hash);
+        }
+
+        let functions: Vec<RVA> = ws.get_functions().cloned().collect();
+        for function in functions.into_iter() {
+            let (md5, sha256) = match ws
+                .md5_function(function)
+                .and_then(|md5| Ok((md5, ws.sha256_function(function)?)))
+            {
+                Ok(hashes) => hashes,
+                Err(e) => {
+                    debug!("failed to hash function: {}: {}", function, e);
+                    continue;
+                }
+            };
+
+            let mut meta = match ws.get_function_meta(function) {
+                Some(meta) => meta.clone(),
+                None => continue,
+            };
+            meta.md5 = Some(md5);
+            meta.sha256 = Some(sha256);
+
+            ws.set_function_meta(function, meta)?;
+        }
+
+        Ok(())
+    }
+}
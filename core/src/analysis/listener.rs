@@ -0,0 +1,251 @@
+use std::{collections::HashSet, time::Duration};
+
+use failure::Error;
+
+use super::super::{
+    arch::RVA,
+    strings::RecoveredString,
+    xref::{Xref, XrefType},
+};
+
+/// Receives notifications as the analysis discovers new artifacts,
+///  so that a consumer (like a UI) can update incrementally
+///  rather than polling the workspace.
+///
+/// Each `on_new_*` method is invoked once per artifact, the first time it is
+/// added. The `on_pass_*` methods are invoked as each analyzer finishes,
+/// followed by `on_progress` while loading a workspace.
+/// `on_symbol_renamed` is invoked when an existing symbol gets a new name,
+/// such as by the user, or by a source with a higher priority.
+/// `on_bytes_changed` is invoked when the bytes of the module are modified,
+/// such as by a patch, after the analysis of the affected code is invalidated.
+/// All methods default to doing nothing, so implement only the ones you need.
+pub trait AnalysisListener {
+    fn on_new_function(&mut self, _rva: RVA) {}
+    fn on_new_xref(&mut self, _xref: &Xref) {}
+    fn on_new_symbol(&mut self, _rva: RVA, _name: &str) {}
+    fn on_new_string(&mut self, _s: &RecoveredString) {}
+    fn on_symbol_renamed(&mut self, _rva: RVA, _previous: &str, _name: &str) {}
+    fn on_pass_completed(&mut self, _name: &str) {}
+    fn on_pass_failed(&mut self, _name: &str, _error: &Error) {}
+    fn on_progress(&mut self, _progress: &Progress) {}
+    fn on_bytes_changed(&mut self, _rva: RVA, _length: usize) {}
+}
+
+/// How far the analyzers run while loading a workspace have gotten.
+#[derive(Debug, Clone, Copy)]
+pub struct Progress {
+    /// the number of analyzers that have run, successfully or not.
+    pub completed: usize,
+    /// the number of analyzers that will run.
+    pub total:     usize,
+    /// the time spent running the completed analyzers.
+    pub elapsed:   Duration,
+}
+
+impl Progress {
+    /// The fraction of analyzers that have run, from 0.0 to 1.0.
+    pub fn fraction(&self) -> f64 {
+        if self.total == 0 {
+            return 1.0;
+        }
+        self.completed as f64 / self.total as f64
+    }
+
+    /// Estimate the time until the remaining analyzers complete,
+    ///  assuming each takes the average time of the completed analyzers.
+    ///
+    /// This is rough, since analyzers vary widely in cost.
+    ///
+    /// ```
+    /// use std::time::Duration;
+    /// use lancelot::analysis::listener::Progress;
+    ///
+    /// let progress = Progress {
+    ///     completed: 2,
+    ///     total:     5,
+    ///     elapsed:   Duration::from_secs(4),
+    /// };
+    /// assert_eq!(progress.fraction(), 0.4);
+    /// assert_eq!(progress.remaining(), Some(Duration::from_secs(6)));
+    ///
+    /// let progress = Progress {
+    ///     completed: 0,
+    ///     total:     5,
+    ///     elapsed:   Duration::from_secs(0),
+    /// };
+    /// assert_eq!(progress.remaining(), None);
+    /// ```
+    pub fn remaining(&self) -> Option<Duration> {
+        if self.completed == 0 {
+            return None;
+        }
+        let per_pass = self.elapsed / self.completed as u32;
+        Some(per_pass * (self.total.saturating_sub(self.completed)) as u32)
+    }
+}
+
+#[derive(Debug, Copy, Clone, Hash, PartialEq, Eq)]
+pub enum ArtifactType {
+    Function,
+    Xref,
+    Symbol,
+    String,
+}
+
+/// Wraps another listener, and forwards only the artifacts that pass the
+/// configured filters. By default, everything is forwarded.
+/// Notifications about analyzer passes and progress are always forwarded,
+/// and changes to the bytes are forwarded when they overlap the range.
+///
+/// This is useful to keep a sink manageable when analyzing large modules,
+///  for example, by dropping fallthrough xrefs entirely.
+///
+/// ```
+/// use std::sync::mpsc;
+/// use lancelot::test;
+/// use lancelot::arch::RVA;
+/// use lancelot::xref::{Xref, XrefType};
+/// use lancelot::analysis::{AnalysisListener, listener::{ArtifactType, FilteredListener}};
+///
+/// struct XrefCollector(mpsc::Sender<Xref>);
+///
+/// impl AnalysisListener for XrefCollector {
+///     fn on_new_xref(&mut self, xref: &Xref) {
+///         self.0.send(*xref).unwrap();
+///     }
+/// }
+///
+/// // 0: 75 01  JNZ $+3
+/// // 2: 90     NOP
+/// // 3: C3     RETN
+/// let mut ws = test::get_shellcode32_workspace(b"\x75\x01\x90\xC3");
+/// let (tx, rx) = mpsc::channel();
+/// ws.add_listener(Box::new(
+///     FilteredListener::new(XrefCollector(tx))
+///         .with_artifact_types(&[ArtifactType::Xref])
+///         .with_xref_types(&[XrefType::ConditionalJump])
+///         .with_range(RVA(0x0), RVA(0x2)),
+/// ));
+/// ws.make_function(RVA(0x0)).unwrap();
+/// ws.analyze().unwrap();
+///
+/// let xrefs: Vec<Xref> = rx.try_iter().collect();
+/// assert_eq!(xrefs.len(), 1);
+/// assert_eq!(xrefs[0].dst, RVA(0x3));
+/// ```
+pub struct FilteredListener<L: AnalysisListener> {
+    inner:          L,
+    // [start, end)
+    range:          Option<(RVA, RVA)>,
+    artifact_types: Option<HashSet<ArtifactType>>,
+    xref_types:     Option<HashSet<XrefType>>,
+}
+
+impl<L: AnalysisListener> FilteredListener<L> {
+    pub fn new(inner: L) -> FilteredListener<L> {
+        FilteredListener {
+            inner,
+            range: None,
+            artifact_types: None,
+            xref_types: None,
+        }
+    }
+
+    /// Forward only artifacts at addresses within `[start, end)`.
+    /// Xrefs are matched by their source address.
+    pub fn with_range(self, start: RVA, end: RVA) -> FilteredListener<L> {
+        FilteredListener {
+            range: Some((start, end)),
+            ..self
+        }
+    }
+
+    /// Forward only the given types of artifacts.
+    pub fn with_artifact_types(self, types: &[ArtifactType]) -> FilteredListener<L> {
+        FilteredListener {
+            artifact_types: Some(types.iter().cloned().collect()),
+            ..self
+        }
+    }
+
+    /// Forward only xrefs of the given types.
+    pub fn with_xref_types(self, types: &[XrefType]) -> FilteredListener<L> {
+        FilteredListener {
+            xref_types: Some(types.iter().cloned().collect()),
+            ..self
+        }
+    }
+
+    fn is_match(&self, typ: ArtifactType, rva: RVA) -> bool {
+        if let Some((start, end)) = self.range {
+            if rva < start || rva >= end {
+                return false;
+            }
+        }
+
+        match &self.artifact_types {
+            Some(types) => types.contains(&typ),
+            None => true,
+        }
+    }
+}
+
+impl<L: AnalysisListener> AnalysisListener for FilteredListener<L> {
+    fn on_new_function(&mut self, rva: RVA) {
+        if self.is_match(ArtifactType::Function, rva) {
+            self.inner.on_new_function(rva);
+        }
+    }
+
+    fn on_new_xref(&mut self, xref: &Xref) {
+        if let Some(types) = &self.xref_types {
+            if !types.contains(&xref.typ) {
+                return;
+            }
+        }
+
+        if self.is_match(ArtifactType::Xref, xref.src) {
+            self.inner.on_new_xref(xref);
+        }
+    }
+
+    fn on_new_symbol(&mut self, rva: RVA, name: &str) {
+        if self.is_match(ArtifactType::Symbol, rva) {
+            self.inner.on_new_symbol(rva, name);
+        }
+    }
+
+    fn on_new_string(&mut self, s: &RecoveredString) {
+        if self.is_match(ArtifactType::String, s.rva) {
+            self.inner.on_new_string(s);
+        }
+    }
+
+    fn on_symbol_renamed(&mut self, rva: RVA, previous: &str, name: &str) {
+        if self.is_match(ArtifactType::Symbol, rva) {
+            self.inner.on_symbol_renamed(rva, previous, name);
+        }
+    }
+
+    fn on_pass_completed(&mut self, name: &str) {
+        self.inner.on_pass_completed(name);
+    }
+
+    fn on_pass_failed(&mut self, name: &str, error: &Error) {
+        self.inner.on_pass_failed(name, error);
+    }
+
+    fn on_progress(&mut self, progress: &Progress) {
+        self.inner.on_progress(progress);
+    }
+
+    fn on_bytes_changed(&mut self, rva: RVA, length: usize) {
+        if let Some((start, end)) = self.range {
+            if rva + length <= start || rva >= end {
+                return;
+            }
+        }
+        self.inner.on_bytes_changed(rva, length);
+    }
+}
@@ -1,28 +1,51 @@
 use std::{
     collections::{HashMap, HashSet, VecDeque},
     fmt::Display,
+    time::Instant,
 };
 
 use failure::{bail, Error, Fail};
-use log::{debug, trace, warn};
+use log::{debug, info, trace, warn};
 use serde_json;
 use zydis;
 
 use super::{
     arch::{RVA, VA},
+    comment::CommentType,
     flowmeta::{self, FlowMeta},
+    function::{Function, FunctionMeta, CALL_TARGET_SOURCE},
     loader::{LoadedModule, Permissions},
     pagemap::{self, PageMap},
+    strings::RecoveredString,
+    symbol::{SymbolCandidate, SymbolSource},
+    types::{Prototype, TypeLibrary},
     util,
     workspace::Workspace,
     xref::{Xref, XrefType},
 };
 
+pub mod apihashes;
+use apihashes::ApiHashDatabase;
 pub mod config;
+pub mod crypto;
+pub mod frame;
+pub mod functionid;
+pub mod golang;
+pub use golang::GoPclntabAnalyzer;
+pub mod hashes;
+pub mod listener;
+pub use listener::AnalysisListener;
+pub mod names;
 pub mod orphans;
 pub use orphans::OrphanFunctionAnalyzer;
 
 pub mod pe;
+pub mod signatures;
+pub use signatures::SignatureAnalyzer;
+pub mod stackstrings;
+pub mod strings;
+pub use strings::StringAnalyzer;
+pub mod yara;
 
 #[derive(Debug, Fail)]
 pub enum AnalysisError {
@@ -32,14 +55,41 @@ pub enum AnalysisError {
     NotSupported,
     #[fail(display = "foo")]
     InvalidInstruction,
+    #[fail(display = "Unsupported operand at {}", _0)]
+    UnsupportedOperand(RVA),
+    #[fail(display = "No function at {}", _0)]
+    NotAFunction(RVA),
+    #[fail(display = "Analyzer dependency cycle involving {}", _0)]
+    DependencyCycle(String),
 }
 
 #[derive(Debug, Clone)]
 pub enum AnalysisCommand {
     MakeInsn(RVA),
     MakeXref(Xref),
-    MakeSymbol { rva: RVA, name: String },
-    MakeFunction(RVA),
+    MakeSymbol {
+        rva:    RVA,
+        name:   String,
+        source: SymbolSource,
+    },
+    RenameSymbol {
+        rva:  RVA,
+        name: String,
+    },
+    MakeFunction {
+        rva:    RVA,
+        source: Option<String>,
+    },
+    MakeComment {
+        rva:  RVA,
+        typ:  CommentType,
+        text: String,
+    },
+    MakeTag {
+        rva: RVA,
+        tag: String,
+    },
+    MakeString(RecoveredString),
 }
 
 impl Display for AnalysisCommand {
@@ -47,8 +97,17 @@ impl Display for AnalysisCommand {
         match self {
             AnalysisCommand::MakeInsn(rva) => write!(f, "MakeInsn({})", rva),
             AnalysisCommand::MakeXref(x) => write!(f, "MakeXref({:?})", x),
-            AnalysisCommand::MakeSymbol { rva, name } => write!(f, "MakeSymbol({}, {})", rva, name),
-            AnalysisCommand::MakeFunction(rva) => write!(f, "MakeFunction({})", rva),
+            AnalysisCommand::MakeSymbol { rva, name, source } => {
+                write!(f, "MakeSymbol({}, {}, {})", rva, name, source.name())
+            }
+            AnalysisCommand::RenameSymbol { rva, name } => write!(f, "RenameSymbol({}, {})", rva, name),
+            AnalysisCommand::MakeFunction { rva, source } => match source {
+                Some(source) => write!(f, "MakeFunction({}, {})", rva, source),
+                None => write!(f, "MakeFunction({})", rva),
+            },
+            AnalysisCommand::MakeComment { rva, typ, text } => write!(f, "MakeComment({}, {:?}, {})", rva, typ, text),
+            AnalysisCommand::MakeTag { rva, tag } => write!(f, "MakeTag({}, {})", rva, tag),
+            AnalysisCommand::MakeString(s) => write!(f, "MakeString({}, {:?})", s.rva, s.text),
         }
     }
 }
@@ -87,9 +146,10 @@ pub fn get_first_operand(insn: &zydis::DecodedInstruction) -> Option<&zydis::Dec
         .find(|op| op.visibility == zydis::OperandVisibility::EXPLICIT)
 }
 
-fn print_op(op: &zydis::DecodedOperand) {
-    let s = serde_json::to_string(op).unwrap();
-    println!("op: {}", s);
+fn log_op(rva: RVA, op: &zydis::DecodedOperand) {
+    if let Ok(s) = serde_json::to_string(op) {
+        debug!("{}: op: {}", rva, s);
+    }
 }
 
 pub struct XrefAnalysis {
@@ -112,12 +172,42 @@ pub struct Analysis {
     queue: VecDeque<AnalysisCommand>,
 
     // TODO: FNV
-    pub functions: HashSet<RVA>,
+    pub functions: HashMap<RVA, FunctionMeta>,
 
     // TODO: FNV
     pub symbols: HashMap<RVA, String>,
 
+    // TODO: FNV
+    // all the names proposed for each address, in the order found,
+    // including the current symbol.
+    pub symbol_candidates: HashMap<RVA, Vec<SymbolCandidate>>,
+
+    // TODO: FNV
+    // the source of the current symbol at each address.
+    pub symbol_sources: HashMap<RVA, SymbolSource>,
+
+    // TODO: FNV
+    pub comments: HashMap<(RVA, CommentType), String>,
+
+    // TODO: FNV
+    pub tags: HashMap<RVA, HashSet<String>>,
+
+    // TODO: FNV
+    pub strings: HashMap<RVA, RecoveredString>,
+
+    // properties of the module as a whole, like its imphash, by name.
+    pub properties: HashMap<String, String>,
+
+    // TODO: FNV
+    // the error met while resolving the flow of each instruction,
+    // whose unresolved flow was dropped.
+    pub errors: HashMap<RVA, String>,
+
+    pub types: TypeLibrary,
+
     pub flow: FlowAnalysis,
+
+    listeners: Vec<Box<dyn AnalysisListener>>,
     /* datameta
      * symbols
      * functions */
@@ -136,16 +226,25 @@ impl Analysis {
         }
 
         Analysis {
-            queue:     VecDeque::new(),
-            functions: HashSet::new(),
-            symbols:   HashMap::new(),
-            flow:      FlowAnalysis {
+            queue:             VecDeque::new(),
+            functions:         HashMap::new(),
+            symbols:           HashMap::new(),
+            symbol_candidates: HashMap::new(),
+            symbol_sources:    HashMap::new(),
+            comments:          HashMap::new(),
+            tags:              HashMap::new(),
+            strings:           HashMap::new(),
+            properties:        HashMap::new(),
+            errors:            HashMap::new(),
+            types:             TypeLibrary::new(),
+            flow:              FlowAnalysis {
                 meta,
                 xrefs: XrefAnalysis {
                     to:   HashMap::new(),
                     from: HashMap::new(),
                 },
             },
+            listeners:         vec![],
         }
     }
 }
@@ -172,6 +271,68 @@ impl Workspace {
         Ok(())
     }
 
+    /// Forget the instructions whose bytes overlap the given range, along with
+    /// their flow, and queue them to be analyzed again, such as after the bytes
+    /// have been patched. The listeners are notified of the change.
+    ///
+    /// The flow into the range from other instructions is kept, and so is the
+    /// code that was only reachable via the forgotten flow.
+    /// Use `analyze` to re-analyze the instructions.
+    ///
+    /// Returns the addresses of the forgotten instructions.
+    pub fn invalidate_code(&mut self, rva: RVA, length: usize) -> Vec<RVA> {
+        // instructions are at most 15 bytes long,
+        // so one that starts up to 14 bytes before the range may overlap it.
+        let start: usize = rva.into();
+        let start = start.saturating_sub(0xE);
+        let end: usize = (rva + length).into();
+
+        let stale: Vec<(RVA, u8)> = (start..end)
+            .map(RVA::from)
+            .filter_map(|addr| self.get_insn_length(addr).ok().map(|length| (addr, length)))
+            .filter(|&(addr, length)| addr + length > rva)
+            .collect();
+
+        for &(addr, length) in stale.iter() {
+            debug!("invalidating instruction: {}", addr);
+
+            let meta = self.get_meta(addr).expect("flowmeta not in section");
+            if meta.does_fallthrough() {
+                if let Some(next) = self.get_meta_mut(addr + length) {
+                    next.unset_other_fallthrough_to();
+                }
+            }
+
+            if let Some(xrefs) = self.analysis.flow.xrefs.from.remove(&addr) {
+                for xref in xrefs.iter() {
+                    let is_empty = match self.analysis.flow.xrefs.to.get_mut(&xref.dst) {
+                        Some(to) => {
+                            to.remove(xref);
+                            to.is_empty()
+                        }
+                        None => false,
+                    };
+                    if is_empty {
+                        self.analysis.flow.xrefs.to.remove(&xref.dst);
+                        if let Some(dst) = self.get_meta_mut(xref.dst) {
+                            dst.unset_xrefs_to();
+                        }
+                    }
+                }
+            }
+            self.analysis.errors.remove(&addr);
+
+            self.get_meta_mut(addr).expect("flowmeta not in section").unset_insn();
+            self.analysis.queue.push_back(AnalysisCommand::MakeInsn(addr));
+        }
+
+        for listener in self.analysis.listeners.iter_mut() {
+            listener.on_bytes_changed(rva, length);
+        }
+
+        stale.into_iter().map(|(addr, _)| addr).collect()
+    }
+
     /// ```
     /// use lancelot::test;
     /// use lancelot::arch::RVA;
@@ -184,15 +345,256 @@ impl Workspace {
     /// assert_eq!(ws.get_symbol(RVA(0x0)).unwrap(), "entry");
     /// ```
     pub fn make_symbol(&mut self, rva: RVA, name: &str) -> Result<(), Error> {
+        self.make_symbol_from(rva, name, SymbolSource::Analysis)
+    }
+
+    /// Propose a name for the given address from the given source.
+    /// It replaces the existing symbol only if the source has a higher
+    ///  priority than the source of the existing symbol; see `symbol`.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::symbol::SymbolSource;
+    ///
+    /// // JMP $+0;
+    /// let mut ws = test::get_shellcode32_workspace(b"\xEB\xFE");
+    /// ws.make_symbol_from(RVA(0x0), "fn_spin", SymbolSource::Heuristic).unwrap();
+    /// ws.make_symbol_from(RVA(0x0), "_spin", SymbolSource::DebugInfo).unwrap();
+    /// ws.make_symbol_from(RVA(0x0), "spin", SymbolSource::Export).unwrap();
+    /// ws.analyze().unwrap();
+    /// assert_eq!(ws.get_symbol(RVA(0x0)).unwrap(), "_spin");
+    /// assert_eq!(ws.get_symbol_source(RVA(0x0)).unwrap(), SymbolSource::DebugInfo);
+    ///
+    /// let names: Vec<&str> = ws
+    ///     .get_symbol_candidates(RVA(0x0))
+    ///     .iter()
+    ///     .map(|candidate| candidate.name.as_str())
+    ///     .collect();
+    /// assert_eq!(names, vec!["_spin", "spin", "fn_spin"]);
+    /// ```
+    ///
+    /// When a name replaces the existing symbol, the listeners are notified:
+    ///
+    /// ```
+    /// use std::sync::mpsc;
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::symbol::SymbolSource;
+    /// use lancelot::analysis::AnalysisListener;
+    ///
+    /// struct RenameCollector(mpsc::Sender<String>);
+    ///
+    /// impl AnalysisListener for RenameCollector {
+    ///     fn on_symbol_renamed(&mut self, _rva: RVA, _previous: &str, name: &str) {
+    ///         self.0.send(name.to_string()).unwrap();
+    ///     }
+    /// }
+    ///
+    /// // JMP $+0;
+    /// let mut ws = test::get_shellcode32_workspace(b"\xEB\xFE");
+    /// let (tx, rx) = mpsc::channel();
+    /// ws.add_listener(Box::new(RenameCollector(tx)));
+    /// ws.make_symbol_from(RVA(0x0), "fn_spin", SymbolSource::Heuristic).unwrap();
+    /// ws.make_symbol_from(RVA(0x0), "_spin", SymbolSource::DebugInfo).unwrap();
+    /// ws.make_symbol_from(RVA(0x0), "spin", SymbolSource::Export).unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// assert_eq!(rx.try_iter().collect::<Vec<_>>(), vec!["_spin"]);
+    /// ```
+    pub fn make_symbol_from(&mut self, rva: RVA, name: &str, source: SymbolSource) -> Result<(), Error> {
         self.analysis.queue.push_back(AnalysisCommand::MakeSymbol {
             rva,
             name: name.to_string(),
+            source,
+        });
+        Ok(())
+    }
+
+    /// Set the name of the given address, replacing any existing symbol.
+    /// Unlike `make_symbol`, which keeps the first name found by the analysis,
+    ///  this is meant for names chosen by the user.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    ///
+    /// // JMP $+0;
+    /// let mut ws = test::get_shellcode32_workspace(b"\xEB\xFE");
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.analyze().unwrap();
+    /// assert_eq!(ws.get_name(RVA(0x0)), "sub_0");
+    ///
+    /// ws.make_symbol(RVA(0x0), "entry").unwrap();
+    /// ws.make_symbol(RVA(0x0), "start").unwrap();
+    /// ws.analyze().unwrap();
+    /// assert_eq!(ws.get_name(RVA(0x0)), "entry");
+    ///
+    /// ws.rename_symbol(RVA(0x0), "spin").unwrap();
+    /// ws.analyze().unwrap();
+    /// assert_eq!(ws.get_name(RVA(0x0)), "spin");
+    /// assert_eq!(ws.get_name(RVA(0x1)), "loc_1");
+    /// ```
+    ///
+    /// Listeners are notified of the new name:
+    ///
+    /// ```
+    /// use std::sync::mpsc;
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::analysis::AnalysisListener;
+    ///
+    /// struct RenameCollector(mpsc::Sender<(RVA, String, String)>);
+    ///
+    /// impl AnalysisListener for RenameCollector {
+    ///     fn on_symbol_renamed(&mut self, rva: RVA, previous: &str, name: &str) {
+    ///         self.0.send((rva, previous.to_string(), name.to_string())).unwrap();
+    ///     }
+    /// }
+    ///
+    /// // JMP $+0;
+    /// let mut ws = test::get_shellcode32_workspace(b"\xEB\xFE");
+    /// let (tx, rx) = mpsc::channel();
+    /// ws.add_listener(Box::new(RenameCollector(tx)));
+    /// ws.make_symbol(RVA(0x0), "entry").unwrap();
+    /// ws.analyze().unwrap();
+    /// ws.rename_symbol(RVA(0x0), "spin").unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// assert_eq!(
+    ///     rx.try_iter().collect::<Vec<_>>(),
+    ///     vec![(RVA(0x0), "entry".to_string(), "spin".to_string())]
+    /// );
+    /// ```
+    pub fn rename_symbol(&mut self, rva: RVA, name: &str) -> Result<(), Error> {
+        self.analysis.queue.push_back(AnalysisCommand::RenameSymbol {
+            rva,
+            name: name.to_string(),
         });
         Ok(())
     }
 
+    /// Fetch the name to display for the given address:
+    /// its symbol, if any, otherwise an automatic name derived from its
+    /// virtual address, like `sub_401000` for a function, or `loc_401000`.
+    pub fn get_name(&self, rva: RVA) -> String {
+        if let Some(name) = self.get_symbol(rva) {
+            return name.to_string();
+        }
+
+        let va: u64 = match self.va(rva) {
+            Some(va) => va.into(),
+            None => return format!("{}", rva),
+        };
+
+        if self.analysis.functions.contains_key(&rva) {
+            format!("sub_{:x}", va)
+        } else {
+            format!("loc_{:x}", va)
+        }
+    }
+
+    /// Record a flow cross reference, such as one restored from an export.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::xref::{Xref, XrefType};
+    ///
+    /// // NOP
+    /// // NOP
+    /// let mut ws = test::get_shellcode32_workspace(b"\x90\x90");
+    /// ws.make_xref(Xref { src: RVA(0x0), dst: RVA(0x1), typ: XrefType::UnconditionalJump }).unwrap();
+    /// ws.analyze().unwrap();
+    /// assert_eq!(ws.get_xrefs_to(RVA(0x1)).unwrap()[0].src, RVA(0x0));
+    /// ```
+    pub fn make_xref(&mut self, xref: Xref) -> Result<(), Error> {
+        self.analysis.queue.push_back(AnalysisCommand::MakeXref(xref));
+        Ok(())
+    }
+
     pub fn get_functions(&self) -> impl Iterator<Item = &RVA> {
-        self.analysis.functions.iter()
+        self.analysis.functions.keys()
+    }
+
+    /// Fetch a summary of the function that starts at the given RVA,
+    /// including its name, basic blocks, and metadata.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    ///
+    /// // 0: 75 01  JNZ $+3
+    /// // 2: 90     NOP
+    /// // 3: C3     RETN
+    /// let mut ws = test::get_shellcode32_workspace(b"\x75\x01\x90\xC3");
+    /// assert!(ws.get_function(RVA(0x0)).is_none());
+    ///
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.make_symbol(RVA(0x0), "entry").unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// let f = ws.get_function(RVA(0x0)).unwrap();
+    /// assert_eq!(f.name.unwrap(), "entry");
+    /// assert_eq!(f.basic_blocks, vec![RVA(0x0), RVA(0x2), RVA(0x3)]);
+    /// assert_eq!(f.meta.is_noreturn, false);
+    /// ```
+    pub fn get_function(&self, rva: RVA) -> Option<Function> {
+        let meta = self.analysis.functions.get(&rva)?;
+
+        let mut basic_blocks: Vec<RVA> = self
+            .get_basic_blocks(rva)
+            .map(|bbs| bbs.iter().map(|bb| bb.addr).collect())
+            .unwrap_or_else(|_| vec![]);
+        basic_blocks.sort();
+
+        Some(Function {
+            addr: rva,
+            name: self.get_symbol(rva).cloned(),
+            basic_blocks,
+            meta: meta.clone(),
+        })
+    }
+
+    pub fn get_function_meta(&self, rva: RVA) -> Option<&FunctionMeta> {
+        self.analysis.functions.get(&rva)
+    }
+
+    /// Update the metadata of an existing function.
+    ///
+    /// Errors:
+    ///
+    ///   - NotAFunction - if there is no function at the given address.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::function::{CallingConvention, FunctionMeta};
+    ///
+    /// // C2 04 00  RETN 4
+    /// let mut ws = test::get_shellcode32_workspace(b"\xC2\x04\x00");
+    /// assert!(ws.set_function_meta(RVA(0x0), FunctionMeta::default()).is_err());
+    ///
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.analyze().unwrap();
+    /// ws.set_function_meta(RVA(0x0), FunctionMeta {
+    ///     calling_convention: CallingConvention::Stdcall,
+    ///     argument_count: Some(1),
+    ///     ..FunctionMeta::default()
+    /// }).unwrap();
+    ///
+    /// let meta = ws.get_function_meta(RVA(0x0)).unwrap();
+    /// assert_eq!(meta.calling_convention, CallingConvention::Stdcall);
+    /// assert_eq!(meta.argument_count, Some(1));
+    /// ```
+    pub fn set_function_meta(&mut self, rva: RVA, meta: FunctionMeta) -> Result<(), Error> {
+        match self.analysis.functions.get_mut(&rva) {
+            Some(existing) => {
+                *existing = meta;
+                Ok(())
+            }
+            None => Err(AnalysisError::NotAFunction(rva).into()),
+        }
     }
 
     /// ```
@@ -209,7 +611,39 @@ impl Workspace {
     /// assert_eq!(ws.get_functions().collect::<Vec<_>>().len(), 1);
     /// ```
     pub fn make_function(&mut self, rva: RVA) -> Result<(), Error> {
-        self.analysis.queue.push_back(AnalysisCommand::MakeFunction(rva));
+        self.analysis
+            .queue
+            .push_back(AnalysisCommand::MakeFunction { rva, source: None });
+        Ok(())
+    }
+
+    /// Create a function at the given address, recording the name of the
+    /// analyzer that discovered it in the function metadata.
+    /// The first source recorded for a function is kept.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::function::CALL_TARGET_SOURCE;
+    ///
+    /// // 0: E8 01 00 00 00  CALL $+6
+    /// // 5: C3              RETN
+    /// // 6: C3              RETN
+    /// let mut ws = test::get_shellcode32_workspace(b"\xE8\x01\x00\x00\x00\xC3\xC3");
+    /// ws.make_function_from(RVA(0x0), "user").unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// assert_eq!(ws.get_function_meta(RVA(0x0)).unwrap().source, Some("user".to_string()));
+    /// assert_eq!(
+    ///     ws.get_function_meta(RVA(0x6)).unwrap().source,
+    ///     Some(CALL_TARGET_SOURCE.to_string())
+    /// );
+    /// ```
+    pub fn make_function_from(&mut self, rva: RVA, source: &str) -> Result<(), Error> {
+        self.analysis.queue.push_back(AnalysisCommand::MakeFunction {
+            rva,
+            source: Some(source.to_string()),
+        });
         Ok(())
     }
 
@@ -217,6 +651,245 @@ impl Workspace {
         self.analysis.symbols.get(&rva)
     }
 
+    pub fn get_symbol_source(&self, rva: RVA) -> Option<SymbolSource> {
+        self.analysis.symbol_sources.get(&rva).cloned()
+    }
+
+    /// Fetch all the names proposed for the given address,
+    ///  from the highest priority source to the lowest.
+    pub fn get_symbol_candidates(&self, rva: RVA) -> Vec<&SymbolCandidate> {
+        let mut candidates: Vec<&SymbolCandidate> = match self.analysis.symbol_candidates.get(&rva) {
+            Some(candidates) => candidates.iter().collect(),
+            None => vec![],
+        };
+        // stable, so candidates from the same source remain in the order found.
+        candidates.sort_by(|a, b| b.source.cmp(&a.source));
+        candidates
+    }
+
+    /// Fetch the prototype for the given function name from the workspace's
+    /// type library. Import symbols like `kernel32.dll!CreateFileA` are
+    /// matched by the function name.
+    ///
+    /// ```
+    /// use lancelot::test;
+    ///
+    /// let ws = test::get_shellcode32_workspace(b"\xC3");
+    /// assert_eq!(ws.get_prototype("kernel32.dll!CreateFileA").unwrap().arguments.len(), 7);
+    /// assert_eq!(ws.get_prototype("LoadLibraryA").unwrap().return_type, "HMODULE");
+    /// assert!(ws.get_prototype("kernel32.dll!#10").is_none());
+    /// ```
+    pub fn get_prototype(&self, name: &str) -> Option<&Prototype> {
+        let name = match name.rfind('!') {
+            Some(i) => &name[i + 1..],
+            None => name,
+        };
+        self.analysis.types.get_prototype(name)
+    }
+
+    /// Attach a comment to the given address.
+    /// Any existing comment of the same type at the address is replaced.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::comment::CommentType;
+    ///
+    /// // JMP $+0;
+    /// let mut ws = test::get_shellcode32_workspace(b"\xEB\xFE");
+    /// assert!(ws.get_comment(RVA(0x0), CommentType::Inline).is_none());
+    ///
+    /// ws.make_comment(RVA(0x0), CommentType::Inline, "infinite loop").unwrap();
+    /// ws.make_comment(RVA(0x0), CommentType::Pre, "entry point").unwrap();
+    /// ws.analyze().unwrap();
+    /// assert_eq!(ws.get_comment(RVA(0x0), CommentType::Inline).unwrap(), "infinite loop");
+    /// assert_eq!(ws.get_comments(RVA(0x0)).len(), 2);
+    ///
+    /// ws.make_comment(RVA(0x0), CommentType::Inline, "spin").unwrap();
+    /// ws.analyze().unwrap();
+    /// assert_eq!(ws.get_comment(RVA(0x0), CommentType::Inline).unwrap(), "spin");
+    /// ```
+    pub fn make_comment(&mut self, rva: RVA, typ: CommentType, text: &str) -> Result<(), Error> {
+        self.analysis.queue.push_back(AnalysisCommand::MakeComment {
+            rva,
+            typ,
+            text: text.to_string(),
+        });
+        Ok(())
+    }
+
+    pub fn get_comment(&self, rva: RVA, typ: CommentType) -> Option<&String> {
+        self.analysis.comments.get(&(rva, typ))
+    }
+
+    /// Fetch all the comments at the given address, ordered by type.
+    pub fn get_comments(&self, rva: RVA) -> Vec<(CommentType, &String)> {
+        [CommentType::Pre, CommentType::Inline, CommentType::Post]
+            .iter()
+            .filter_map(|&typ| self.get_comment(rva, typ).map(|text| (typ, text)))
+            .collect()
+    }
+
+    /// Tag the given address, such as a function start, with an arbitrary
+    /// string like "crypto" or "c2".
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    ///
+    /// // NOP
+    /// // RETN
+    /// let mut ws = test::get_shellcode32_workspace(b"\x90\xC3");
+    /// ws.make_tag(RVA(0x1), "suspicious").unwrap();
+    /// ws.make_tag(RVA(0x0), "suspicious").unwrap();
+    /// ws.make_tag(RVA(0x0), "crypto").unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// assert_eq!(ws.get_tags(RVA(0x0)), vec!["crypto", "suspicious"]);
+    /// assert_eq!(ws.find_tagged("suspicious"), vec![RVA(0x0), RVA(0x1)]);
+    /// assert!(ws.find_tagged("c2").is_empty());
+    /// ```
+    pub fn make_tag(&mut self, rva: RVA, tag: &str) -> Result<(), Error> {
+        self.analysis.queue.push_back(AnalysisCommand::MakeTag {
+            rva,
+            tag: tag.to_string(),
+        });
+        Ok(())
+    }
+
+    /// Fetch the tags at the given address, sorted.
+    pub fn get_tags(&self, rva: RVA) -> Vec<&String> {
+        let mut tags: Vec<&String> = match self.analysis.tags.get(&rva) {
+            Some(tags) => tags.iter().collect(),
+            None => vec![],
+        };
+        tags.sort();
+        tags
+    }
+
+    /// Fetch the addresses with the given tag, sorted.
+    pub fn find_tagged(&self, tag: &str) -> Vec<RVA> {
+        let mut rvas: Vec<RVA> = self
+            .analysis
+            .tags
+            .iter()
+            .filter(|(_, tags)| tags.contains(tag))
+            .map(|(&rva, _)| rva)
+            .collect();
+        rvas.sort();
+        rvas
+    }
+
+    /// Record a property of the module as a whole, like its imphash,
+    ///  replacing any existing value.
+    ///
+    /// ```
+    /// use lancelot::test;
+    ///
+    /// let mut ws = test::get_shellcode32_workspace(b"\xC3");
+    /// assert!(ws.get_property("imphash").is_none());
+    ///
+    /// ws.set_property("imphash", "00112233445566778899aabbccddeeff");
+    /// assert_eq!(ws.get_property("imphash").unwrap(), "00112233445566778899aabbccddeeff");
+    /// assert_eq!(ws.get_properties().len(), 1);
+    /// ```
+    pub fn set_property(&mut self, name: &str, value: &str) {
+        self.analysis.properties.insert(name.to_string(), value.to_string());
+    }
+
+    pub fn get_property(&self, name: &str) -> Option<&String> {
+        self.analysis.properties.get(name)
+    }
+
+    /// Fetch the properties of the module, sorted by name.
+    pub fn get_properties(&self) -> Vec<(&String, &String)> {
+        let mut properties: Vec<(&String, &String)> = self.analysis.properties.iter().collect();
+        properties.sort();
+        properties
+    }
+
+    /// Record a string recovered from the module.
+    /// Any existing string at the address is replaced.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::strings::{RecoveredString, StringEncoding};
+    ///
+    /// let mut ws = test::get_shellcode32_workspace(b"\x90\xC3kernel32.dll\x00");
+    /// ws.make_string(RecoveredString {
+    ///     rva:        RVA(0x2),
+    ///     encoding:   StringEncoding::Ascii,
+    ///     text:       "kernel32.dll".to_string(),
+    ///     source:     "user".to_string(),
+    ///     references: vec![],
+    /// })
+    /// .unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// assert_eq!(ws.get_string(RVA(0x2)).unwrap().text, "kernel32.dll");
+    /// assert_eq!(ws.search_strings("KERNEL").len(), 1);
+    /// assert!(ws.search_strings("ntdll").is_empty());
+    /// ```
+    pub fn make_string(&mut self, s: RecoveredString) -> Result<(), Error> {
+        self.analysis.queue.push_back(AnalysisCommand::MakeString(s));
+        Ok(())
+    }
+
+    pub fn get_string(&self, rva: RVA) -> Option<&RecoveredString> {
+        self.analysis.strings.get(&rva)
+    }
+
+    /// Fetch all the recovered strings, sorted by address.
+    pub fn get_strings(&self) -> Vec<&RecoveredString> {
+        let mut strings: Vec<&RecoveredString> = self.analysis.strings.values().collect();
+        strings.sort_by_key(|s| s.rva);
+        strings
+    }
+
+    /// Fetch the recovered strings that contain the given text, ignoring
+    /// case, sorted by address.
+    pub fn search_strings(&self, needle: &str) -> Vec<&RecoveredString> {
+        let needle = needle.to_lowercase();
+        self.get_strings()
+            .into_iter()
+            .filter(|s| s.text.to_lowercase().contains(&needle))
+            .collect()
+    }
+
+    /// Register a listener to be notified as the analysis discovers
+    ///  new functions, xrefs, symbols, and strings.
+    ///
+    /// ```
+    /// use std::sync::mpsc;
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::analysis::AnalysisListener;
+    ///
+    /// struct FunctionCollector(mpsc::Sender<RVA>);
+    ///
+    /// impl AnalysisListener for FunctionCollector {
+    ///     fn on_new_function(&mut self, rva: RVA) {
+    ///         self.0.send(rva).unwrap();
+    ///     }
+    /// }
+    ///
+    /// // NOP
+    /// // RETN
+    /// let mut ws = test::get_shellcode32_workspace(b"\x90\xC3");
+    /// let (tx, rx) = mpsc::channel();
+    /// ws.add_listener(Box::new(FunctionCollector(tx)));
+    ///
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// assert_eq!(rx.try_iter().collect::<Vec<_>>(), vec![RVA(0x0)]);
+    /// ```
+    pub fn add_listener(&mut self, listener: Box<dyn AnalysisListener>) {
+        self.analysis.listeners.push(listener);
+    }
+
     pub fn get_meta(&self, rva: RVA) -> Option<FlowMeta> {
         self.analysis.flow.meta.get(rva)
     }
@@ -341,6 +1014,46 @@ impl Workspace {
         Ok(xrefs)
     }
 
+    /// Fetch the xrefs from all the instructions found in the given range
+    /// `[start, end)`. Filter the results on `.typ` to select calls, jumps,
+    /// etc.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::xref::XrefType;
+    ///
+    /// // 0: E8 01 00 00 00  CALL $+6
+    /// // 5: C3              RETN
+    /// // 6: EB FE           JMP $+0
+    /// let mut ws = test::get_shellcode32_workspace(b"\xE8\x01\x00\x00\x00\xC3\xEB\xFE");
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// let xrefs = ws.get_xrefs_in_range(RVA(0x0), RVA(0x8)).unwrap();
+    /// assert_eq!(xrefs.len(), 3);
+    ///
+    /// let calls: Vec<_> = xrefs.iter().filter(|x| x.typ == XrefType::Call).collect();
+    /// assert_eq!(calls.len(), 1);
+    /// assert_eq!(calls[0].dst, RVA(0x6));
+    ///
+    /// assert!(ws.get_xrefs_in_range(RVA(0x5), RVA(0x6)).unwrap().is_empty());
+    /// ```
+    pub fn get_xrefs_in_range(&self, start: RVA, end: RVA) -> Result<Vec<Xref>, Error> {
+        let mut xrefs = vec![];
+
+        let start: i64 = start.into();
+        let end: i64 = end.into();
+        for rva in (start..end).map(RVA::from) {
+            match self.get_meta(rva) {
+                Some(meta) if meta.is_insn() => xrefs.extend(self.get_xrefs_from(rva)?),
+                _ => continue,
+            }
+        }
+
+        Ok(xrefs)
+    }
+
     /// ## test simple memory ptr operand
     ///
     /// ```
@@ -376,6 +1089,25 @@ impl Workspace {
     /// assert_eq!(xref.is_some(), true);
     /// assert_eq!(xref.unwrap(), RVA(0x0));
     /// ```
+    ///
+    /// ## test unsupported operand
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::analysis;
+    /// use lancelot::arch::RVA;
+    ///
+    /// // FF 24 45 10 00 00 00      JMP [eax*2+0x10]
+    /// let mut ws = test::get_shellcode32_workspace(b"\xFF\x24\x45\x10\x00\x00\x00");
+    /// let insn = ws.read_insn(RVA(0x0)).unwrap();
+    /// let op = analysis::get_first_operand(&insn).unwrap();
+    /// assert!(ws.get_memory_operand_xref(RVA(0x0), &insn, &op).is_err());
+    ///
+    /// // the instruction is still recognized, just without flow.
+    /// ws.make_insn(RVA(0x0)).unwrap();
+    /// ws.analyze().unwrap();
+    /// assert!(ws.get_meta(RVA(0x0)).unwrap().is_insn());
+    /// ```
     #[allow(clippy::if_same_then_else)]
     pub fn get_memory_operand_xref(
         &self,
@@ -464,9 +1196,8 @@ impl Workspace {
             // this is something like `JMP [0x1000+eax*4]` (32-bit)
             Ok(None)
         } else {
-            println!("{:#x}: get mem op xref", rva);
-            print_op(op);
-            panic!("not supported");
+            log_op(rva, op);
+            Err(AnalysisError::UnsupportedOperand(rva).into())
         }
     }
 
@@ -566,10 +1297,9 @@ impl Workspace {
             }
         } else {
             // the operand is an immediate absolute address.
-            println!("get imm op xref");
-            println!("not implemented: immediate absolute address");
-            print_op(op);
-            panic!("not implemented");
+            // TODO: not yet supported.
+            log_op(rva, op);
+            Err(AnalysisError::UnsupportedOperand(rva).into())
         }
     }
 
@@ -908,11 +1638,30 @@ impl Workspace {
         let does_fallthrough = Workspace::does_insn_fallthrough(&insn);
 
         // 4. compute flow ref
-        // TODO: don't fail, but just return empty list?
-        let flows = self.get_insn_flow(rva, &insn)?;
+        //
+        // if we can't figure out where the instruction flows,
+        // then, unless the error policy says to abort, we still want to keep the
+        // instruction (and the rest of the analysis), just without any of the
+        // unresolved flow. the error is recorded, see `get_function_errors`.
+        let flows = match self.get_insn_flow(rva, &insn) {
+            Ok(flows) => flows,
+            Err(e) => match e.downcast_ref::<AnalysisError>() {
+                Some(AnalysisError::UnsupportedOperand(_))
+                    if self.config.analysis.error_policy != config::ErrorPolicy::AbortAll =>
+                {
+                    warn!("failed to compute instruction flow: {}", e);
+                    self.analysis.errors.insert(rva, e.to_string());
+                    vec![]
+                }
+                _ => return Err(e),
+            },
+        };
         ret.extend(flows.iter().map(|f| AnalysisCommand::MakeXref(*f)));
         ret.extend(flows.iter().map(|f| match f.typ {
-            XrefType::Call => AnalysisCommand::MakeFunction(f.dst),
+            XrefType::Call => AnalysisCommand::MakeFunction {
+                rva:    f.dst,
+                source: Some(CALL_TARGET_SOURCE.to_string()),
+            },
             _ => AnalysisCommand::MakeInsn(f.dst),
         }));
 
@@ -1017,34 +1766,163 @@ impl Workspace {
                 let xrefs = self.analysis.flow.xrefs.to.entry(xref.dst).or_insert_with(HashSet::new);
                 xrefs.insert(xref);
             }
+
+            for listener in self.analysis.listeners.iter_mut() {
+                listener.on_new_xref(&xref);
+            }
         }
 
         Ok(vec![])
     }
 
-    fn handle_make_symbol(&mut self, rva: RVA, name: &str) -> Result<Vec<AnalysisCommand>, Error> {
+    /// record the given name as a candidate for the given address,
+    /// unless the same source already proposed it.
+    fn add_symbol_candidate(&mut self, rva: RVA, name: &str, source: SymbolSource) {
+        let candidate = SymbolCandidate {
+            name: name.to_string(),
+            source,
+        };
+        let candidates = self.analysis.symbol_candidates.entry(rva).or_insert_with(Vec::new);
+        if !candidates.contains(&candidate) {
+            candidates.push(candidate);
+        }
+    }
+
+    fn handle_make_symbol(
+        &mut self,
+        rva: RVA,
+        name: &str,
+        source: SymbolSource,
+    ) -> Result<Vec<AnalysisCommand>, Error> {
         if !self.probe(rva, 1, Permissions::R) {
             warn!("invalid symbol address: {:#x}", rva);
             return Ok(vec![]);
         }
 
-        self.analysis.symbols.entry(rva).or_insert_with(|| {
-            debug!("adding symbol: {} -> \"{}\"", rva, name);
-            name.to_string()
-        });
+        self.add_symbol_candidate(rva, name, source);
+
+        match self.analysis.symbol_sources.get(&rva) {
+            None => {
+                debug!("adding symbol: {} -> \"{}\" ({})", rva, name, source.name());
+                self.analysis.symbols.insert(rva, name.to_string());
+                self.analysis.symbol_sources.insert(rva, source);
+                for listener in self.analysis.listeners.iter_mut() {
+                    listener.on_new_symbol(rva, name);
+                }
+            }
+            Some(&existing) if source > existing => {
+                debug!("replacing symbol: {} -> \"{}\" ({})", rva, name, source.name());
+                let previous = self.analysis.symbols.insert(rva, name.to_string());
+                self.analysis.symbol_sources.insert(rva, source);
+                self.notify_symbol_renamed(rva, previous, name);
+            }
+            Some(_) => {}
+        }
+
+        Ok(vec![])
+    }
+
+    /// notify the listeners that the symbol at the given address changed from
+    /// the given name, if it did.
+    fn notify_symbol_renamed(&mut self, rva: RVA, previous: Option<String>, name: &str) {
+        if let Some(previous) = previous {
+            if previous != name {
+                for listener in self.analysis.listeners.iter_mut() {
+                    listener.on_symbol_renamed(rva, &previous, name);
+                }
+            }
+        }
+    }
+
+    fn handle_rename_symbol(&mut self, rva: RVA, name: &str) -> Result<Vec<AnalysisCommand>, Error> {
+        if !self.analysis.symbols.contains_key(&rva) {
+            return self.handle_make_symbol(rva, name, SymbolSource::User);
+        }
+
+        debug!("renaming symbol: {} -> \"{}\"", rva, name);
+        self.add_symbol_candidate(rva, name, SymbolSource::User);
+        let previous = self.analysis.symbols.insert(rva, name.to_string());
+        self.analysis.symbol_sources.insert(rva, SymbolSource::User);
+        self.notify_symbol_renamed(rva, previous, name);
+
+        Ok(vec![])
+    }
+
+    fn handle_make_comment(&mut self, rva: RVA, typ: CommentType, text: &str) -> Result<Vec<AnalysisCommand>, Error> {
+        if !self.probe(rva, 1, Permissions::R) {
+            warn!("invalid comment address: {:#x}", rva);
+            return Ok(vec![]);
+        }
+
+        debug!("adding comment: {} {:?} -> \"{}\"", rva, typ, text);
+        self.analysis.comments.insert((rva, typ), text.to_string());
+
+        Ok(vec![])
+    }
+
+    fn handle_make_tag(&mut self, rva: RVA, tag: &str) -> Result<Vec<AnalysisCommand>, Error> {
+        if !self.probe(rva, 1, Permissions::R) {
+            warn!("invalid tag address: {:#x}", rva);
+            return Ok(vec![]);
+        }
+
+        if self
+            .analysis
+            .tags
+            .entry(rva)
+            .or_insert_with(HashSet::new)
+            .insert(tag.to_string())
+        {
+            debug!("adding tag: {} -> \"{}\"", rva, tag);
+        }
+
+        Ok(vec![])
+    }
+
+    fn handle_make_string(&mut self, s: RecoveredString) -> Result<Vec<AnalysisCommand>, Error> {
+        if !self.probe(s.rva, 1, Permissions::R) {
+            warn!("invalid string address: {:#x}", s.rva);
+            return Ok(vec![]);
+        }
+
+        debug!("adding string: {} -> {:?}", s.rva, s.text);
+        if !self.analysis.strings.contains_key(&s.rva) {
+            for listener in self.analysis.listeners.iter_mut() {
+                listener.on_new_string(&s);
+            }
+        }
+        self.analysis.strings.insert(s.rva, s);
 
         Ok(vec![])
     }
 
-    fn handle_make_function(&mut self, rva: RVA) -> Result<Vec<AnalysisCommand>, Error> {
+    fn handle_make_function(&mut self, rva: RVA, source: Option<String>) -> Result<Vec<AnalysisCommand>, Error> {
         // TODO: probably ensure this is code, not just readable.
         if !self.probe(rva, 1, Permissions::X) {
             warn!("invalid function address: {:#x}", rva);
             return Ok(vec![]);
         }
 
-        if self.analysis.functions.insert(rva) {
-            debug!("adding function: {}", rva);
+        match self.analysis.functions.get_mut(&rva) {
+            Some(meta) => {
+                // keep the first source, but fill it in if it wasn't known.
+                if meta.source.is_none() {
+                    meta.source = source;
+                }
+            }
+            None => {
+                debug!("adding function: {}", rva);
+                self.analysis.functions.insert(
+                    rva,
+                    FunctionMeta {
+                        source,
+                        ..FunctionMeta::default()
+                    },
+                );
+                for listener in self.analysis.listeners.iter_mut() {
+                    listener.on_new_function(rva);
+                }
+            }
         };
 
         Ok(vec![AnalysisCommand::MakeInsn(rva)])
@@ -1068,17 +1946,269 @@ impl Workspace {
             let cmds = match cmd {
                 AnalysisCommand::MakeInsn(rva) => self.handle_make_insn(rva)?,
                 AnalysisCommand::MakeXref(xref) => self.handle_make_xref(xref)?,
-                AnalysisCommand::MakeSymbol { rva, name } => self.handle_make_symbol(rva, &name)?,
-                AnalysisCommand::MakeFunction(rva) => self.handle_make_function(rva)?,
+                AnalysisCommand::MakeSymbol { rva, name, source } => self.handle_make_symbol(rva, &name, source)?,
+                AnalysisCommand::RenameSymbol { rva, name } => self.handle_rename_symbol(rva, &name)?,
+                AnalysisCommand::MakeFunction { rva, source } => self.handle_make_function(rva, source)?,
+                AnalysisCommand::MakeComment { rva, typ, text } => self.handle_make_comment(rva, typ, &text)?,
+                AnalysisCommand::MakeTag { rva, tag } => self.handle_make_tag(rva, &tag)?,
+                AnalysisCommand::MakeString(s) => self.handle_make_string(s)?,
             };
             self.analysis.queue.extend(cmds);
         }
 
+        if self.config.analysis.error_policy == config::ErrorPolicy::SkipFunction {
+            self.skip_failed_functions();
+        }
+
         Ok(())
     }
+
+    /// drop the functions that contain an instruction whose flow couldn't be
+    /// resolved. their instructions remain.
+    fn skip_failed_functions(&mut self) {
+        if self.analysis.errors.is_empty() {
+            return;
+        }
+
+        let failed: Vec<RVA> = self
+            .analysis
+            .functions
+            .keys()
+            .filter(|&&function| !self.get_function_errors(function).is_empty())
+            .cloned()
+            .collect();
+        for function in failed.into_iter() {
+            warn!("skipping function with analysis errors: {}", function);
+            self.analysis.functions.remove(&function);
+        }
+    }
+
+    /// Fetch the errors met while resolving the flow of the instructions of
+    /// the given function, ordered by address.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::analysis::config::ErrorPolicy;
+    ///
+    /// // 0: 85 C0                 TEST EAX, EAX
+    /// // 2: 74 07                 JZ 0xB
+    /// // 4: FF 24 45 10 00 00 00  JMP [eax*2+0x10]
+    /// // B: C3                    RETN
+    /// let buf = b"\x85\xC0\x74\x07\xFF\x24\x45\x10\x00\x00\x00\xC3";
+    ///
+    /// // by default, the function is kept without the unresolved flow.
+    /// let mut ws = test::get_shellcode32_workspace(buf);
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.analyze().unwrap();
+    /// assert_eq!(
+    ///     ws.get_function_errors(RVA(0x0)),
+    ///     vec![(RVA(0x4), "Unsupported operand at 0x4".to_string())]
+    /// );
+    ///
+    /// let mut ws = test::get_shellcode32_workspace(buf);
+    /// ws.config.analysis.error_policy = ErrorPolicy::SkipFunction;
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.analyze().unwrap();
+    /// assert_eq!(ws.get_functions().count(), 0);
+    /// assert!(ws.get_meta(RVA(0xB)).unwrap().is_insn());
+    ///
+    /// let mut ws = test::get_shellcode32_workspace(buf);
+    /// ws.config.analysis.error_policy = ErrorPolicy::AbortAll;
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// assert!(ws.analyze().is_err());
+    /// ```
+    pub fn get_function_errors(&self, rva: RVA) -> Vec<(RVA, String)> {
+        let bbs = self.get_basic_blocks(rva).unwrap_or_else(|_| vec![]);
+        let mut errors: Vec<(RVA, String)> = self
+            .analysis
+            .errors
+            .iter()
+            .filter(|(&addr, _)| {
+                bbs.iter()
+                    .any(|bb| bb.addr <= addr && addr < bb.addr + bb.length as usize)
+            })
+            .map(|(&addr, e)| (addr, e.clone()))
+            .collect();
+        errors.sort();
+        errors
+    }
+
+    /// Run the given analyzer over the workspace, logging how long it takes,
+    ///  and notifying the listeners when it completes or fails.
+    ///
+    /// ```
+    /// use std::sync::mpsc;
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::analysis::{AnalysisListener, StringAnalyzer};
+    ///
+    /// struct PassCollector(mpsc::Sender<String>);
+    ///
+    /// impl AnalysisListener for PassCollector {
+    ///     fn on_pass_completed(&mut self, name: &str) {
+    ///         self.0.send(name.to_string()).unwrap();
+    ///     }
+    /// }
+    ///
+    /// let mut ws = test::get_shellcode32_workspace(b"\xC3hello world\x00");
+    /// let (tx, rx) = mpsc::channel();
+    /// ws.add_listener(Box::new(PassCollector(tx)));
+    ///
+    /// ws.run_analyzer(&StringAnalyzer::new()).unwrap();
+    /// assert_eq!(ws.get_string(RVA(0x1)).unwrap().text, "hello world");
+    /// assert_eq!(rx.try_iter().collect::<Vec<_>>(), vec!["string analyzer"]);
+    /// ```
+    pub fn run_analyzer(&mut self, analyzer: &dyn Analyzer) -> Result<(), Error> {
+        let name = analyzer.get_name();
+        info!("analyzing with {}", name);
+        let start = Instant::now();
+        let ret = analyzer.analyze(self);
+        info!("analyzer {} ran for {:?}", name, start.elapsed());
+
+        for listener in self.analysis.listeners.iter_mut() {
+            match &ret {
+                Ok(_) => listener.on_pass_completed(&name),
+                Err(e) => listener.on_pass_failed(&name, e),
+            }
+        }
+
+        ret
+    }
+
+    /// Notify the listeners of how far the analysis has gotten.
+    pub fn report_progress(&mut self, progress: &listener::Progress) {
+        match progress.remaining() {
+            Some(remaining) => debug!(
+                "analysis progress: {}/{}, about {:?} remaining",
+                progress.completed, progress.total, remaining
+            ),
+            None => debug!("analysis progress: {}/{}", progress.completed, progress.total),
+        }
+
+        for listener in self.analysis.listeners.iter_mut() {
+            listener.on_progress(progress);
+        }
+    }
+}
+
+/// The stage of analysis during which an analyzer runs.
+/// Each analyzer runs after all the analyzers of the earlier phases.
+#[derive(Debug, Copy, Clone, PartialEq, Eq, PartialOrd, Ord)]
+pub enum AnalysisPhase {
+    /// find functions and other artifacts, like from the file format.
+    Discovery,
+    /// runs once all the discovery passes are done,
+    /// like to find the functions that they missed.
+    PostDiscovery,
+    /// inspect the functions that have been found.
+    Functions,
 }
 
 pub trait Analyzer {
     fn get_name(&self) -> String;
+
+    fn get_phase(&self) -> AnalysisPhase {
+        AnalysisPhase::Discovery
+    }
+
+    /// the names of the analyzers that must run before this one,
+    ///  if they're scheduled at all.
+    fn get_dependencies(&self) -> Vec<String> {
+        vec![]
+    }
+
     fn analyze(&self, ws: &mut Workspace) -> Result<(), Error>;
 }
+
+/// The analyzers that don't run by default, but can be enabled by name
+///  via `AnalysisConfig::enabled_analyzers`.
+///
+/// ```
+/// use lancelot::analysis::{self, Analyzer};
+///
+/// let names: Vec<String> = analysis::get_optional_analyzers()
+///     .iter()
+///     .map(|analyzer| analyzer.get_name())
+///     .collect();
+/// assert!(names.contains(&"API hash analyzer".to_string()));
+/// ```
+pub fn get_optional_analyzers() -> Vec<Box<dyn Analyzer>> {
+    vec![
+        Box::new(StringAnalyzer::new()),
+        Box::new(stackstrings::StackStringAnalyzer::new()),
+        Box::new(names::StringNameAnalyzer::new()),
+        Box::new(crypto::CryptoAnalyzer::new()),
+        Box::new(hashes::ImageHashAnalyzer::new()),
+        Box::new(apihashes::ApiHashAnalyzer::new(ApiHashDatabase::new())),
+    ]
+}
+
+/// Order the given analyzers so that each runs after the analyzers of earlier
+///  phases and after its dependencies, otherwise keeping the given order.
+/// Dependencies on analyzers that aren't given are ignored.
+///
+/// ```
+/// use lancelot::analysis::{self, Analyzer};
+/// use lancelot::analysis::names::StringNameAnalyzer;
+/// use lancelot::analysis::{OrphanFunctionAnalyzer, StringAnalyzer};
+/// use lancelot::analysis::frame::FrameAnalyzer;
+/// use lancelot::analysis::pe::EntryPointAnalyzer;
+///
+/// let names = StringNameAnalyzer::new();
+/// let strings = StringAnalyzer::new();
+/// let orphans = OrphanFunctionAnalyzer::new();
+/// let analyzers: Vec<&dyn Analyzer> = vec![&names, &strings, &orphans];
+///
+/// let order: Vec<String> = analysis::schedule_analyzers(analyzers)
+///     .unwrap()
+///     .iter()
+///     .map(|analyzer| analyzer.get_name())
+///     .collect();
+/// assert_eq!(
+///     order,
+///     vec!["string analyzer", "orphan function analyzer", "string-based function name analyzer"]
+/// );
+///
+/// let frames = FrameAnalyzer::new();
+/// let entry = EntryPointAnalyzer::new();
+/// let analyzers: Vec<&dyn Analyzer> = vec![&frames, &orphans, &entry];
+///
+/// let order: Vec<String> = analysis::schedule_analyzers(analyzers)
+///     .unwrap()
+///     .iter()
+///     .map(|analyzer| analyzer.get_name())
+///     .collect();
+/// assert_eq!(
+///     order,
+///     vec!["PE entry point analyzer", "orphan function analyzer", "stack frame analyzer"]
+/// );
+/// ```
+///
+/// Errors:
+///
+///   - DependencyCycle - if the dependencies form a cycle.
+pub fn schedule_analyzers(analyzers: Vec<&dyn Analyzer>) -> Result<Vec<&dyn Analyzer>, Error> {
+    let mut pending = analyzers;
+    let mut scheduled = Vec::with_capacity(pending.len());
+
+    while !pending.is_empty() {
+        let names: HashSet<String> = pending.iter().map(|analyzer| analyzer.get_name()).collect();
+        // pick the first analyzer that doesn't wait on a pending one.
+        let ready = pending.iter().position(|analyzer| {
+            let phase = analyzer.get_phase();
+            pending.iter().all(|other| other.get_phase() >= phase)
+                && analyzer
+                    .get_dependencies()
+                    .iter()
+                    .all(|dependency| !names.contains(dependency))
+        });
+
+        match ready {
+            Some(index) => scheduled.push(pending.remove(index)),
+            None => return Err(AnalysisError::DependencyCycle(pending[0].get_name()).into()),
+        }
+    }
+
+    Ok(scheduled)
+}
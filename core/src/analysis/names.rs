@@ -0,0 +1,178 @@
+/// name unnamed functions after the most distinctive string that they
+/// reference, such as `fn_beacon_sent_to` for a function that logs
+/// `"beacon sent to %s"`.
+///
+/// these names are guesses, so the functions are tagged with
+/// `HEURISTIC_NAME_TAG`, the names have the lowest priority source, and the
+/// names are only applied to functions without a symbol.
+/// users can replace them with `Workspace::rename_symbol`.
+///
+/// this should run after the `StringAnalyzer`, which finds the strings and
+/// their references.
+use std::collections::{HashMap, HashSet};
+
+use failure::Error;
+use lazy_static::lazy_static;
+use log::debug;
+use regex::Regex;
+
+use super::{
+    super::{arch::RVA, symbol::SymbolSource, workspace::Workspace},
+    AnalysisPhase, Analyzer,
+};
+
+/// the tag applied to functions named by this analyzer.
+pub const HEURISTIC_NAME_TAG: &str = "heuristic-name";
+
+/// the maximum number of words from the string used in a name.
+const MAX_WORDS: usize = 4;
+
+pub struct StringNameAnalyzer {}
+
+impl StringNameAnalyzer {
+    #[allow(clippy::new_without_default)]
+    pub fn new() -> StringNameAnalyzer {
+        StringNameAnalyzer {}
+    }
+}
+
+/// split the given string into lowercase words, ignoring format specifiers
+/// like `%s` or `%08x`.
+fn get_words(s: &str) -> Vec<String> {
+    lazy_static! {
+        static ref FORMAT_RE: Regex = Regex::new(r"%[-+ #0]*[0-9]*(\.[0-9]+)?[hlLqjzt]*[a-zA-Z]").unwrap();
+        static ref WORD_RE: Regex = Regex::new(r"[A-Za-z]{2,}").unwrap();
+    }
+
+    let s = FORMAT_RE.replace_all(s, " ");
+    WORD_RE.find_iter(&s).map(|m| m.as_str().to_lowercase()).collect()
+}
+
+/// score how well the given string describes the code that references it.
+/// format strings are typically log messages, which describe the code well.
+fn score(s: &str) -> usize {
+    let words = get_words(s).len();
+    if s.contains('%') {
+        words * 2
+    } else {
+        words
+    }
+}
+
+/// derive a function name from the given string, if it has any words.
+fn get_name(s: &str) -> Option<String> {
+    let words = get_words(s);
+    if words.is_empty() {
+        return None;
+    }
+
+    let words: Vec<&str> = words.iter().take(MAX_WORDS).map(String::as_str).collect();
+    Some(format!("fn_{}", words.join("_")))
+}
+
+impl Analyzer for StringNameAnalyzer {
+    fn get_name(&self) -> String {
+        "string-based function name analyzer".to_string()
+    }
+
+    fn get_phase(&self) -> AnalysisPhase {
+        AnalysisPhase::Functions
+    }
+
+    fn get_dependencies(&self) -> Vec<String> {
+        vec!["string analyzer".to_string(), "stack string analyzer".to_string()]
+    }
+
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::analysis::Analyzer;
+    /// use lancelot::analysis::StringAnalyzer;
+    /// use lancelot::analysis::names::{StringNameAnalyzer, HEURISTIC_NAME_TAG};
+    ///
+    /// // 0: 68 10 00 00 00  PUSH 0x10
+    /// // 5: C3              RETN
+    /// // 6: ...             padding
+    /// // 10: "beacon sent to %s"
+    /// let mut ws = test::get_shellcode32_workspace(
+    ///     b"\x68\x10\x00\x00\x00\xC3\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\
+    ///       beacon sent to %s\x00",
+    /// );
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.analyze().unwrap();
+    /// StringAnalyzer::new().analyze(&mut ws).unwrap();
+    ///
+    /// StringNameAnalyzer::new().analyze(&mut ws).unwrap();
+    /// assert_eq!(ws.get_symbol(RVA(0x0)).unwrap(), "fn_beacon_sent_to");
+    /// assert_eq!(ws.find_tagged(HEURISTIC_NAME_TAG), vec![RVA(0x0)]);
+    /// ```
+    fn analyze(&self, ws: &mut Workspace) -> Result<(), Error> {
+        // index the unnamed functions by their instructions.
+        let mut containing: HashMap<RVA, Vec<RVA>> = HashMap::new();
+        for &function in ws.get_functions() {
+            if ws.get_symbol(function).is_some() {
+                continue;
+            }
+
+            let bbs = match ws.get_basic_blocks(function) {
+                Ok(bbs) => bbs,
+                Err(_) => continue,
+            };
+            for bb in bbs.iter() {
+                for &insn in bb.insns.iter() {
+                    containing.entry(insn).or_insert_with(Vec::new).push(function);
+                }
+            }
+        }
+
+        // for each function, the best string it references.
+        let mut best: HashMap<RVA, (usize, &str)> = HashMap::new();
+        for s in ws.get_strings().into_iter() {
+            let score = score(&s.text);
+            if score == 0 {
+                continue;
+            }
+
+            for reference in s.references.iter() {
+                for &function in containing.get(reference).into_iter().flatten() {
+                    let is_better = match best.get(&function) {
+                        Some(&(existing, _)) => score > existing,
+                        None => true,
+                    };
+                    if is_better {
+                        best.insert(function, (score, &s.text));
+                    }
+                }
+            }
+        }
+
+        let mut names: HashSet<String> = ws.analysis.symbols.values().cloned().collect();
+        let mut symbols: Vec<(RVA, String)> = vec![];
+        let mut functions: Vec<&RVA> = best.keys().collect();
+        functions.sort();
+        for &function in functions.into_iter() {
+            let name = match get_name(best[&function].1) {
+                Some(name) => name,
+                None => continue,
+            };
+
+            // keep names unique, since many functions may log similar messages.
+            let mut candidate = name.clone();
+            let mut i = 2;
+            while names.contains(&candidate) {
+                candidate = format!("{}_{}", name, i);
+                i += 1;
+            }
+
+            names.insert(candidate.clone());
+            symbols.push((function, candidate));
+        }
+
+        for (function, name) in symbols.into_iter() {
+            debug!("heuristic name: {}: {}", function, name);
+            ws.make_symbol_from(function, &name, SymbolSource::Heuristic)?;
+            ws.make_tag(function, HEURISTIC_NAME_TAG)?;
+        }
+        ws.analyze()
+    }
+}
@@ -3,7 +3,7 @@
 /// example: RUNTIME_FUNCTION always references code, but not always the start
 /// of a function. catch that case here.
 ///
-/// this should be the final analyzer pass.
+/// this runs after all the function discovery passes.
 use std::collections::HashSet;
 
 use failure::Error;
@@ -11,7 +11,7 @@ use log::debug;
 
 use super::{
     super::{arch::RVA, workspace::Workspace},
-    Analyzer,
+    AnalysisPhase, Analyzer,
 };
 
 pub struct OrphanFunctionAnalyzer {}
@@ -28,6 +28,11 @@ impl Analyzer for OrphanFunctionAnalyzer {
         "orphan function analyzer".to_string()
     }
 
+    /// runs once all the other analyzers have found the functions they can.
+    fn get_phase(&self) -> AnalysisPhase {
+        AnalysisPhase::PostDiscovery
+    }
+
     /// ```
     /// use lancelot::rsrc::*;
     /// use lancelot::arch::*;
@@ -78,7 +83,7 @@ impl Analyzer for OrphanFunctionAnalyzer {
 
         for rva in orphans.iter() {
             debug!("found orphan function {}", rva);
-            ws.make_function(*rva)?;
+            ws.make_function_from(*rva, &self.get_name())?;
             ws.analyze()?;
         }
 
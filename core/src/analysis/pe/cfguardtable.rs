@@ -134,7 +134,7 @@ impl Analyzer for CFGuardTableAnalyzer {
             let function = RVA::from(ws.read_i32(offset)?);
 
             debug!("CF guard function: {}", function);
-            ws.make_function(function)?;
+            ws.make_function_from(function, &self.get_name())?;
             ws.analyze()?;
 
             // 4 == sizeof(32-bit RVA)
@@ -153,7 +153,7 @@ impl Analyzer for CFGuardTableAnalyzer {
             let guard_check_icall = ws.rva(ws.read_va(guard_check_icall_fptr)?).unwrap();
             if ws.probe(guard_check_icall, 1, Permissions::X) {
                 debug!("CF guard check function: {:#x}", guard_check_icall);
-                ws.make_function(guard_check_icall)?;
+                ws.make_function_from(guard_check_icall, &self.get_name())?;
                 ws.analyze()?;
             }
         };
@@ -178,7 +178,7 @@ impl Analyzer for CFGuardTableAnalyzer {
                     let guard_dispatch_icall = ws.rva(ws.read_va(guard_dispatch_icall_fptr)?).unwrap();
                     if ws.probe(guard_dispatch_icall, 1, Permissions::X) {
                         debug!("CF guard dispatch function: {:#x}", guard_dispatch_icall);
-                        ws.make_function(guard_dispatch_icall)?;
+                        ws.make_function_from(guard_dispatch_icall, &self.get_name())?;
                         ws.analyze()?;
                     }
                 };
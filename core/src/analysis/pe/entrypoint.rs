@@ -21,6 +21,24 @@ impl Analyzer for EntryPointAnalyzer {
         "PE entry point analyzer".to_string()
     }
 
+    /// create a function at the entry point, recording this analyzer as its
+    /// source.
+    ///
+    /// ```
+    /// use lancelot::rsrc::*;
+    /// use lancelot::workspace::Workspace;
+    ///
+    /// let ws = Workspace::from_bytes("nop.exe", &get_buf(Rsrc::NOP))
+    ///    .load().unwrap();
+    /// let entry = *ws
+    ///     .get_functions()
+    ///     .find(|&&f| ws.get_symbol(f).map(String::as_str) == Some("entry"))
+    ///     .unwrap();
+    /// assert_eq!(
+    ///     ws.get_function_meta(entry).unwrap().source,
+    ///     Some("PE entry point analyzer".to_string())
+    /// );
+    /// ```
     fn analyze(&self, ws: &mut Workspace) -> Result<(), Error> {
         let pe = match Object::parse(&ws.buf) {
             Ok(Object::PE(pe)) => pe,
@@ -31,7 +49,7 @@ impl Analyzer for EntryPointAnalyzer {
         debug!("entry point: {}", entry);
 
         ws.make_symbol(entry, "entry")?;
-        ws.make_function(entry)?;
+        ws.make_function_from(entry, &self.get_name())?;
         ws.analyze()?;
 
         Ok(())
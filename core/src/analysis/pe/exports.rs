@@ -1,12 +1,66 @@
 use failure::Error;
-use goblin::Object;
+use goblin::{pe::export::Reexport, Object};
 use log::debug;
 
 use super::super::{
-    super::{arch::RVA, workspace::Workspace},
+    super::{arch::RVA, comment::CommentType, symbol::SymbolSource, workspace::Workspace},
     Analyzer,
 };
 
+/// an export that is implemented by another module,
+/// like kernel32's `HeapAlloc`, which is forwarded to `NTDLL.RtlAllocateHeap`.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Forwarder {
+    /// address of the forwarder string.
+    pub rva:    RVA,
+    /// name of the export, if it is not exported only by ordinal.
+    pub name:   Option<String>,
+    /// name of the implementing module, like `NTDLL`.
+    pub module: String,
+    /// the implementing export, by name like `RtlAllocateHeap`, or by
+    /// ordinal like `#12`.
+    pub target: String,
+}
+
+/// Fetch the exports that are forwarded to other modules.
+///
+/// ```
+/// use lancelot::rsrc::*;
+/// use lancelot::workspace::Workspace;
+/// use lancelot::analysis::pe::exports;
+///
+/// let ws = Workspace::from_bytes("k32.dll", &get_buf(Rsrc::K32))
+///    .disable_analysis()
+///    .load().unwrap();
+/// let forwarders = exports::get_forwarders(&ws);
+/// let heap_alloc = forwarders.iter().find(|f| f.name == Some("HeapAlloc".to_string())).unwrap();
+/// assert_eq!(heap_alloc.module, "NTDLL");
+/// assert_eq!(heap_alloc.target, "RtlAllocateHeap");
+/// ```
+pub fn get_forwarders(ws: &Workspace) -> Vec<Forwarder> {
+    let pe = match Object::parse(&ws.buf) {
+        Ok(Object::PE(pe)) => pe,
+        _ => return vec![],
+    };
+
+    pe.exports
+        .iter()
+        .filter_map(|exp| {
+            let (module, target) = match exp.reexport.as_ref()? {
+                Reexport::DLLName { export, lib } => (lib.to_string(), export.to_string()),
+                Reexport::DLLOrdinal { ordinal, lib } => (lib.to_string(), format!("#{}", ordinal)),
+            };
+
+            Some(Forwarder {
+                rva: RVA::from(exp.rva),
+                name: exp.name.map(|name| name.to_string()),
+                module,
+                target,
+            })
+        })
+        .collect()
+}
+
 pub struct ExportsAnalyzer {}
 
 impl ExportsAnalyzer {
@@ -48,16 +102,26 @@ impl Analyzer for ExportsAnalyzer {
 
         for (rva, name) in symbols.into_iter() {
             debug!("export: {}: {}", rva, name);
-            ws.make_symbol(rva, &name)?;
+            ws.make_symbol_from(rva, &name, SymbolSource::Export)?;
             ws.analyze()?;
         }
 
         for rva in exports.into_iter() {
-            ws.make_function(rva)?;
+            ws.make_function_from(rva, &self.get_name())?;
             ws.analyze()?;
         }
 
-        ws.make_function(entry)?;
+        for forwarder in get_forwarders(ws).into_iter() {
+            debug!("forwarded export: {:?}", forwarder);
+            ws.make_comment(
+                forwarder.rva,
+                CommentType::Inline,
+                &format!("forwarded to {}.{}", forwarder.module, forwarder.target),
+            )?;
+        }
+        ws.analyze()?;
+
+        ws.make_function_from(entry, &self.get_name())?;
         ws.make_symbol(entry, "entry")?;
         ws.analyze()?;
 
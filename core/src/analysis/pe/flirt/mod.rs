@@ -4,11 +4,15 @@ use failure::Error;
 use log::{debug, info, trace, warn};
 
 use super::super::{
-    super::{arch::RVA, util, workspace::Workspace},
+    super::{arch::RVA, symbol::SymbolSource, util, workspace::Workspace},
     Analyzer,
 };
 use flirt::{self, pat, sig};
 
+/// the tag applied to functions recognized by a FLIRT signature,
+/// so that library code can be excluded from further analysis or review.
+pub const LIBRARY_TAG: &str = "library";
+
 #[derive(Clone, Debug)]
 pub struct FlirtConfig {
     pub pat_dir: PathBuf,
@@ -217,7 +221,8 @@ impl Analyzer for FlirtAnalyzer {
                 // can unwrap name cause its guaranteed to have a name due to filter above.
                 let name = match_.get_name().unwrap();
                 debug!("FLIRT signature match: {} {}", fva, name);
-                ws.make_symbol(fva, name).unwrap(); // danger
+                ws.make_symbol_from(fva, name, SymbolSource::Signature).unwrap(); // danger
+                ws.make_tag(fva, LIBRARY_TAG)?;
                 continue;
             }
         }
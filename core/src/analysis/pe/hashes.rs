@@ -0,0 +1,180 @@
+/// compute the imphash and Rich header hash of a PE file,
+/// which are commonly used to cluster related samples,
+/// and record them as properties of the module.
+///
+/// these match the values computed by pefile.
+use byteorder::{ByteOrder, LittleEndian};
+use failure::Error;
+use goblin::Object;
+use md5;
+
+use super::{
+    super::{super::workspace::Workspace, Analyzer},
+    ordinals,
+};
+
+/// the name of the module property that records the imphash.
+pub const IMPHASH_PROPERTY: &str = "imphash";
+/// the name of the module property that records the Rich header hash.
+pub const RICH_HEADER_HASH_PROPERTY: &str = "rich header hash";
+
+/// `DanS` as a little-endian dword.
+const DANS: u32 = 0x536E_6144;
+
+/// Compute the imphash: the MD5 of the lowercased, comma-separated list of
+///  `module.function` names of the imports, in the order they appear.
+///
+/// Returns `None` if the file is not a PE or has no imports.
+///
+/// ```
+/// use lancelot::rsrc::*;
+/// use lancelot::workspace::Workspace;
+/// use lancelot::analysis::pe::hashes;
+///
+/// let ws = Workspace::from_bytes("k32.dll", &get_buf(Rsrc::K32))
+///    .disable_analysis()
+///    .load().unwrap();
+/// assert_eq!(hashes::imphash(&ws).unwrap().unwrap(), "100f313c3eeb0e6bb4bcd10918d650f0");
+///
+/// let ws = Workspace::from_bytes("nop.exe", &get_buf(Rsrc::NOP))
+///    .disable_analysis()
+///    .load().unwrap();
+/// assert_eq!(hashes::imphash(&ws).unwrap().unwrap(), "419a52bf699bf96906d83fa6b634c66e");
+///
+/// let ws = Workspace::from_bytes("tiny.exe", &get_buf(Rsrc::TINY))
+///    .disable_analysis()
+///    .load().unwrap();
+/// assert!(hashes::imphash(&ws).unwrap().is_none());
+/// ```
+pub fn imphash(ws: &Workspace) -> Result<Option<String>, Error> {
+    let pe = match Object::parse(&ws.buf) {
+        Ok(Object::PE(pe)) => pe,
+        _ => return Ok(None),
+    };
+
+    if pe.imports.is_empty() {
+        return Ok(None);
+    }
+
+    let names: Vec<String> = pe
+        .imports
+        .iter()
+        .map(|import| {
+            let dll = import.dll.to_lowercase();
+            let dll = match dll.rfind('.') {
+                Some(i) if ["dll", "ocx", "sys"].contains(&&dll[i + 1..]) => dll[..i].to_string(),
+                _ => dll,
+            };
+
+            // goblin names imports by ordinal like `ORDINAL 12`.
+            let name = if import.name.starts_with("ORDINAL ") {
+                match ordinals::get_name(import.dll, u32::from(import.ordinal)) {
+                    Some(name) => name.to_lowercase(),
+                    None => format!("ord{}", import.ordinal),
+                }
+            } else {
+                import.name.to_lowercase()
+            };
+
+            format!("{}.{}", dll, name)
+        })
+        .collect();
+
+    Ok(Some(format!("{:x}", md5::compute(names.join(",")))))
+}
+
+/// Compute the MD5 of the decoded Rich header, which records the build
+/// tools used to link the file.
+///
+/// Returns `None` if the file is not a PE or has no valid Rich header.
+///
+/// ```
+/// use lancelot::rsrc::*;
+/// use lancelot::workspace::Workspace;
+/// use lancelot::analysis::pe::hashes;
+///
+/// let ws = Workspace::from_bytes("k32.dll", &get_buf(Rsrc::K32))
+///    .disable_analysis()
+///    .load().unwrap();
+/// assert_eq!(hashes::rich_header_hash(&ws).unwrap(), "3863b1aa5e7189361625f8711c7d9ce8");
+///
+/// let ws = Workspace::from_bytes("nop.exe", &get_buf(Rsrc::NOP))
+///    .disable_analysis()
+///    .load().unwrap();
+/// assert_eq!(hashes::rich_header_hash(&ws).unwrap(), "8c03028e1d464367c6d8d30c6f11ded2");
+/// ```
+pub fn rich_header_hash(ws: &Workspace) -> Option<String> {
+    let pe = match Object::parse(&ws.buf) {
+        Ok(Object::PE(pe)) => pe,
+        _ => return None,
+    };
+
+    // the Rich header follows the DOS stub, and precedes the PE header.
+    let start = 0x80;
+    let end = std::cmp::min(pe.header.dos_header.pe_pointer as usize, ws.buf.len());
+    if end < start + 8 {
+        return None;
+    }
+
+    let rich = ws.buf[start..end].windows(4).position(|w| w == b"Rich")? + start;
+    if rich + 8 > ws.buf.len() || (rich - start) % 4 != 0 {
+        return None;
+    }
+
+    let key = &ws.buf[rich + 4..rich + 8];
+    let clear: Vec<u8> = ws.buf[start..rich]
+        .iter()
+        .enumerate()
+        .map(|(i, &b)| b ^ key[i % 4])
+        .collect();
+
+    if clear.len() < 4 || LittleEndian::read_u32(&clear[..4]) != DANS {
+        return None;
+    }
+
+    Some(format!("{:x}", md5::compute(clear)))
+}
+
+pub struct HashesAnalyzer {}
+
+impl HashesAnalyzer {
+    #[allow(clippy::new_without_default)]
+    pub fn new() -> HashesAnalyzer {
+        HashesAnalyzer {}
+    }
+}
+
+impl Analyzer for HashesAnalyzer {
+    fn get_name(&self) -> String {
+        "PE hashes analyzer".to_string()
+    }
+
+    /// record the imphash and Rich header hash, when present,
+    /// as properties of the module.
+    ///
+    /// ```
+    /// use lancelot::rsrc::*;
+    /// use lancelot::workspace::Workspace;
+    /// use lancelot::analysis::pe::hashes;
+    ///
+    /// let ws = Workspace::from_bytes("nop.exe", &get_buf(Rsrc::NOP))
+    ///    .load().unwrap();
+    /// assert_eq!(
+    ///     ws.get_property(hashes::IMPHASH_PROPERTY).unwrap(),
+    ///     "419a52bf699bf96906d83fa6b634c66e"
+    /// );
+    /// assert_eq!(
+    ///     ws.get_property(hashes::RICH_HEADER_HASH_PROPERTY).unwrap(),
+    ///     "8c03028e1d464367c6d8d30c6f11ded2"
+    /// );
+    /// ```
+    fn analyze(&self, ws: &mut Workspace) -> Result<(), Error> {
+        if let Some(imphash) = imphash(ws)? {
+            ws.set_property(IMPHASH_PROPERTY, &imphash);
+        }
+        if let Some(hash) = rich_header_hash(ws) {
+            ws.set_property(RICH_HEADER_HASH_PROPERTY, &hash);
+        }
+        Ok(())
+    }
+}
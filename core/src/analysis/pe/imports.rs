@@ -3,9 +3,12 @@ use failure::Error;
 use goblin::Object;
 use log::debug;
 
-use super::super::{
-    super::{arch::RVA, loader::Permissions, workspace::Workspace},
-    Analyzer,
+use super::{
+    super::{
+        super::{arch::RVA, loader::Permissions, symbol::SymbolSource, workspace::Workspace},
+        Analyzer,
+    },
+    ordinals,
 };
 
 pub struct ImportsAnalyzer {}
@@ -229,14 +232,17 @@ impl Analyzer for ImportsAnalyzer {
                             symbols.push((first_thunk, format!("{}!{}", dll_name, imp.name)))
                         }
                     }
-                    ImageThunkData::Ordinal(ord) => symbols.push((first_thunk, format!("{}!#{}", dll_name, ord))),
+                    ImageThunkData::Ordinal(ord) => match ordinals::get_name(&dll_name, ord) {
+                        Some(name) => symbols.push((first_thunk, format!("{}!{}", dll_name, name))),
+                        None => symbols.push((first_thunk, format!("{}!#{}", dll_name, ord))),
+                    },
                 };
             }
         }
 
         for (rva, name) in symbols.iter() {
             debug!("found import: {} -> {}", rva, name);
-            ws.make_symbol(*rva, name)?;
+            ws.make_symbol_from(*rva, name, SymbolSource::Import)?;
             ws.analyze()?;
         }
 
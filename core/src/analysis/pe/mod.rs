@@ -22,8 +22,21 @@ pub use sigs::ByteSigAnalyzer;
 pub mod flirt;
 pub use self::flirt::FlirtAnalyzer;
 
+pub mod rtti;
+pub use rtti::RttiAnalyzer;
+
+pub mod packer;
+pub use packer::PackerAnalyzer;
+
+pub mod hashes;
+pub use hashes::HashesAnalyzer;
+
+pub mod ordinals;
+
+pub mod thunks;
+pub use thunks::ImportThunkAnalyzer;
+
 // TODO: analyzer for global ctors, initializers (__initterm_e, __initterm)
-// TODO: analyzer for import thunks
 // TODO: analyzer for TLS callbacks
 // TODO: analyzer for switch tables (e.g.
 // 748aa5fcfa2af451c76039faf6a8684d:10001AD8) TODO: analyzer for non-returning
@@ -34,7 +47,7 @@ pub use self::flirt::FlirtAnalyzer;
 // heuristic:
 // TODO: analyzer to inspect operands for pointers into the text section, e.g.
 // argument to CreateThread TODO: analyzer for jump-tables, ptr tables, see
-// vivisect/pointertables.py TODO: analyzer for RTTI
+// vivisect/pointertables.py
 // TODO: analyzer for VEH
 // TODO: analyzer for SEH
 
@@ -0,0 +1,59 @@
+/// names of the exports of commonly ordinal-imported DLLs,
+/// so that imports by ordinal can be rendered like `ws2_32.dll!connect`
+/// rather than `ws2_32.dll!#4`.
+use std::collections::HashMap;
+
+use lazy_static::lazy_static;
+use rust_embed::RustEmbed;
+
+#[derive(RustEmbed)]
+#[folder = "$CARGO_MANIFEST_DIR/src/analysis/pe/data/ordinals"]
+struct Assets;
+
+/// parse the bundled `ordinal name` file with the given name.
+fn load(filename: &str) -> HashMap<u32, String> {
+    let buf = Assets::get(filename).unwrap();
+    String::from_utf8_lossy(&buf)
+        .lines()
+        .map(str::trim)
+        .filter(|line| !line.is_empty() && !line.starts_with('#'))
+        .filter_map(|line| {
+            let mut parts = line.splitn(2, ' ');
+            let ordinal = parts.next()?.parse::<u32>().ok()?;
+            let name = parts.next()?.to_string();
+            Some((ordinal, name))
+        })
+        .collect()
+}
+
+lazy_static! {
+    // keyed by the lowercase module name, without extension.
+    static ref ORDINALS: HashMap<&'static str, HashMap<u32, String>> = {
+        let mut ordinals = HashMap::new();
+        ordinals.insert("ws2_32", load("ws2_32.txt"));
+        ordinals.insert("wsock32", load("ws2_32.txt"));
+        ordinals.insert("oleaut32", load("oleaut32.txt"));
+        ordinals
+    };
+}
+
+/// Fetch the name of the export with the given ordinal from the given module,
+///  if it is known. The module name is case-insensitive, and may include an
+///  extension.
+///
+/// ```
+/// use lancelot::analysis::pe::ordinals;
+///
+/// assert_eq!(ordinals::get_name("WS2_32.dll", 4).unwrap(), "connect");
+/// assert_eq!(ordinals::get_name("oleaut32", 6).unwrap(), "SysFreeString");
+/// assert!(ordinals::get_name("kernel32.dll", 1).is_none());
+/// ```
+pub fn get_name(module: &str, ordinal: u32) -> Option<&'static str> {
+    let module = module.to_lowercase();
+    let module = match module.rfind('.') {
+        Some(i) => &module[..i],
+        None => &module[..],
+    };
+
+    ORDINALS.get(module)?.get(&ordinal).map(String::as_str)
+}
@@ -0,0 +1,242 @@
+/// identify the packer or protector used to build a PE file,
+/// from the names of its sections, the stub at its entry point,
+/// and generic indicators like high entropy code or a tiny import table.
+///
+/// the family decides whether the file should be unpacked:
+/// packers only compress the original code, which can be recovered,
+/// while protectors like VMProtect also virtualize it.
+use failure::Error;
+use goblin::Object;
+use log::debug;
+
+use super::super::{
+    super::{
+        arch::RVA,
+        comment::CommentType,
+        loader::{Permissions, Section},
+        workspace::Workspace,
+    },
+    Analyzer,
+};
+
+/// the prefix of the tag applied to the entry point of a packed file.
+pub const PACKER_TAG_PREFIX: &str = "packer:";
+
+/// the family reported when only generic indicators are found.
+pub const UNKNOWN_FAMILY: &str = "unknown";
+
+/// the minimum entropy, in bits per byte, of a section of packed code.
+const PACKED_ENTROPY: f64 = 7.2;
+
+/// the maximum number of imports of a packed file,
+/// which typically imports little more than `LoadLibraryA` and
+/// `GetProcAddress`.
+const PACKED_IMPORTS: usize = 8;
+
+/// the number of generic indicators needed to report an unknown packer.
+const MIN_GENERIC_INDICATORS: usize = 2;
+
+/// families that virtualize or mutate the original code,
+/// so that unpacking does not recover it.
+const PROTECTORS: [&str; 3] = ["Themida", "VMProtect", "Enigma"];
+
+/// section names that are characteristic of a family.
+const SECTION_NAMES: [(&str, &str); 16] = [
+    ("UPX0", "UPX"),
+    ("UPX1", "UPX"),
+    ("UPX2", "UPX"),
+    (".aspack", "ASPack"),
+    (".adata", "ASPack"),
+    (".MPRESS1", "MPRESS"),
+    (".MPRESS2", "MPRESS"),
+    ("PEC2", "PECompact"),
+    ("pec1", "PECompact"),
+    (".petite", "Petite"),
+    (".nsp0", "NsPack"),
+    (".nsp1", "NsPack"),
+    (".themida", "Themida"),
+    (".vmp0", "VMProtect"),
+    (".vmp1", "VMProtect"),
+    (".enigma1", "Enigma"),
+];
+
+/// the stubs at the entry point of each family: family, bytes, and mask.
+const ENTRY_STUBS: [(&str, &[u8], &[u8]); 4] = [
+    (
+        // PUSHAD; MOV ESI, ...; LEA EDI, [ESI+...]
+        "UPX",
+        b"\x60\xBE\x00\x00\x00\x00\x8D\xBE",
+        b"\xFF\xFF\x00\x00\x00\x00\xFF\xFF",
+    ),
+    (
+        // PUSHAD; CALL $+8; JMP ...
+        "ASPack",
+        b"\x60\xE8\x03\x00\x00\x00\xE9\xEB",
+        b"\xFF\xFF\xFF\xFF\xFF\xFF\xFF\xFF",
+    ),
+    (
+        // PUSHAD; CALL $+5; POP EAX; ADD EAX, ...
+        "MPRESS",
+        b"\x60\xE8\x00\x00\x00\x00\x58\x05",
+        b"\xFF\xFF\xFF\xFF\xFF\xFF\xFF\xFF",
+    ),
+    (
+        // MOV EAX, ...; PUSH EAX; PUSH DWORD PTR FS:[0]
+        "PECompact",
+        b"\xB8\x00\x00\x00\x00\x50\x64\xFF\x35\x00\x00\x00\x00",
+        b"\xFF\x00\x00\x00\x00\xFF\xFF\xFF\xFF\xFF\xFF\xFF\xFF",
+    ),
+];
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct PackerDetection {
+    /// the name of the family, like `UPX`, or `UNKNOWN_FAMILY`.
+    pub family:  String,
+    /// the indicators that were found, like `entry point in last section`.
+    pub reasons: Vec<String>,
+}
+
+impl PackerDetection {
+    /// Should an unpacker run?
+    /// Not for protectors, whose original code isn't recoverable.
+    pub fn should_unpack(&self) -> bool {
+        !PROTECTORS.contains(&self.family.as_str())
+    }
+}
+
+/// Identify the family of the stub at the start of the given buffer,
+/// such as the bytes at the entry point.
+///
+/// ```
+/// use lancelot::analysis::pe::packer;
+///
+/// // PUSHAD; MOV ESI, 0x415000; LEA EDI, [ESI-0x14000]
+/// let buf = b"\x60\xBE\x00\x50\x41\x00\x8D\xBE\x00\xC0\xFE\xFF";
+/// assert_eq!(packer::match_entry_stub(buf), Some("UPX"));
+/// assert_eq!(packer::match_entry_stub(b"\x55\x8B\xEC"), None);
+/// ```
+pub fn match_entry_stub(buf: &[u8]) -> Option<&'static str> {
+    ENTRY_STUBS
+        .iter()
+        .find(|(_, bytes, mask)| {
+            buf.len() >= bytes.len()
+                && buf
+                    .iter()
+                    .zip(bytes.iter().zip(mask.iter()))
+                    .all(|(&b, (&p, &m))| b & m == p & m)
+        })
+        .map(|&(family, _, _)| family)
+}
+
+/// Identify the packer or protector used to build the PE file,
+///  or return `None` if it doesn't appear to be packed.
+///
+/// ```
+/// use lancelot::rsrc::*;
+/// use lancelot::workspace::Workspace;
+/// use lancelot::analysis::pe::packer;
+///
+/// let ws = Workspace::from_bytes("k32.dll", &get_buf(Rsrc::K32))
+///    .disable_analysis()
+///    .load().unwrap();
+/// assert!(packer::detect_packer(&ws).unwrap().is_none());
+/// ```
+pub fn detect_packer(ws: &Workspace) -> Result<Option<PackerDetection>, Error> {
+    let pe = match Object::parse(&ws.buf) {
+        Ok(Object::PE(pe)) => pe,
+        _ => return Ok(None),
+    };
+
+    let mut families: Vec<&str> = vec![];
+    let mut reasons: Vec<String> = vec![];
+
+    for section in ws.module.sections.iter() {
+        if let Some(&(_, family)) = SECTION_NAMES.iter().find(|(name, _)| *name == section.name) {
+            families.push(family);
+            reasons.push(format!("section name: {}", section.name));
+        }
+    }
+
+    let entry = RVA::from(pe.entry);
+    if let Some(family) = ws.read_bytes(entry, 0x10).ok().and_then(|buf| match_entry_stub(&buf)) {
+        families.push(family);
+        reasons.push(format!("entry point stub: {}", family));
+    }
+
+    // generic indicators, which don't identify a family.
+    let mut generic = 0;
+
+    let last: Option<&Section> = ws.module.sections.iter().max_by_key(|section| section.addr);
+    if last.map_or(false, |section| ws.module.sections.len() > 1 && section.contains(entry)) {
+        generic += 1;
+        reasons.push("entry point in last section".to_string());
+    }
+
+    for section in ws.module.sections.iter().filter(|section| section.is_executable()) {
+        if section.perms.contains(Permissions::W) {
+            generic += 1;
+            reasons.push(format!("writable code section: {}", section.name));
+        }
+
+        let entropy = ws.get_stats(section.addr, section.size as usize)?.entropy;
+        if entropy >= PACKED_ENTROPY {
+            generic += 1;
+            reasons.push(format!("high entropy code section: {} ({:.2})", section.name, entropy));
+        }
+    }
+
+    if pe.imports.len() <= PACKED_IMPORTS {
+        generic += 1;
+        reasons.push(format!("few imports: {}", pe.imports.len()));
+    }
+
+    // prefer the family of the entry point stub, which is pushed last.
+    let family = match families.last() {
+        Some(family) => family.to_string(),
+        None if generic >= MIN_GENERIC_INDICATORS => UNKNOWN_FAMILY.to_string(),
+        None => return Ok(None),
+    };
+
+    Ok(Some(PackerDetection { family, reasons }))
+}
+
+pub struct PackerAnalyzer {}
+
+impl PackerAnalyzer {
+    #[allow(clippy::new_without_default)]
+    pub fn new() -> PackerAnalyzer {
+        PackerAnalyzer {}
+    }
+}
+
+impl Analyzer for PackerAnalyzer {
+    fn get_name(&self) -> String {
+        "PE packer analyzer".to_string()
+    }
+
+    /// tag and comment the entry point of a packed file with the family,
+    /// like `packer:upx`.
+    fn analyze(&self, ws: &mut Workspace) -> Result<(), Error> {
+        let detection = match detect_packer(ws)? {
+            Some(detection) => detection,
+            None => return Ok(()),
+        };
+
+        let entry = match Object::parse(&ws.buf) {
+            Ok(Object::PE(pe)) => RVA::from(pe.entry),
+            _ => return Ok(()),
+        };
+
+        debug!("packer: {} ({})", detection.family, detection.reasons.join(", "));
+        ws.make_tag(
+            entry,
+            &format!("{}{}", PACKER_TAG_PREFIX, detection.family.to_lowercase()),
+        )?;
+        ws.make_comment(
+            entry,
+            CommentType::Pre,
+            &format!("packer: {} ({})", detection.family, detection.reasons.join(", ")),
+        )?;
+        ws.analyze()
+    }
+}
@@ -0,0 +1,326 @@
+/// this analyzer uses MSVC run-time type information (RTTI) to find the
+/// virtual function tables of C++ classes, and names the tables and the
+/// functions they reference, like `Foo::vfunc_3`.
+///
+/// each method is tagged with its class, like `class:Foo`,
+/// so the methods of a class can be found with `Workspace::find_tagged`,
+/// and its classes are recorded in its metadata.
+///
+/// a function referenced by the vftables of several classes, like
+/// `_purecall` or a method inherited from a base class, isn't named for any
+/// of them, and is tagged `shared-method` instead.
+///
+/// the structures are linked like:
+///
+/// ```text
+///   vftable[-1] -> CompleteObjectLocator -> TypeDescriptor (".?AVFoo@@")
+/// ```
+///
+/// references:
+///   - http://www.openrce.org/articles/full_view/23
+use std::collections::{BTreeMap, HashMap, HashSet};
+
+use failure::Error;
+use log::debug;
+
+use super::super::{
+    super::{
+        arch::{RVA, VA},
+        symbol::SymbolSource,
+        workspace::Workspace,
+    },
+    Analyzer,
+};
+
+/// the prefix of the tag applied to the methods of a class.
+pub const CLASS_TAG_PREFIX: &str = "class:";
+
+/// the tag applied to the functions referenced by the vftables of several
+/// classes.
+pub const SHARED_METHOD_TAG: &str = "shared-method";
+
+/// the maximum number of slots read from a single vftable.
+const MAX_VFTABLE_SLOTS: usize = 0x400;
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct Vftable {
+    pub rva:       RVA,
+    /// the name of the class, like `Foo` or `Bar::Foo`.
+    pub class:     String,
+    /// offset of this vftable's pointer within the object.
+    /// non-zero for the additional vftables of classes with multiple
+    /// inheritance.
+    pub offset:    u32,
+    pub functions: Vec<RVA>,
+}
+
+/// render a type descriptor name like `.?AVFoo@Bar@@` as `Bar::Foo`.
+/// names that are not simple, like templates, are returned undecorated.
+fn parse_class_name(name: &str) -> String {
+    let name = name.get(4..).unwrap_or(name).trim_end_matches('@');
+    if name.contains('?') || name.contains('$') {
+        return name.to_string();
+    }
+
+    let mut parts: Vec<&str> = name.split('@').collect();
+    parts.reverse();
+    parts.join("::")
+}
+
+fn read_pointer(ws: &Workspace, rva: RVA) -> Result<u64, Error> {
+    if ws.loader.get_arch().get_pointer_size() == 8 {
+        ws.read_u64(rva)
+    } else {
+        Ok(u64::from(ws.read_u32(rva)?))
+    }
+}
+
+/// find the aligned locations of the given little-endian values of the given
+/// size, 4 or 8 bytes, across all sections.
+fn find_references(ws: &Workspace, targets: &HashSet<u64>, size: usize) -> Result<HashMap<u64, Vec<RVA>>, Error> {
+    let mut refs: HashMap<u64, Vec<RVA>> = HashMap::new();
+    if targets.is_empty() {
+        return Ok(refs);
+    }
+
+    for section in ws.module.sections.iter() {
+        let buf = ws.read_bytes(section.addr, section.size as usize)?;
+        for (i, chunk) in buf.chunks_exact(size).enumerate() {
+            let value = chunk.iter().rev().fold(0u64, |value, &b| (value << 8) | u64::from(b));
+            if targets.contains(&value) {
+                refs.entry(value).or_insert_with(Vec::new).push(section.addr + i * size);
+            }
+        }
+    }
+
+    Ok(refs)
+}
+
+/// Find the vftables described by RTTI, sorted by address.
+///
+/// ```
+/// use lancelot::test;
+/// use lancelot::arch::RVA;
+/// use lancelot::analysis::pe::rtti;
+///
+/// // 0x01: C3                       RETN
+/// // 0x02: C3                       RETN
+/// // 0x10: 00 00 00 00 00 00 00 00  TypeDescriptor: pVFTable, spare
+/// // 0x18: ".?AVFoo@@"                              name
+/// // 0x24: 00 00 00 00 00 00 00 00  CompleteObjectLocator: signature, offset
+/// // 0x2C: 00 00 00 00 10 00 00 00                         cdOffset, pTypeDescriptor
+/// // 0x34: 00 00 00 00                                     pClassDescriptor
+/// // 0x38: 24 00 00 00              pointer to CompleteObjectLocator
+/// // 0x3C: 01 00 00 00 02 00 00 00  vftable
+/// // 0x44: 00 00 00 00
+/// let ws = test::get_shellcode32_workspace(
+///     b"\x00\xC3\xC3\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\
+///       \x00\x00\x00\x00\x00\x00\x00\x00.?AVFoo@@\x00\x00\x00\
+///       \x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x10\x00\x00\x00\
+///       \x00\x00\x00\x00\x24\x00\x00\x00\x01\x00\x00\x00\x02\x00\x00\x00\
+///       \x00\x00\x00\x00",
+/// );
+/// let vftables = rtti::find_vftables(&ws).unwrap();
+/// assert_eq!(vftables.len(), 1);
+/// assert_eq!(vftables[0].rva, RVA(0x3C));
+/// assert_eq!(vftables[0].class, "Foo");
+/// assert_eq!(vftables[0].functions, vec![RVA(0x1), RVA(0x2)]);
+/// ```
+pub fn find_vftables(ws: &Workspace) -> Result<Vec<Vftable>, Error> {
+    let psize = ws.loader.get_arch().get_pointer_size() as usize;
+    let is_64 = psize == 8;
+
+    // TypeDescriptors are referenced by VA on x86, and by RVA on x64.
+    let mut descriptors: HashMap<u64, String> = HashMap::new();
+    let mut names = ws.search_bytes(b".?AV", b"\xFF\xFF\xFF\xFF")?;
+    names.extend(ws.search_bytes(b".?AU", b"\xFF\xFF\xFF\xFF")?);
+    for name in names.into_iter() {
+        let descriptor = name - RVA::from(2 * psize);
+        let value = if is_64 {
+            descriptor.0 as u64
+        } else {
+            match ws.va(descriptor) {
+                Some(va) => va.into(),
+                None => continue,
+            }
+        };
+
+        if let Ok(name) = ws.read_utf8(name) {
+            descriptors.insert(value, parse_class_name(&name));
+        }
+    }
+
+    // CompleteObjectLocators have a signature of 0 on x86, and 1 on x64,
+    // and reference their TypeDescriptor at offset 0xC.
+    let signature = if is_64 { 1 } else { 0 };
+    let mut locators: HashMap<u64, (String, u32)> = HashMap::new();
+    let targets: HashSet<u64> = descriptors.keys().cloned().collect();
+    for (value, refs) in find_references(ws, &targets, 4)?.into_iter() {
+        for r in refs.into_iter() {
+            let locator = r - RVA::from(0xCusize);
+            if ws.read_u32(locator).ok() != Some(signature) {
+                continue;
+            }
+
+            let offset = ws.read_u32(locator + 4)?;
+            if let Some(va) = ws.va(locator) {
+                locators.insert(va.into(), (descriptors[&value].clone(), offset));
+            }
+        }
+    }
+
+    // each vftable is preceded by a pointer to its CompleteObjectLocator.
+    let mut vftables = vec![];
+    let targets: HashSet<u64> = locators.keys().cloned().collect();
+    for (value, refs) in find_references(ws, &targets, psize)?.into_iter() {
+        for r in refs.into_iter() {
+            let rva = r + psize;
+
+            let mut functions = vec![];
+            for i in 0..MAX_VFTABLE_SLOTS {
+                let ptr = match read_pointer(ws, rva + i * psize) {
+                    Ok(ptr) if ptr != 0 => ptr,
+                    _ => break,
+                };
+
+                match ws.rva(VA::from(ptr)) {
+                    Some(function) if ws.get_section(function).map_or(false, |s| s.is_executable()) => {
+                        functions.push(function)
+                    }
+                    _ => break,
+                }
+            }
+
+            let (class, offset) = locators[&value].clone();
+            vftables.push(Vftable {
+                rva,
+                class,
+                offset,
+                functions,
+            });
+        }
+    }
+
+    vftables.sort_by(|a, b| a.rva.cmp(&b.rva));
+    Ok(vftables)
+}
+
+pub struct RttiAnalyzer {}
+
+impl RttiAnalyzer {
+    #[allow(clippy::new_without_default)]
+    pub fn new() -> RttiAnalyzer {
+        RttiAnalyzer {}
+    }
+}
+
+impl Analyzer for RttiAnalyzer {
+    fn get_name(&self) -> String {
+        "RTTI analyzer".to_string()
+    }
+
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::analysis::Analyzer;
+    /// use lancelot::analysis::pe::rtti::{RttiAnalyzer, SHARED_METHOD_TAG};
+    ///
+    /// // 0x01: C3                       RETN
+    /// // 0x02: C3                       RETN
+    /// // 0x03: C3                       RETN
+    /// // 0x10: 00 00 00 00 00 00 00 00  TypeDescriptor: pVFTable, spare
+    /// // 0x18: ".?AVFoo@@"                              name
+    /// // 0x24: 00 00 00 00 00 00 00 00  CompleteObjectLocator: signature, offset
+    /// // 0x2C: 00 00 00 00 10 00 00 00                         cdOffset, pTypeDescriptor
+    /// // 0x34: 00 00 00 00                                     pClassDescriptor
+    /// // 0x38: 24 00 00 00              pointer to CompleteObjectLocator
+    /// // 0x3C: 01 00 00 00 02 00 00 00  vftable
+    /// // 0x44: 00 00 00 00
+    /// // 0x48: 00 00 00 00 00 00 00 00  TypeDescriptor: pVFTable, spare
+    /// // 0x50: ".?AVBar@@"                              name
+    /// // 0x5C: 00 00 00 00 00 00 00 00  CompleteObjectLocator: signature, offset
+    /// // 0x64: 00 00 00 00 48 00 00 00                         cdOffset, pTypeDescriptor
+    /// // 0x6C: 00 00 00 00                                     pClassDescriptor
+    /// // 0x70: 5C 00 00 00              pointer to CompleteObjectLocator
+    /// // 0x74: 03 00 00 00 02 00 00 00  vftable
+    /// // 0x7C: 00 00 00 00
+    /// let mut ws = test::get_shellcode32_workspace(
+    ///     b"\x00\xC3\xC3\xC3\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\
+    ///       \x00\x00\x00\x00\x00\x00\x00\x00.?AVFoo@\
+    ///       @\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\
+    ///       \x10\x00\x00\x00\x00\x00\x00\x00\x24\x00\x00\x00\x01\x00\x00\x00\
+    ///       \x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\
+    ///       .?AVBar@@\x00\x00\x00\x00\x00\x00\x00\
+    ///       \x00\x00\x00\x00\x00\x00\x00\x00\x48\x00\x00\x00\x00\x00\x00\x00\
+    ///       \x5C\x00\x00\x00\x03\x00\x00\x00\x02\x00\x00\x00\x00\x00\x00\x00",
+    /// );
+    /// RttiAnalyzer::new().analyze(&mut ws).unwrap();
+    ///
+    /// assert_eq!(ws.get_symbol(RVA(0x3C)).unwrap(), "Foo::`vftable'");
+    /// assert_eq!(ws.get_symbol(RVA(0x1)).unwrap(), "Foo::vfunc_0");
+    /// assert_eq!(ws.get_symbol(RVA(0x3)).unwrap(), "Bar::vfunc_0");
+    /// assert_eq!(ws.find_tagged("class:Foo"), vec![RVA(0x1), RVA(0x2)]);
+    ///
+    /// // the shared function isn't named for either class.
+    /// assert!(ws.get_symbol(RVA(0x2)).is_none());
+    /// assert_eq!(ws.find_tagged(SHARED_METHOD_TAG), vec![RVA(0x2)]);
+    /// assert_eq!(ws.get_function_meta(RVA(0x2)).unwrap().classes, vec!["Bar", "Foo"]);
+    /// assert_eq!(ws.get_function_meta(RVA(0x1)).unwrap().classes, vec!["Foo"]);
+    /// ```
+    fn analyze(&self, ws: &mut Workspace) -> Result<(), Error> {
+        // the classes of each function referenced by a vftable, and the name
+        // of its first slot.
+        let mut methods: BTreeMap<RVA, (Vec<String>, String)> = BTreeMap::new();
+        for vftable in find_vftables(ws)?.into_iter() {
+            debug!(
+                "vftable: {} {} ({} functions)",
+                vftable.rva,
+                vftable.class,
+                vftable.functions.len()
+            );
+
+            // classes with multiple inheritance have a vftable per base class.
+            let prefix = if vftable.offset == 0 {
+                vftable.class.clone()
+            } else {
+                format!("{}_{:x}", vftable.class, vftable.offset)
+            };
+            ws.make_symbol_from(vftable.rva, &format!("{}::`vftable'", prefix), SymbolSource::Analysis)?;
+
+            for (i, &function) in vftable.functions.iter().enumerate() {
+                let (classes, _) = methods
+                    .entry(function)
+                    .or_insert_with(|| (vec![], format!("{}::vfunc_{}", prefix, i)));
+                if !classes.contains(&vftable.class) {
+                    classes.push(vftable.class.clone());
+                }
+            }
+        }
+
+        for (&function, (classes, name)) in methods.iter_mut() {
+            classes.sort();
+
+            ws.make_function_from(function, &self.get_name())?;
+            for class in classes.iter() {
+                ws.make_tag(function, &format!("{}{}", CLASS_TAG_PREFIX, class))?;
+            }
+            if classes.len() == 1 {
+                ws.make_symbol_from(function, name, SymbolSource::Analysis)?;
+            } else {
+                ws.make_tag(function, SHARED_METHOD_TAG)?;
+            }
+        }
+        ws.analyze()?;
+
+        for (function, (classes, _)) in methods.into_iter() {
+            let mut meta = match ws.get_function_meta(function) {
+                Some(meta) => meta.clone(),
+                None => continue,
+            };
+            meta.classes = classes;
+            ws.set_function_meta(function, meta)?;
+        }
+
+        Ok(())
+    }
+}
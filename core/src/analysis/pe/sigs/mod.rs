@@ -683,7 +683,7 @@ impl Analyzer for ByteSigAnalyzer {
 
         for rva in functions.iter() {
             debug!("found function by signature: {}", rva);
-            ws.make_function(*rva).unwrap();
+            ws.make_function_from(*rva, &self.get_name()).unwrap();
             ws.analyze().unwrap();
         }
 
@@ -0,0 +1,183 @@
+/// recognize import thunks, functions that only jump through an import
+/// address table entry, like `JMP [__imp_CreateFileA]`,
+/// and apply the prototype of the import from the type library.
+///
+/// calls to imports, directly or via a thunk, are also resolved to their
+/// prototype here, so that the lifter can account for the arguments that a
+/// `stdcall` import pops from the stack.
+use failure::Error;
+use zydis;
+
+use super::super::{
+    super::{
+        arch::{Arch, RVA, VA},
+        function::CallingConvention,
+        symbol::SymbolSource,
+        types::Prototype,
+        workspace::Workspace,
+    },
+    get_first_operand, AnalysisPhase, Analyzer,
+};
+
+/// the import address table entry read by the given memory operand,
+/// like `[0x401000]` or, on x64, `[rip+0x1000]`.
+fn get_import_slot(
+    ws: &Workspace,
+    rva: RVA,
+    insn: &zydis::DecodedInstruction,
+    op: &zydis::DecodedOperand,
+) -> Option<RVA> {
+    if op.ty != zydis::OperandType::MEMORY || op.mem.index != zydis::Register::NONE {
+        return None;
+    }
+
+    let slot = match op.mem.base {
+        zydis::Register::NONE if op.mem.disp.displacement >= 0 => ws.rva(VA::from(op.mem.disp.displacement as u64))?,
+        zydis::Register::RIP => rva + RVA::from(op.mem.disp.displacement) + insn.length,
+        _ => return None,
+    };
+
+    match ws.get_symbol_source(slot) {
+        Some(SymbolSource::Import) => Some(slot),
+        _ => None,
+    }
+}
+
+/// The import address table entry that the thunk at the given address jumps
+/// through, if the function is an import thunk.
+pub fn get_thunk_target(ws: &Workspace, rva: RVA) -> Option<RVA> {
+    let insn = ws.read_insn(rva).ok()?;
+    if insn.mnemonic != zydis::Mnemonic::JMP {
+        return None;
+    }
+    get_import_slot(ws, rva, &insn, get_first_operand(&insn)?)
+}
+
+/// Fetch the prototype of the import called by the instruction at the given
+/// address, either directly, like `CALL [__imp_CloseHandle]`, or via an import
+/// thunk.
+///
+/// ```
+/// use lancelot::test;
+/// use lancelot::arch::RVA;
+/// use lancelot::symbol::SymbolSource;
+/// use lancelot::analysis::pe::thunks;
+///
+/// // 0: FF 15 20 00 00 00  CALL [0x20]
+/// // 6: E8 01 00 00 00     CALL 0xC
+/// // B: C3                 RETN
+/// // C: FF 25 20 00 00 00  JMP [0x20]
+/// // 12: ...               padding
+/// // 20: 00 00 00 00       kernel32.dll!CloseHandle
+/// let mut ws = test::get_shellcode32_workspace(
+///     b"\xFF\x15\x20\x00\x00\x00\xE8\x01\x00\x00\x00\xC3\xFF\x25\x20\x00\
+///       \x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\
+///       \x00\x00\x00\x00",
+/// );
+/// ws.make_symbol_from(RVA(0x20), "kernel32.dll!CloseHandle", SymbolSource::Import)
+///     .unwrap();
+/// ws.analyze().unwrap();
+///
+/// assert_eq!(thunks::get_callee_prototype(&ws, RVA(0x0)).unwrap().name, "CloseHandle");
+/// assert_eq!(thunks::get_callee_prototype(&ws, RVA(0x6)).unwrap().name, "CloseHandle");
+/// assert!(thunks::get_callee_prototype(&ws, RVA(0xB)).is_none());
+/// ```
+pub fn get_callee_prototype(ws: &Workspace, rva: RVA) -> Option<&Prototype> {
+    let insn = ws.read_insn(rva).ok()?;
+    if insn.mnemonic != zydis::Mnemonic::CALL {
+        return None;
+    }
+
+    let op = get_first_operand(&insn)?;
+    let slot = match op.ty {
+        zydis::OperandType::MEMORY => get_import_slot(ws, rva, &insn, op)?,
+        zydis::OperandType::IMMEDIATE => {
+            let xref = ws.get_call_insn_flow(rva, &insn).ok()?.into_iter().next()?;
+            get_thunk_target(ws, xref.dst)?
+        }
+        _ => return None,
+    };
+
+    ws.get_symbol(slot).and_then(|name| ws.get_prototype(name))
+}
+
+pub struct ImportThunkAnalyzer {}
+
+impl ImportThunkAnalyzer {
+    #[allow(clippy::new_without_default)]
+    pub fn new() -> ImportThunkAnalyzer {
+        ImportThunkAnalyzer {}
+    }
+}
+
+impl Analyzer for ImportThunkAnalyzer {
+    fn get_name(&self) -> String {
+        "PE import thunk analyzer".to_string()
+    }
+
+    fn get_phase(&self) -> AnalysisPhase {
+        AnalysisPhase::Functions
+    }
+
+    fn get_dependencies(&self) -> Vec<String> {
+        vec!["PE imports analyzer".to_string()]
+    }
+
+    /// record the calling convention and argument count of each import thunk
+    /// in its metadata, from the prototype of the import.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::symbol::SymbolSource;
+    /// use lancelot::function::CallingConvention;
+    /// use lancelot::analysis::Analyzer;
+    /// use lancelot::analysis::pe::ImportThunkAnalyzer;
+    ///
+    /// // 0: FF 25 10 00 00 00  JMP [0x10]
+    /// // 6: ...                padding
+    /// // 10: 00 00 00 00       kernel32.dll!CreateFileA
+    /// let mut ws = test::get_shellcode32_workspace(
+    ///     b"\xFF\x25\x10\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\
+    ///       \x00\x00\x00\x00",
+    /// );
+    /// ws.make_symbol_from(RVA(0x10), "kernel32.dll!CreateFileA", SymbolSource::Import)
+    ///     .unwrap();
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// ImportThunkAnalyzer::new().analyze(&mut ws).unwrap();
+    /// let meta = ws.get_function_meta(RVA(0x0)).unwrap();
+    /// assert_eq!(meta.calling_convention, CallingConvention::Stdcall);
+    /// assert_eq!(meta.argument_count, Some(7));
+    /// ```
+    fn analyze(&self, ws: &mut Workspace) -> Result<(), Error> {
+        let arch = ws.loader.get_arch();
+        let functions: Vec<RVA> = ws.get_functions().cloned().collect();
+
+        for function in functions.into_iter() {
+            let proto = match get_thunk_target(ws, function)
+                .and_then(|slot| ws.get_symbol(slot))
+                .and_then(|name| ws.get_prototype(name))
+            {
+                Some(proto) => proto.clone(),
+                None => continue,
+            };
+
+            let mut meta = match ws.get_function_meta(function) {
+                Some(meta) => meta.clone(),
+                None => continue,
+            };
+            meta.calling_convention = match arch {
+                Arch::X32 => proto.calling_convention,
+                // there's a single calling convention on x64.
+                Arch::X64 => CallingConvention::Win64,
+            };
+            meta.argument_count = Some(proto.arguments.len() as u32);
+
+            ws.set_function_meta(function, meta)?;
+        }
+
+        Ok(())
+    }
+}
@@ -0,0 +1,358 @@
+//! Lancelot's own function signatures: a masked byte pattern of the start of a
+//!  function, plus constraints on the names of the functions it calls.
+//!
+//! Signatures are generated from a workspace with named functions, like a
+//!  library with symbols, and then applied to other workspaces with the
+//!  `SignatureAnalyzer` to name the matching functions.
+//!
+//! The document layout is:
+//!
+//! ```json
+//! {
+//!   "signatures": [{
+//!     "name": "memcpy",
+//!     "pattern": "558bec8b4508e8........c3",
+//!     "references": [{"offset": 6, "name": "helper"}]
+//!   }]
+//! }
+//! ```
+//!
+//! where the pattern is hex, with `..` for a wildcard byte,
+//! and each reference requires a call at the given offset to a function with
+//! the given name.
+use std::collections::HashSet;
+
+use failure::{Error, Fail};
+use log::debug;
+use serde_json::{json, Value};
+
+use super::{
+    super::{arch::RVA, symbol::SymbolSource, workspace::Workspace, xref::XrefType},
+    pe::flirt::LIBRARY_TAG,
+    AnalysisPhase, Analyzer,
+};
+
+/// the maximum number of bytes from the start of a function
+/// covered by a generated signature.
+pub const MAX_SIGNATURE_LENGTH: usize = 0x40;
+
+/// the minimum number of non-wildcard bytes in a generated signature,
+/// so that short or generic functions don't produce false positives.
+pub const MIN_SIGNATURE_BYTES: usize = 0x8;
+
+#[derive(Debug, Fail)]
+pub enum SignatureError {
+    #[fail(display = "Invalid signature document")]
+    InvalidDocument,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct Reference {
+    /// offset of the calling instruction from the start of the function.
+    pub offset: usize,
+    /// name of the called function.
+    pub name:   String,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct Signature {
+    pub name:       String,
+    pub bytes:      Vec<u8>,
+    /// `0xFF` for bytes that must match, `0x00` for wildcards.
+    pub mask:       Vec<u8>,
+    pub references: Vec<Reference>,
+}
+
+fn render_pattern(sig: &Signature) -> String {
+    sig.bytes
+        .iter()
+        .zip(sig.mask.iter())
+        .map(|(&b, &m)| {
+            if m == 0x00 {
+                "..".to_string()
+            } else {
+                format!("{:02x}", b)
+            }
+        })
+        .collect()
+}
+
+fn parse_pattern(pattern: &str) -> Result<(Vec<u8>, Vec<u8>), Error> {
+    if pattern.len() % 2 != 0 {
+        return Err(SignatureError::InvalidDocument.into());
+    }
+
+    let mut bytes = vec![];
+    let mut mask = vec![];
+    for i in (0..pattern.len()).step_by(2) {
+        let s = pattern.get(i..i + 2).ok_or(SignatureError::InvalidDocument)?;
+        if s == ".." {
+            bytes.push(0x00);
+            mask.push(0x00);
+        } else {
+            bytes.push(u8::from_str_radix(s, 16).map_err(|_| SignatureError::InvalidDocument)?);
+            mask.push(0xFF);
+        }
+    }
+    Ok((bytes, mask))
+}
+
+/// Serialize the given signatures into a JSON document.
+pub fn render(sigs: &[Signature]) -> Value {
+    json!({
+        "signatures": sigs
+            .iter()
+            .map(|sig| {
+                json!({
+                    "name": sig.name,
+                    "pattern": render_pattern(sig),
+                    "references": sig
+                        .references
+                        .iter()
+                        .map(|r| json!({"offset": r.offset, "name": r.name}))
+                        .collect::<Vec<_>>(),
+                })
+            })
+            .collect::<Vec<_>>(),
+    })
+}
+
+/// Parse signatures from the given JSON document.
+///
+/// Errors:
+///
+///   - InvalidDocument - if the document is missing expected fields.
+pub fn parse(doc: &Value) -> Result<Vec<Signature>, Error> {
+    let sigs = doc
+        .get("signatures")
+        .and_then(Value::as_array)
+        .ok_or(SignatureError::InvalidDocument)?;
+
+    sigs.iter()
+        .map(|sig| {
+            let name = sig.get("name").and_then(Value::as_str);
+            let pattern = sig.get("pattern").and_then(Value::as_str);
+            let (name, pattern) = match (name, pattern) {
+                (Some(name), Some(pattern)) => (name, pattern),
+                _ => return Err(SignatureError::InvalidDocument.into()),
+            };
+            let (bytes, mask) = parse_pattern(pattern)?;
+
+            let references = match sig.get("references").and_then(Value::as_array) {
+                Some(references) => references
+                    .iter()
+                    .map(|r| {
+                        let offset = r.get("offset").and_then(Value::as_u64);
+                        let name = r.get("name").and_then(Value::as_str);
+                        match (offset, name) {
+                            (Some(offset), Some(name)) => Ok(Reference {
+                                offset: offset as usize,
+                                name:   name.to_string(),
+                            }),
+                            _ => Err(SignatureError::InvalidDocument.into()),
+                        }
+                    })
+                    .collect::<Result<Vec<_>, Error>>()?,
+                None => vec![],
+            };
+
+            Ok(Signature {
+                name: name.to_string(),
+                bytes,
+                mask,
+                references,
+            })
+        })
+        .collect()
+}
+
+/// Generate a signature for the function that starts at the given address,
+///  covering the instructions laid out contiguously from its start.
+///
+/// Returns `None` when the function has too few fixed bytes to be
+/// distinctive.
+fn generate_one(ws: &Workspace, rva: RVA, name: &str) -> Result<Option<Signature>, Error> {
+    let mut bbs = ws.get_basic_blocks(rva)?;
+    bbs.sort_by(|a, b| a.addr.cmp(&b.addr));
+
+    let mut bytes = vec![];
+    let mut mask = vec![];
+    let mut references = vec![];
+
+    'blocks: for bb in bbs.iter() {
+        if bb.addr != rva + bytes.len() {
+            // stop at the first gap in the layout.
+            break;
+        }
+
+        for &insn in bb.insns.iter() {
+            let (insn_bytes, insn_mask) = ws.read_insn_masked(insn)?;
+            if bytes.len() + insn_bytes.len() > MAX_SIGNATURE_LENGTH {
+                break 'blocks;
+            }
+
+            for xref in ws.get_xrefs_from(insn)?.iter() {
+                if xref.typ != XrefType::Call {
+                    continue;
+                }
+                // only constrain on real names, not automatic ones.
+                if let Some(target) = ws.get_symbol(xref.dst) {
+                    references.push(Reference {
+                        offset: bytes.len(),
+                        name:   target.to_string(),
+                    });
+                }
+            }
+
+            bytes.extend(insn_bytes);
+            mask.extend(insn_mask);
+        }
+    }
+
+    if mask.iter().filter(|&&m| m != 0x00).count() < MIN_SIGNATURE_BYTES {
+        return Ok(None);
+    }
+
+    Ok(Some(Signature {
+        name: name.to_string(),
+        bytes,
+        mask,
+        references,
+    }))
+}
+
+/// Generate signatures for each function in the workspace that has a symbol.
+///
+/// ```
+/// use lancelot::test;
+/// use lancelot::arch::RVA;
+/// use lancelot::analysis::{Analyzer, signatures::{self, SignatureAnalyzer}};
+///
+/// // 0: 55              PUSH EBP
+/// // 1: 8B EC           MOV EBP, ESP
+/// // 3: E8 04 00 00 00  CALL $+9
+/// // 8: 8B E5           MOV ESP, EBP
+/// // A: 5D              POP EBP
+/// // B: C3              RETN
+/// // C: C3              RETN
+/// let buf = b"\x55\x8B\xEC\xE8\x04\x00\x00\x00\x8B\xE5\x5D\xC3\xC3";
+/// let mut lib = test::get_shellcode32_workspace(buf);
+/// lib.make_function(RVA(0x0)).unwrap();
+/// lib.make_symbol(RVA(0x0), "outer").unwrap();
+/// lib.make_symbol(RVA(0xC), "inner").unwrap();
+/// lib.analyze().unwrap();
+///
+/// let sigs = signatures::generate(&lib).unwrap();
+/// assert_eq!(sigs.len(), 1);
+/// assert_eq!(sigs[0].name, "outer");
+/// assert_eq!(sigs[0].references[0].offset, 0x3);
+///
+/// // round trip through the document format.
+/// let sigs = signatures::parse(&signatures::render(&sigs)).unwrap();
+///
+/// let mut ws = test::get_shellcode32_workspace(buf);
+/// ws.make_function(RVA(0x0)).unwrap();
+/// ws.analyze().unwrap();
+///
+/// // the call reference isn't satisfied until the callee is named.
+/// SignatureAnalyzer::new(sigs.clone()).analyze(&mut ws).unwrap();
+/// assert!(ws.get_symbol(RVA(0x0)).is_none());
+///
+/// ws.make_symbol(RVA(0xC), "inner").unwrap();
+/// ws.analyze().unwrap();
+/// SignatureAnalyzer::new(sigs).analyze(&mut ws).unwrap();
+/// assert_eq!(ws.get_symbol(RVA(0x0)).unwrap(), "outer");
+/// ```
+pub fn generate(ws: &Workspace) -> Result<Vec<Signature>, Error> {
+    let mut functions: Vec<RVA> = ws.get_functions().cloned().collect();
+    functions.sort();
+
+    let mut sigs = vec![];
+    for rva in functions.into_iter() {
+        let name = match ws.get_symbol(rva) {
+            Some(name) => name.clone(),
+            None => continue,
+        };
+
+        if let Some(sig) = generate_one(ws, rva, &name)? {
+            sigs.push(sig);
+        }
+    }
+
+    debug!("generated {} signatures", sigs.len());
+    Ok(sigs)
+}
+
+pub struct SignatureAnalyzer {
+    sigs: Vec<Signature>,
+}
+
+impl SignatureAnalyzer {
+    pub fn new(sigs: Vec<Signature>) -> SignatureAnalyzer {
+        SignatureAnalyzer { sigs }
+    }
+
+    fn is_match(ws: &Workspace, rva: RVA, sig: &Signature) -> bool {
+        let buf = match ws.read_bytes(rva, sig.bytes.len()) {
+            Ok(buf) => buf,
+            Err(_) => return false,
+        };
+
+        let bytes_match = buf
+            .iter()
+            .zip(sig.bytes.iter().zip(sig.mask.iter()))
+            .all(|(&b, (&s, &m))| b & m == s & m);
+        if !bytes_match {
+            return false;
+        }
+
+        sig.references.iter().all(|r| match ws.get_xrefs_from(rva + r.offset) {
+            Ok(xrefs) => xrefs
+                .iter()
+                .any(|xref| xref.typ == XrefType::Call && ws.get_symbol(xref.dst) == Some(&r.name)),
+            Err(_) => false,
+        })
+    }
+}
+
+impl Analyzer for SignatureAnalyzer {
+    fn get_name(&self) -> String {
+        "signature analyzer".to_string()
+    }
+
+    fn get_phase(&self) -> AnalysisPhase {
+        AnalysisPhase::Functions
+    }
+
+    /// name the functions without symbols that match exactly one signature
+    /// name, and tag them as library code.
+    fn analyze(&self, ws: &mut Workspace) -> Result<(), Error> {
+        let functions: Vec<RVA> = ws
+            .get_functions()
+            .filter(|&&rva| ws.get_symbol(rva).is_none())
+            .cloned()
+            .collect();
+
+        for rva in functions.into_iter() {
+            let names: HashSet<&String> = self
+                .sigs
+                .iter()
+                .filter(|sig| SignatureAnalyzer::is_match(ws, rva, sig))
+                .map(|sig| &sig.name)
+                .collect();
+
+            match names.len() {
+                0 => {}
+                1 => {
+                    let name = names.into_iter().next().unwrap();
+                    debug!("signature match: {} {}", rva, name);
+                    ws.make_symbol_from(rva, name, SymbolSource::Signature)?;
+                    ws.make_tag(rva, LIBRARY_TAG)?;
+                }
+                _ => debug!("ambiguous signature match: {}: {:?}", rva, names),
+            }
+        }
+
+        ws.analyze()
+    }
+}
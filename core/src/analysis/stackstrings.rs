@@ -0,0 +1,233 @@
+/// recognize strings that are built on the stack from immediates, such as:
+///
+/// ```text
+///   MOV BYTE PTR [EBP-0x8], 0x68  ; 'h'
+///   MOV BYTE PTR [EBP-0x7], 0x69  ; 'i'
+///   MOV BYTE PTR [EBP-0x6], 0x0
+/// ```
+///
+/// and comment the first store with the reassembled string.
+/// malware does this to hide strings from a scan of the file.
+///
+/// the stores are reassembled statically, without emulation,
+/// so only consecutive stores within a basic block are recognized.
+use std::collections::{BTreeMap, HashSet};
+
+use failure::Error;
+use log::debug;
+use zydis;
+
+use super::{
+    super::{arch::RVA, comment::CommentType, strings::StringEncoding, workspace::Workspace},
+    strings::DEFAULT_MIN_LENGTH,
+    AnalysisPhase, Analyzer,
+};
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct StackString {
+    /// the address of the first store instruction.
+    pub rva:      RVA,
+    pub encoding: StringEncoding,
+    pub text:     String,
+}
+
+fn is_text(b: u8) -> bool {
+    (b >= 0x20 && b <= 0x7E) || b == b'\t' || b == b'\r' || b == b'\n'
+}
+
+/// decode a store of an immediate to the stack, like
+/// `MOV DWORD PTR [EBP-0x8], 0x6C6C6568`, into the frame register,
+/// the offset, and the bytes stored.
+fn get_stack_store(insn: &zydis::DecodedInstruction) -> Option<(zydis::Register, i64, Vec<u8>)> {
+    if insn.mnemonic != zydis::Mnemonic::MOV {
+        return None;
+    }
+
+    let dst = &insn.operands[0];
+    let src = &insn.operands[1];
+    if dst.ty != zydis::OperandType::MEMORY
+        || src.ty != zydis::OperandType::IMMEDIATE
+        || dst.mem.index != zydis::Register::NONE
+    {
+        return None;
+    }
+
+    match dst.mem.base {
+        zydis::Register::EBP | zydis::Register::ESP | zydis::Register::RBP | zydis::Register::RSP => {}
+        _ => return None,
+    }
+
+    let size = match dst.size {
+        8 => 1,
+        16 => 2,
+        32 => 4,
+        // the immediate is sign-extended to 64 bits.
+        64 => 8,
+        _ => return None,
+    };
+
+    let bytes = src.imm.value.to_le_bytes()[..size].to_vec();
+    Some((dst.mem.base, dst.mem.disp.displacement, bytes))
+}
+
+/// decode the bytes stored contiguously from the lowest offset,
+/// up to the first NULL, as either ASCII or UTF-16LE.
+fn decode(stores: &BTreeMap<i64, u8>, min_length: usize) -> Option<(StringEncoding, String)> {
+    let mut buf = vec![];
+    let mut next = *stores.keys().next()?;
+    for (&offset, &b) in stores.iter() {
+        if offset != next {
+            break;
+        }
+        buf.push(b);
+        next += 1;
+    }
+
+    let ascii: Vec<u8> = buf.iter().cloned().take_while(|&b| b != 0).collect();
+    if ascii.len() >= min_length && ascii.iter().all(|&b| is_text(b)) {
+        // this is ASCII, and therefore able to be decoded.
+        return Some((StringEncoding::Ascii, String::from_utf8(ascii).unwrap()));
+    }
+
+    let words: Vec<u16> = buf
+        .chunks_exact(2)
+        .map(|w| u16::from(w[1]) << 8 | u16::from(w[0]))
+        .take_while(|&w| w != 0)
+        .collect();
+    if words.len() >= min_length && words.iter().all(|&w| w < 0x80 && is_text(w as u8)) {
+        return String::from_utf16(&words)
+            .ok()
+            .map(|text| (StringEncoding::Utf16, text));
+    }
+
+    None
+}
+
+/// Find the strings built on the stack by the instructions of all functions,
+/// sorted by address.
+///
+/// ```
+/// use lancelot::test;
+/// use lancelot::arch::RVA;
+/// use lancelot::strings::StringEncoding;
+/// use lancelot::analysis::stackstrings::{self, StackString};
+///
+/// // 0: C6 45 F8 68              MOV BYTE PTR [EBP-0x8], 0x68
+/// // 4: C6 45 F9 65              MOV BYTE PTR [EBP-0x7], 0x65
+/// // 8: C7 45 FA 6C 6C 6F 00     MOV DWORD PTR [EBP-0x6], 0x6F6C6C
+/// // F: C3                       RETN
+/// let mut ws = test::get_shellcode32_workspace(
+///     b"\xC6\x45\xF8\x68\xC6\x45\xF9\x65\xC7\x45\xFA\x6C\x6C\x6F\x00\xC3",
+/// );
+/// ws.make_function(RVA(0x0)).unwrap();
+/// ws.analyze().unwrap();
+///
+/// assert_eq!(
+///     stackstrings::find_stack_strings(&ws, 4).unwrap(),
+///     vec![StackString {
+///         rva:      RVA(0x0),
+///         encoding: StringEncoding::Ascii,
+///         text:     "hello".to_string(),
+///     }]
+/// );
+/// assert!(stackstrings::find_stack_strings(&ws, 6).unwrap().is_empty());
+/// ```
+pub fn find_stack_strings(ws: &Workspace, min_length: usize) -> Result<Vec<StackString>, Error> {
+    // each run of stores: its first instruction and the bytes stored at each
+    // offset.
+    let mut runs: Vec<(RVA, BTreeMap<i64, u8>)> = vec![];
+
+    // basic blocks may be shared by multiple functions.
+    let mut seen: HashSet<RVA> = HashSet::new();
+    for &function in ws.get_functions() {
+        let bbs = match ws.get_basic_blocks(function) {
+            Ok(bbs) => bbs,
+            Err(_) => continue,
+        };
+
+        for bb in bbs.into_iter() {
+            if !seen.insert(bb.addr) {
+                continue;
+            }
+
+            // the frame register of the current run, if any.
+            let mut register: Option<zydis::Register> = None;
+            for &rva in bb.insns.iter() {
+                let (base, offset, bytes) = match ws.read_insn(rva).ok().and_then(|insn| get_stack_store(&insn)) {
+                    Some(store) => store,
+                    None => {
+                        register = None;
+                        continue;
+                    }
+                };
+
+                if register != Some(base) {
+                    register = Some(base);
+                    runs.push((rva, BTreeMap::new()));
+                }
+
+                // the run was pushed above, so it exists.
+                let (_, stores) = runs.last_mut().unwrap();
+                for (i, b) in bytes.into_iter().enumerate() {
+                    stores.insert(offset + i as i64, b);
+                }
+            }
+        }
+    }
+
+    let mut strings: Vec<StackString> = runs
+        .into_iter()
+        .filter_map(|(rva, stores)| {
+            decode(&stores, min_length).map(|(encoding, text)| StackString { rva, encoding, text })
+        })
+        .collect();
+    strings.sort_by(|a, b| a.rva.cmp(&b.rva));
+    Ok(strings)
+}
+
+pub struct StackStringAnalyzer {}
+
+impl StackStringAnalyzer {
+    #[allow(clippy::new_without_default)]
+    pub fn new() -> StackStringAnalyzer {
+        StackStringAnalyzer {}
+    }
+}
+
+impl Analyzer for StackStringAnalyzer {
+    fn get_name(&self) -> String {
+        "stack string analyzer".to_string()
+    }
+
+    fn get_phase(&self) -> AnalysisPhase {
+        AnalysisPhase::Functions
+    }
+
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::comment::CommentType;
+    /// use lancelot::analysis::Analyzer;
+    /// use lancelot::analysis::stackstrings::StackStringAnalyzer;
+    ///
+    /// // 0: C7 45 F8 65 76 69 6C     MOV DWORD PTR [EBP-0x8], 0x6C697665
+    /// // 7: C6 45 FC 00              MOV BYTE PTR [EBP-0x4], 0x0
+    /// // B: C3                       RETN
+    /// let mut ws = test::get_shellcode32_workspace(b"\xC7\x45\xF8\x65\x76\x69\x6C\xC6\x45\xFC\x00\xC3");
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// StackStringAnalyzer::new().analyze(&mut ws).unwrap();
+    /// assert_eq!(
+    ///     ws.get_comment(RVA(0x0), CommentType::Inline).unwrap(),
+    ///     "stack string: \"evil\""
+    /// );
+    /// ```
+    fn analyze(&self, ws: &mut Workspace) -> Result<(), Error> {
+        for s in find_stack_strings(ws, DEFAULT_MIN_LENGTH)?.into_iter() {
+            debug!("stack string: {}: {:?}", s.rva, s.text);
+            ws.make_comment(s.rva, CommentType::Inline, &format!("stack string: {:?}", s.text))?;
+        }
+        ws.analyze()
+    }
+}
@@ -0,0 +1,319 @@
+/// scan the mapped sections for ASCII and UTF-16LE strings,
+/// and note the instructions that reference them.
+///
+/// in addition to NULL-terminated strings, this recognizes length-prefixed
+/// strings: Pascal strings with a one byte length, Delphi strings with a
+/// four byte length, and BSTRs with a four byte length in bytes.
+/// since their extent is explicit, these may contain line breaks and tabs.
+///
+/// this should run after code analysis, so that the references can be found.
+use std::collections::HashMap;
+
+use byteorder::{ByteOrder, LittleEndian};
+use failure::Error;
+use log::{debug, warn};
+use regex::bytes::Regex;
+use zydis;
+
+use super::{
+    super::{
+        arch::{RVA, VA},
+        strings::{RecoveredString, StringEncoding},
+        workspace::Workspace,
+        x86::get_operands,
+    },
+    Analyzer,
+};
+
+const SOURCE: &str = "static scan";
+const LENGTH_PREFIXED_SOURCE: &str = "length-prefixed scan";
+
+/// the default minimum number of characters in a recovered string.
+pub const DEFAULT_MIN_LENGTH: usize = 4;
+
+/// the maximum number of characters in a recovered length-prefixed string,
+/// so that arbitrary dwords aren't taken as lengths.
+const MAX_PREFIXED_LENGTH: usize = 0x1000;
+
+pub struct StringAnalyzer {
+    min_length: usize,
+}
+
+impl StringAnalyzer {
+    #[allow(clippy::new_without_default)]
+    pub fn new() -> StringAnalyzer {
+        StringAnalyzer {
+            min_length: DEFAULT_MIN_LENGTH,
+        }
+    }
+
+    /// Recover only the strings with at least the given number of characters.
+    pub fn with_min_length(min_length: usize) -> StringAnalyzer {
+        StringAnalyzer {
+            // a zero-length match would match everywhere.
+            min_length: std::cmp::max(min_length, 1),
+        }
+    }
+}
+
+fn is_printable(b: u8) -> bool {
+    b >= 0x20 && b <= 0x7E
+}
+
+fn is_text(b: u8) -> bool {
+    is_printable(b) || b == b'\t' || b == b'\r' || b == b'\n'
+}
+
+fn find_ascii_strings(buf: &[u8], min_length: usize) -> Vec<(usize, String)> {
+    // the character class is valid, so the pattern always compiles.
+    let re = Regex::new(&format!("[ -~]{{{},}}", min_length)).unwrap();
+
+    re.find_iter(buf)
+        // this had better be ASCII, and therefore able to be decoded.
+        .map(|mat| (mat.start(), String::from_utf8(mat.as_bytes().to_vec()).unwrap()))
+        .collect()
+}
+
+fn find_unicode_strings(buf: &[u8], min_length: usize) -> Vec<(usize, String)> {
+    // the character class is valid, so the pattern always compiles.
+    let re = Regex::new(&format!("([ -~]\x00){{{},}}", min_length)).unwrap();
+
+    re.find_iter(buf)
+        .filter_map(|mat| {
+            let words: Vec<u16> = mat
+                .as_bytes()
+                .chunks_exact(2)
+                .map(|w| u16::from(w[1]) << 8 | u16::from(w[0]))
+                .collect();
+            String::from_utf16(&words).ok().map(|s| (mat.start(), s))
+        })
+        .collect()
+}
+
+/// find strings that are preceded by their length, rather than followed by a
+/// NULL. the offsets are of the text, not the length.
+///
+/// to avoid matching part of a longer string, the length must not be preceded
+/// by a text character, and the text must not be followed by a printable one.
+/// the length itself may be printable, like 0x41 (`A`).
+fn find_length_prefixed_strings(buf: &[u8], min_length: usize) -> Vec<(usize, StringEncoding, String)> {
+    let fits_text = |start: usize, length: usize, stride: usize| -> bool {
+        let end = start + length * stride;
+        end <= buf.len()
+            && (start..end)
+                .step_by(stride)
+                .all(|i| is_text(buf[i]) && (stride == 1 || buf[i + 1] == 0))
+            && (end == buf.len() || !is_printable(buf[end]))
+    };
+    let ascii = |start: usize, length: usize| String::from_utf8_lossy(&buf[start..start + length]).to_string();
+
+    let mut strings = vec![];
+    for i in 0..buf.len() {
+        // Pascal: u8 length, then ASCII text.
+        let length = buf[i] as usize;
+        if length >= min_length && (i == 0 || !is_text(buf[i - 1])) && fits_text(i + 1, length, 1) {
+            strings.push((i + 1, StringEncoding::Ascii, ascii(i + 1, length)));
+            continue;
+        }
+
+        if i + 4 > buf.len() {
+            continue;
+        }
+        let length = LittleEndian::read_u32(&buf[i..i + 4]) as usize;
+        if length < min_length || length > MAX_PREFIXED_LENGTH * 2 {
+            continue;
+        }
+
+        // Delphi: u32 length, then ASCII text.
+        if length <= MAX_PREFIXED_LENGTH && fits_text(i + 4, length, 1) {
+            strings.push((i + 4, StringEncoding::Ascii, ascii(i + 4, length)));
+        // BSTR: u32 length in bytes, then UTF-16LE text.
+        } else if length % 2 == 0 && length / 2 >= min_length && fits_text(i + 4, length / 2, 2) {
+            let words: Vec<u16> = buf[i + 4..i + 4 + length]
+                .chunks_exact(2)
+                .map(|w| u16::from(w[1]) << 8 | u16::from(w[0]))
+                .collect();
+            if let Ok(text) = String::from_utf16(&words) {
+                strings.push((i + 4, StringEncoding::Utf16, text));
+            }
+        }
+    }
+    strings
+}
+
+/// collect the addresses referenced by the operands of the given instruction:
+/// absolute immediates, absolute memory references, and RIP-relative memory
+/// references.
+fn get_operand_targets(ws: &Workspace, rva: RVA, insn: &zydis::DecodedInstruction) -> Vec<RVA> {
+    get_operands(insn)
+        .into_iter()
+        .filter_map(|op| match op.ty {
+            zydis::OperandType::IMMEDIATE if !op.imm.is_relative => ws.rva(VA::from(op.imm.value)),
+            zydis::OperandType::MEMORY
+                if op.mem.base == zydis::Register::NONE
+                    && op.mem.index == zydis::Register::NONE
+                    && op.mem.disp.has_displacement
+                    && op.mem.disp.displacement >= 0 =>
+            {
+                ws.rva(VA::from(op.mem.disp.displacement as u64))
+            }
+            zydis::OperandType::MEMORY
+                if op.mem.base == zydis::Register::RIP
+                    && op.mem.index == zydis::Register::NONE
+                    && op.mem.disp.has_displacement =>
+            {
+                Some(rva + RVA::from(op.mem.disp.displacement) + insn.length)
+            }
+            _ => None,
+        })
+        .collect()
+}
+
+/// Index the instructions by the addresses that their operands reference.
+pub fn find_references(ws: &Workspace) -> Result<HashMap<RVA, Vec<RVA>>, Error> {
+    let mut references: HashMap<RVA, Vec<RVA>> = HashMap::new();
+
+    for section in ws.module.sections.iter().filter(|section| section.is_executable()) {
+        let insns: Vec<RVA> = ws
+            .get_metas(section.addr, section.size as usize)?
+            .iter()
+            .enumerate()
+            .filter(|(_, meta)| meta.is_insn())
+            .map(|(j, _)| section.addr + RVA::from(j))
+            .collect();
+
+        for rva in insns.into_iter() {
+            let insn = match ws.read_insn(rva) {
+                Ok(insn) => insn,
+                Err(_) => continue,
+            };
+
+            for target in get_operand_targets(ws, rva, &insn).into_iter() {
+                references.entry(target).or_insert_with(Vec::new).push(rva);
+            }
+        }
+    }
+
+    Ok(references)
+}
+
+impl Analyzer for StringAnalyzer {
+    fn get_name(&self) -> String {
+        "string analyzer".to_string()
+    }
+
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::analysis::Analyzer;
+    /// use lancelot::analysis::StringAnalyzer;
+    /// use lancelot::strings::StringEncoding;
+    ///
+    /// // 0: 68 10 00 00 00  PUSH 0x10
+    /// // 5: C3              RETN
+    /// // 6: ...             padding
+    /// // 10: "hello world"
+    /// // 1D: L"abcd"
+    /// let mut ws = test::get_shellcode32_workspace(
+    ///     b"\x68\x10\x00\x00\x00\xC3\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\
+    ///       hello world\x00\x00a\x00b\x00c\x00d\x00\x00\x00",
+    /// );
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.analyze().unwrap();
+    /// StringAnalyzer::new().analyze(&mut ws).unwrap();
+    ///
+    /// let s = ws.get_string(RVA(0x10)).unwrap();
+    /// assert_eq!(s.text, "hello world");
+    /// assert_eq!(s.encoding, StringEncoding::Ascii);
+    /// assert_eq!(s.references, vec![RVA(0x0)]);
+    ///
+    /// let s = ws.get_string(RVA(0x1D)).unwrap();
+    /// assert_eq!(s.text, "abcd");
+    /// assert_eq!(s.encoding, StringEncoding::Utf16);
+    /// assert!(s.references.is_empty());
+    ///
+    /// // with a larger minimum length, "abcd" is skipped.
+    /// let mut ws = test::get_shellcode32_workspace(b"hello world\x00\x00a\x00b\x00c\x00d\x00\x00\x00");
+    /// StringAnalyzer::with_min_length(5).analyze(&mut ws).unwrap();
+    /// assert!(ws.get_string(RVA(0x0)).is_some());
+    /// assert!(ws.get_string(RVA(0xD)).is_none());
+    ///
+    /// // a Pascal string, which the static scan would split at the line break.
+    /// // 0: 0C "hello\r\nworld"
+    /// let mut ws = test::get_shellcode32_workspace(b"\x0Chello\r\nworld\x00");
+    /// StringAnalyzer::new().analyze(&mut ws).unwrap();
+    /// let s = ws.get_string(RVA(0x1)).unwrap();
+    /// assert_eq!(s.text, "hello\r\nworld");
+    /// assert_eq!(s.source, "length-prefixed scan");
+    ///
+    /// // a Pascal string whose length, 0x41, is itself printable (`A`).
+    /// let mut buf = vec![0x00, 0x41];
+    /// buf.extend_from_slice(&[b'x'; 0x41]);
+    /// buf.push(0x00);
+    /// let mut ws = test::get_shellcode32_workspace(&buf);
+    /// StringAnalyzer::new().analyze(&mut ws).unwrap();
+    /// let s = ws.get_string(RVA(0x2)).unwrap();
+    /// assert_eq!(s.text, "x".repeat(0x41));
+    /// assert_eq!(s.source, "length-prefixed scan");
+    /// ```
+    fn analyze(&self, ws: &mut Workspace) -> Result<(), Error> {
+        let references = find_references(ws)?;
+        let mut strings: Vec<RecoveredString> = vec![];
+
+        for section in ws.module.sections.iter() {
+            let buf = match ws.read_bytes(section.addr, section.size as usize) {
+                Ok(buf) => buf,
+                Err(e) => {
+                    warn!("failed to read section {}: {}", section.name, e);
+                    continue;
+                }
+            };
+
+            let found = find_ascii_strings(&buf, self.min_length)
+                .into_iter()
+                .map(|(offset, text)| (offset, StringEncoding::Ascii, text))
+                .chain(
+                    find_unicode_strings(&buf, self.min_length)
+                        .into_iter()
+                        .map(|(offset, text)| (offset, StringEncoding::Utf16, text)),
+                );
+
+            let mut offsets: HashMap<usize, String> = HashMap::new();
+            for (offset, encoding, text) in found {
+                let rva = section.addr + RVA::from(offset);
+                offsets.insert(offset, text.clone());
+                strings.push(RecoveredString {
+                    rva,
+                    encoding,
+                    text,
+                    source: SOURCE.to_string(),
+                    references: references.get(&rva).cloned().unwrap_or_else(Vec::new),
+                });
+            }
+
+            // length-prefixed strings are often also NULL-terminated,
+            // in which case they've already been found.
+            // otherwise, they replace the partial string found at the same address.
+            for (offset, encoding, text) in find_length_prefixed_strings(&buf, self.min_length) {
+                if offsets.get(&offset) == Some(&text) {
+                    continue;
+                }
+
+                let rva = section.addr + RVA::from(offset);
+                strings.push(RecoveredString {
+                    rva,
+                    encoding,
+                    text,
+                    source: LENGTH_PREFIXED_SOURCE.to_string(),
+                    references: references.get(&rva).cloned().unwrap_or_else(Vec::new),
+                });
+            }
+        }
+
+        debug!("found {} strings", strings.len());
+        for s in strings.into_iter() {
+            ws.make_string(s)?;
+        }
+        ws.analyze()
+    }
+}
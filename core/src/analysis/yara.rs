@@ -0,0 +1,799 @@
+/// a YARA-like pattern matcher: match rules written in a subset of the YARA
+/// language against the mapped sections, and tag the start of each matching
+/// string with `yara:<rule>`.
+///
+/// this does not bind libyara. the supported subset is:
+///
+///   - text strings, with the `nocase`, `ascii`, and `wide` modifiers,
+///   - hex strings, with wildcards (`??`, `4?`), jumps (`[2-4]`), and
+///     alternatives (`( 01 | 02 )`),
+///   - regular expressions, with the `i` and `s` flags, and
+///   - conditions built from `and`, `or`, `not`, and parentheses over:
+///     - `true` and `false`,
+///     - `any of them`, `all of them`, `none of them`, and `N of them`,
+///     - `$a`, `$a at <int>`, and `$a in (<int>..<int>)`, and
+///     - comparisons (`==`, `!=`, `<`, `<=`, `>`, `>=`) of integers: literals
+///       like `16`, `0x10`, or `1KB`, `filesize`, counts like `#a`, and reads
+///       of the file like `uint16(0)` or `int32be(0x3C)`.
+///
+/// not supported are modules (like `pe`), `for` loops, string sets other
+/// than `them`, match offsets (`@a[i]`), arithmetic, and rules without strings.
+///
+/// rules are matched against the mapped sections, rather than the file,
+/// so sections unpacked into the workspace are scanned too.
+/// the addresses in `at` and `in` are therefore RVAs,
+/// while `filesize` and `uint16(...)` refer to the raw file, as in YARA.
+/// matches do not span sections, and like YARA, overlapping matches are
+/// reported, one per starting address.
+use std::collections::HashMap;
+
+use failure::{Error, Fail};
+use lazy_static::lazy_static;
+use log::debug;
+use regex::{bytes, Regex};
+
+use super::{
+    super::{arch::RVA, comment::CommentType, workspace::Workspace},
+    Analyzer,
+};
+
+/// the prefix of the tag applied to the matches of a rule.
+pub const YARA_TAG_PREFIX: &str = "yara:";
+
+#[derive(Debug, Fail)]
+pub enum YaraError {
+    #[fail(display = "Invalid YARA rule: {}", _0)]
+    InvalidRule(String),
+    #[fail(display = "Unsupported YARA condition: {}", _0)]
+    UnsupportedCondition(String),
+}
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum Comparison {
+    Eq,
+    Ne,
+    Lt,
+    Le,
+    Gt,
+    Ge,
+}
+
+/// an integer in a condition.
+#[derive(Debug, Clone, PartialEq)]
+enum Integer {
+    Const(i64),
+    /// the size of the file, in bytes.
+    FileSize,
+    /// the number of matches of a string, like `#a`.
+    Count(String),
+    /// an integer read from the file at the given offset, like `uint16(0)`.
+    Read {
+        size:       usize,
+        signed:     bool,
+        big_endian: bool,
+        offset:     Box<Integer>,
+    },
+}
+
+#[derive(Debug, Clone, PartialEq)]
+enum Condition {
+    Bool(bool),
+    Not(Box<Condition>),
+    And(Box<Condition>, Box<Condition>),
+    Or(Box<Condition>, Box<Condition>),
+    Compare(Comparison, Integer, Integer),
+    /// at least the given number of strings match, or all of them if `None`.
+    /// `0 of them` requires that none match.
+    Of(Option<usize>),
+    /// the string matches, like `$a`.
+    String(String),
+    /// the string matches at the given address, like `$a at 0x10`.
+    At(String, Integer),
+    /// the string matches within the given addresses, inclusive,
+    /// like `$a in (0x10..0x20)`.
+    In(String, Integer, Integer),
+}
+
+/// what a condition is evaluated against.
+struct Scan<'a, 'b> {
+    /// the raw file.
+    buf:     &'a [u8],
+    /// the number of strings in the rule.
+    strings: usize,
+    /// the matches of each string that matched.
+    matches: &'a HashMap<&'b str, Vec<YaraMatch>>,
+}
+
+impl<'a, 'b> Scan<'a, 'b> {
+    fn get_matches(&self, identifier: &str) -> &[YaraMatch] {
+        self.matches.get(identifier).map_or(&[], |matches| &matches[..])
+    }
+}
+
+impl Integer {
+    fn get_identifiers<'a>(&'a self, identifiers: &mut Vec<&'a str>) {
+        match self {
+            Integer::Count(identifier) => identifiers.push(identifier),
+            Integer::Read { offset, .. } => offset.get_identifiers(identifiers),
+            Integer::Const(_) | Integer::FileSize => {}
+        }
+    }
+
+    /// the value of this integer, or `None` if it's undefined,
+    /// like a read past the end of the file.
+    fn evaluate(&self, scan: &Scan) -> Option<i64> {
+        match self {
+            Integer::Const(v) => Some(*v),
+            Integer::FileSize => Some(scan.buf.len() as i64),
+            Integer::Count(identifier) => Some(scan.get_matches(identifier).len() as i64),
+            Integer::Read {
+                size,
+                signed,
+                big_endian,
+                offset,
+            } => {
+                let offset = offset.evaluate(scan)?;
+                if offset < 0 {
+                    return None;
+                }
+                let offset = offset as usize;
+                let bytes = scan.buf.get(offset..offset.checked_add(*size)?)?;
+
+                let value = if *big_endian {
+                    bytes.iter().fold(0u64, |v, &b| v << 8 | u64::from(b))
+                } else {
+                    bytes.iter().rev().fold(0u64, |v, &b| v << 8 | u64::from(b))
+                };
+                if *signed {
+                    let shift = 64 - 8 * *size as u32;
+                    Some(((value << shift) as i64) >> shift)
+                } else {
+                    Some(value as i64)
+                }
+            }
+        }
+    }
+}
+
+impl Condition {
+    /// collect the identifiers of the strings referenced by this condition.
+    fn get_identifiers<'a>(&'a self, identifiers: &mut Vec<&'a str>) {
+        match self {
+            Condition::Bool(_) | Condition::Of(_) => {}
+            Condition::Not(condition) => condition.get_identifiers(identifiers),
+            Condition::And(left, right) | Condition::Or(left, right) => {
+                left.get_identifiers(identifiers);
+                right.get_identifiers(identifiers);
+            }
+            Condition::Compare(_, left, right) => {
+                left.get_identifiers(identifiers);
+                right.get_identifiers(identifiers);
+            }
+            Condition::String(identifier) => identifiers.push(identifier),
+            Condition::At(identifier, addr) => {
+                identifiers.push(identifier);
+                addr.get_identifiers(identifiers);
+            }
+            Condition::In(identifier, low, high) => {
+                identifiers.push(identifier);
+                low.get_identifiers(identifiers);
+                high.get_identifiers(identifiers);
+            }
+        }
+    }
+
+    fn evaluate(&self, scan: &Scan) -> bool {
+        match self {
+            Condition::Bool(v) => *v,
+            Condition::Not(condition) => !condition.evaluate(scan),
+            Condition::And(left, right) => left.evaluate(scan) && right.evaluate(scan),
+            Condition::Or(left, right) => left.evaluate(scan) || right.evaluate(scan),
+            Condition::Compare(comparison, left, right) => match (left.evaluate(scan), right.evaluate(scan)) {
+                (Some(left), Some(right)) => match comparison {
+                    Comparison::Eq => left == right,
+                    Comparison::Ne => left != right,
+                    Comparison::Lt => left < right,
+                    Comparison::Le => left <= right,
+                    Comparison::Gt => left > right,
+                    Comparison::Ge => left >= right,
+                },
+                _ => false,
+            },
+            Condition::Of(Some(0)) => scan.matches.is_empty(),
+            Condition::Of(count) => scan.matches.len() >= count.unwrap_or(scan.strings),
+            Condition::String(identifier) => !scan.get_matches(identifier).is_empty(),
+            Condition::At(identifier, addr) => match addr.evaluate(scan) {
+                Some(addr) => scan.get_matches(identifier).iter().any(|m| m.rva == RVA::from(addr)),
+                None => false,
+            },
+            Condition::In(identifier, low, high) => match (low.evaluate(scan), high.evaluate(scan)) {
+                (Some(low), Some(high)) => scan
+                    .get_matches(identifier)
+                    .iter()
+                    .any(|m| RVA::from(low) <= m.rva && m.rva <= RVA::from(high)),
+                _ => false,
+            },
+        }
+    }
+}
+
+#[derive(Debug, Clone)]
+pub struct YaraRule {
+    pub name:  String,
+    /// the identifiers of the strings, like `$a`, and their patterns.
+    strings:   Vec<(String, bytes::Regex)>,
+    condition: Condition,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct YaraMatch {
+    pub rule:       String,
+    /// the identifier of the matching string, like `$a`.
+    pub identifier: String,
+    pub rva:        RVA,
+    pub length:     usize,
+}
+
+/// render the given bytes as a pattern that matches them exactly.
+fn escape_bytes(buf: &[u8]) -> String {
+    buf.iter().map(|b| format!("\\x{:02X}", b)).collect()
+}
+
+/// decode the escapes in a text string: `\"`, `\\`, `\t`, `\n`, `\r`, and
+/// `\xNN`.
+fn unescape_text(s: &str) -> Result<Vec<u8>, Error> {
+    let invalid = || YaraError::InvalidRule(format!("invalid escape in \"{}\"", s));
+
+    let mut buf = vec![];
+    let mut chars = s.bytes();
+    while let Some(c) = chars.next() {
+        if c != b'\\' {
+            buf.push(c);
+            continue;
+        }
+
+        match chars.next() {
+            Some(b'"') => buf.push(b'"'),
+            Some(b'\\') => buf.push(b'\\'),
+            Some(b't') => buf.push(b'\t'),
+            Some(b'n') => buf.push(b'\n'),
+            Some(b'r') => buf.push(b'\r'),
+            Some(b'x') => {
+                let hex: Vec<u8> = chars.by_ref().take(2).collect();
+                let hex = String::from_utf8_lossy(&hex).to_string();
+                buf.push(u8::from_str_radix(&hex, 16).map_err(|_| invalid())?);
+            }
+            _ => return Err(invalid().into()),
+        }
+    }
+    Ok(buf)
+}
+
+/// translate a text string and its modifiers into a pattern.
+fn compile_text(text: &str, modifiers: &str) -> Result<String, Error> {
+    let buf = unescape_text(text)?;
+    let wide = modifiers.contains("wide");
+    let ascii = modifiers.contains("ascii") || !wide;
+
+    let mut alternatives = vec![];
+    if ascii {
+        alternatives.push(escape_bytes(&buf));
+    }
+    if wide {
+        alternatives.push(buf.iter().map(|&b| escape_bytes(&[b, 0x00])).collect());
+    }
+
+    let flags = if modifiers.contains("nocase") { "(?i)" } else { "" };
+    Ok(format!("{}(?:{})", flags, alternatives.join("|")))
+}
+
+/// translate a hex string, like `4D 5A ?? [2-4] ( 01 | 02 )`, into a pattern.
+fn compile_hex(hex: &str) -> Result<String, Error> {
+    lazy_static! {
+        static ref TOKEN_RE: Regex = Regex::new(r"\[\s*(\d*)\s*(-)?\s*(\d*)\s*\]|[0-9A-Fa-f?]{2}|[()|]|\S").unwrap();
+    }
+
+    let mut pattern = String::new();
+    for token in TOKEN_RE.captures_iter(hex) {
+        let s = &token[0];
+        let invalid = || YaraError::InvalidRule(format!("invalid hex string token: {}", s));
+
+        if s.starts_with('[') {
+            let low = token.get(1).map_or("", |m| m.as_str());
+            let high = token.get(3).map_or("", |m| m.as_str());
+            let low = if low.is_empty() { "0" } else { low };
+            if token.get(2).is_none() {
+                pattern.push_str(&format!(".{{{}}}", low));
+            } else {
+                pattern.push_str(&format!(".{{{},{}}}", low, high));
+            }
+        } else if s == "(" || s == "|" || s == ")" {
+            pattern.push_str(if s == "(" { "(?:" } else { s });
+        } else if s.len() == 2 {
+            let (high, low) = (&s[..1], &s[1..]);
+            pattern.push_str(&match (high, low) {
+                ("?", "?") => ".".to_string(),
+                ("?", _) => {
+                    let low = u8::from_str_radix(low, 16).map_err(|_| invalid())?;
+                    let bytes: Vec<String> = (0..16u8).map(|high| escape_bytes(&[high << 4 | low])).collect();
+                    format!("[{}]", bytes.join(""))
+                }
+                (_, "?") => {
+                    let high = u8::from_str_radix(high, 16).map_err(|_| invalid())?;
+                    format!("[{}-{}]", escape_bytes(&[high << 4]), escape_bytes(&[high << 4 | 0xF]))
+                }
+                _ => escape_bytes(&[u8::from_str_radix(s, 16).map_err(|_| invalid())?]),
+            });
+        } else {
+            return Err(invalid().into());
+        }
+    }
+
+    Ok(pattern)
+}
+
+/// find the offset of the brace that closes a block, given the text
+/// following its opening brace, skipping over text strings and escapes.
+fn find_closing_brace(s: &str) -> Option<usize> {
+    let mut depth = 0;
+    let mut in_text = false;
+    let mut escaped = false;
+    for (i, c) in s.char_indices() {
+        if escaped {
+            escaped = false;
+            continue;
+        }
+
+        match c {
+            '\\' => escaped = true,
+            '"' => in_text = !in_text,
+            '{' if !in_text => depth += 1,
+            '}' if !in_text && depth == 0 => return Some(i),
+            '}' if !in_text => depth -= 1,
+            _ => {}
+        }
+    }
+    None
+}
+
+/// parse a number, like `16`, `0x10`, or `1KB`.
+fn parse_number(token: &str) -> Option<i64> {
+    let (digits, multiplier) = if token.ends_with("KB") {
+        (&token[..token.len() - 2], 1024)
+    } else if token.ends_with("MB") {
+        (&token[..token.len() - 2], 1024 * 1024)
+    } else {
+        (token, 1)
+    };
+
+    let value = if digits.starts_with("0x") {
+        i64::from_str_radix(&digits[2..], 16).ok()?
+    } else {
+        digits.parse::<i64>().ok()?
+    };
+    value.checked_mul(multiplier)
+}
+
+/// a recursive descent parser of conditions, from the lowest precedence:
+/// `or`, `and`, `not`, and then the primary expressions.
+struct ConditionParser<'a> {
+    source: &'a str,
+    tokens: Vec<&'a str>,
+    pos:    usize,
+}
+
+impl<'a> ConditionParser<'a> {
+    fn peek(&self) -> Option<&'a str> {
+        self.tokens.get(self.pos).cloned()
+    }
+
+    fn next(&mut self) -> Option<&'a str> {
+        let token = self.peek();
+        self.pos += 1;
+        token
+    }
+
+    fn error(&self) -> Error {
+        YaraError::UnsupportedCondition(self.source.to_string()).into()
+    }
+
+    fn expect(&mut self, token: &str) -> Result<(), Error> {
+        if self.next() == Some(token) {
+            Ok(())
+        } else {
+            Err(self.error())
+        }
+    }
+
+    fn parse_or(&mut self) -> Result<Condition, Error> {
+        let mut condition = self.parse_and()?;
+        while self.peek() == Some("or") {
+            self.pos += 1;
+            condition = Condition::Or(Box::new(condition), Box::new(self.parse_and()?));
+        }
+        Ok(condition)
+    }
+
+    fn parse_and(&mut self) -> Result<Condition, Error> {
+        let mut condition = self.parse_not()?;
+        while self.peek() == Some("and") {
+            self.pos += 1;
+            condition = Condition::And(Box::new(condition), Box::new(self.parse_not()?));
+        }
+        Ok(condition)
+    }
+
+    fn parse_not(&mut self) -> Result<Condition, Error> {
+        if self.peek() == Some("not") {
+            self.pos += 1;
+            return Ok(Condition::Not(Box::new(self.parse_not()?)));
+        }
+        self.parse_primary()
+    }
+
+    fn parse_primary(&mut self) -> Result<Condition, Error> {
+        match self.peek() {
+            Some("(") => {
+                self.pos += 1;
+                let condition = self.parse_or()?;
+                self.expect(")")?;
+                Ok(condition)
+            }
+            Some("true") | Some("false") => Ok(Condition::Bool(self.next() == Some("true"))),
+            Some("any") | Some("all") | Some("none") => {
+                let count = match self.next() {
+                    Some("any") => Some(1),
+                    Some("none") => Some(0),
+                    _ => None,
+                };
+                self.expect("of")?;
+                self.expect("them")?;
+                Ok(Condition::Of(count))
+            }
+            Some(token) if token.starts_with('$') => {
+                self.pos += 1;
+                let identifier = token.to_string();
+                match self.peek() {
+                    Some("at") => {
+                        self.pos += 1;
+                        Ok(Condition::At(identifier, self.parse_integer()?))
+                    }
+                    Some("in") => {
+                        self.pos += 1;
+                        self.expect("(")?;
+                        let low = self.parse_integer()?;
+                        self.expect("..")?;
+                        let high = self.parse_integer()?;
+                        self.expect(")")?;
+                        Ok(Condition::In(identifier, low, high))
+                    }
+                    _ => Ok(Condition::String(identifier)),
+                }
+            }
+            _ => {
+                let left = self.parse_integer()?;
+                if let (Integer::Const(count), Some("of")) = (&left, self.peek()) {
+                    if *count < 0 {
+                        return Err(self.error());
+                    }
+                    self.pos += 1;
+                    self.expect("them")?;
+                    return Ok(Condition::Of(Some(*count as usize)));
+                }
+
+                let comparison = match self.next() {
+                    Some("==") => Comparison::Eq,
+                    Some("!=") => Comparison::Ne,
+                    Some("<") => Comparison::Lt,
+                    Some("<=") => Comparison::Le,
+                    Some(">") => Comparison::Gt,
+                    Some(">=") => Comparison::Ge,
+                    _ => return Err(self.error()),
+                };
+                Ok(Condition::Compare(comparison, left, self.parse_integer()?))
+            }
+        }
+    }
+
+    fn parse_integer(&mut self) -> Result<Integer, Error> {
+        lazy_static! {
+            static ref READ_RE: Regex = Regex::new(r"^(u?)int(8|16|32)(be)?$").unwrap();
+        }
+
+        let token = self.next().ok_or_else(|| self.error())?;
+        if token == "filesize" {
+            Ok(Integer::FileSize)
+        } else if token.starts_with('#') {
+            Ok(Integer::Count(format!("${}", &token[1..])))
+        } else if let Some(read) = READ_RE.captures(token) {
+            self.expect("(")?;
+            let offset = self.parse_integer()?;
+            self.expect(")")?;
+            Ok(Integer::Read {
+                size:       read[2].parse::<usize>().unwrap() / 8,
+                signed:     read[1].is_empty(),
+                big_endian: read.get(3).is_some(),
+                offset:     Box::new(offset),
+            })
+        } else {
+            parse_number(token).map(Integer::Const).ok_or_else(|| self.error())
+        }
+    }
+}
+
+fn parse_condition(condition: &str) -> Result<Condition, Error> {
+    lazy_static! {
+        static ref TOKEN_RE: Regex = Regex::new(r"\.\.|==|!=|<=|>=|[<>(),]|[$#]\w*|\w+|\S").unwrap();
+    }
+
+    let condition = condition.split_whitespace().collect::<Vec<&str>>().join(" ");
+    let mut parser = ConditionParser {
+        source: &condition,
+        tokens: TOKEN_RE.find_iter(&condition).map(|m| m.as_str()).collect(),
+        pos:    0,
+    };
+
+    let ret = parser.parse_or()?;
+    if parser.pos != parser.tokens.len() {
+        return Err(parser.error());
+    }
+    Ok(ret)
+}
+
+impl YaraRule {
+    /// Parse the rules in the given YARA source.
+    ///
+    /// Errors:
+    ///
+    ///   - InvalidRule - if a rule or one of its strings can't be parsed.
+    ///   - UnsupportedCondition - if a condition is outside the supported
+    ///     subset.
+    ///
+    /// ```
+    /// use lancelot::analysis::yara::YaraRule;
+    ///
+    /// let rules = YaraRule::parse("
+    ///     // a comment
+    ///     rule mz : pe {
+    ///         meta:
+    ///             author = \"lancelot\"
+    ///         strings:
+    ///             $mz = { 4D 5A }
+    ///         condition:
+    ///             $mz
+    ///     }
+    /// ").unwrap();
+    /// assert_eq!(rules.len(), 1);
+    /// assert_eq!(rules[0].name, "mz");
+    ///
+    /// assert!(YaraRule::parse("rule r { strings: $a = \"a\" condition: $a and pe.is_dll() }").is_err());
+    /// assert!(YaraRule::parse("rule r { strings: $a = \"a\" condition: $a and #b > 1 }").is_err());
+    /// ```
+    pub fn parse(source: &str) -> Result<Vec<YaraRule>, Error> {
+        lazy_static! {
+            static ref COMMENT_RE: Regex = Regex::new(r"(?s)/\*.*?\*/|(?m)(?:^|\s)//.*$").unwrap();
+            static ref RULE_RE: Regex =
+                Regex::new(r"\b(?:private\s+|global\s+)*rule\s+(\w+)\s*(?::[\w\s]*)?\{").unwrap();
+            static ref STRING_RE: Regex = Regex::new(
+                r#"(\$\w*)\s*=\s*(?:"((?:[^"\\]|\\.)*)"((?:\s+(?:nocase|ascii|wide|fullword))*)|\{([^}]*)\}|/((?:[^/\\]|\\.)*)/([is]*))"#
+            )
+            .unwrap();
+        }
+
+        let source = COMMENT_RE.replace_all(source, "");
+
+        let mut rules = vec![];
+        let mut start = 0;
+        while let Some(header) = RULE_RE.captures(&source[start..]) {
+            let name = header[1].to_string();
+            let body_start = start + header.get(0).unwrap().end();
+            let body_end = find_closing_brace(&source[body_start..])
+                .map(|i| body_start + i)
+                .ok_or_else(|| YaraError::InvalidRule(format!("{}: unterminated rule", name)))?;
+            start = body_end + 1;
+
+            // the condition is last, and text strings may contain "condition:".
+            let body = &source[body_start..body_end];
+            let (body, condition) = match body.rfind("condition:") {
+                Some(i) => (&body[..i], &body[i + "condition:".len()..]),
+                None => return Err(YaraError::InvalidRule(format!("{}: no condition", name)).into()),
+            };
+            let strings_section = match body.find("strings:") {
+                Some(i) => &body[i + "strings:".len()..],
+                None => "",
+            };
+
+            let mut strings = vec![];
+            for (i, string) in STRING_RE.captures_iter(strings_section).enumerate() {
+                let identifier = if &string[1] == "$" {
+                    format!("${}", i)
+                } else {
+                    string[1].to_string()
+                };
+
+                let pattern = if let Some(text) = string.get(2) {
+                    compile_text(text.as_str(), string.get(3).map_or("", |m| m.as_str()))?
+                } else if let Some(hex) = string.get(4) {
+                    compile_hex(hex.as_str())?
+                } else {
+                    format!("(?{}:{})", string.get(6).map_or("", |m| m.as_str()), &string[5])
+                };
+
+                // `.` matches any byte, and `\xNN` matches a byte, not a character.
+                let re = bytes::Regex::new(&format!("(?s-u){}", pattern))
+                    .map_err(|e| YaraError::InvalidRule(format!("{}: {}: {}", name, identifier, e)))?;
+                strings.push((identifier, re));
+            }
+
+            if strings.is_empty() {
+                return Err(YaraError::InvalidRule(format!("{}: no strings", name)).into());
+            }
+
+            let condition = parse_condition(condition)?;
+            let mut identifiers = vec![];
+            condition.get_identifiers(&mut identifiers);
+            for identifier in identifiers.into_iter() {
+                if !strings.iter().any(|(id, _)| id == identifier) {
+                    return Err(YaraError::InvalidRule(format!("{}: undefined string {}", name, identifier)).into());
+                }
+            }
+
+            rules.push(YaraRule {
+                name,
+                strings,
+                condition,
+            });
+        }
+
+        if rules.is_empty() && !source.trim().is_empty() {
+            return Err(YaraError::InvalidRule("no rules".to_string()).into());
+        }
+
+        Ok(rules)
+    }
+
+    /// Find the matches of this rule's strings across the mapped sections,
+    ///  or nothing if the condition is not satisfied.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::analysis::yara::{YaraMatch, YaraRule};
+    ///
+    /// let ws = test::get_shellcode32_workspace(b"\x00\x00\x68\x00\x69\x00\x00\x00\x6A\x40\x68\x00\x30");
+    /// let rules = YaraRule::parse(r#"
+    ///     rule hi {
+    ///         strings:
+    ///             $a = "HI" nocase wide
+    ///             $b = { 6A 40 68 ?? [0-2] }
+    ///             $c = /exit[0-9]/
+    ///         condition:
+    ///             2 of them
+    ///     }
+    /// "#).unwrap();
+    /// assert_eq!(
+    ///     rules[0].find_matches(&ws).unwrap(),
+    ///     vec![
+    ///         YaraMatch { rule: "hi".to_string(), identifier: "$a".to_string(), rva: RVA(0x2), length: 4 },
+    ///         YaraMatch { rule: "hi".to_string(), identifier: "$b".to_string(), rva: RVA(0x8), length: 5 },
+    ///     ]
+    /// );
+    /// ```
+    ///
+    /// overlapping matches are found, and conditions can test the file:
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::analysis::yara::YaraRule;
+    ///
+    /// let ws = test::get_shellcode32_workspace(b"MZ\x90\x00AAAA");
+    /// let rules = YaraRule::parse(r#"
+    ///     rule overlapping {
+    ///         strings:
+    ///             $a = "AA"
+    ///             $b = "ZZ"
+    ///         condition:
+    ///             uint16(0) == 0x5A4D and filesize < 1KB and #a == 3 and
+    ///             ($a at 0x4 or $b) and not $b in (0..0x10)
+    ///     }
+    /// "#).unwrap();
+    /// let rvas: Vec<RVA> = rules[0].find_matches(&ws).unwrap().iter().map(|m| m.rva).collect();
+    /// assert_eq!(rvas, vec![RVA(0x4), RVA(0x5), RVA(0x6)]);
+    /// ```
+    pub fn find_matches(&self, ws: &Workspace) -> Result<Vec<YaraMatch>, Error> {
+        let mut matches: HashMap<&str, Vec<YaraMatch>> = HashMap::new();
+
+        for section in ws.module.sections.iter() {
+            let buf = ws.read_bytes(section.addr, section.size as usize)?;
+            for (identifier, re) in self.strings.iter() {
+                // like YARA, report a match at each starting address,
+                // even when it overlaps the previous match.
+                let mut start = 0;
+                while start <= buf.len() {
+                    let mat = match re.find_at(&buf, start) {
+                        Some(mat) => mat,
+                        None => break,
+                    };
+                    matches
+                        .entry(identifier.as_str())
+                        .or_insert_with(Vec::new)
+                        .push(YaraMatch {
+                            rule:       self.name.clone(),
+                            identifier: identifier.clone(),
+                            rva:        section.addr + mat.start(),
+                            length:     mat.end() - mat.start(),
+                        });
+                    start = mat.start() + 1;
+                }
+            }
+        }
+
+        let scan = Scan {
+            buf:     &ws.buf,
+            strings: self.strings.len(),
+            matches: &matches,
+        };
+        if !self.condition.evaluate(&scan) {
+            return Ok(vec![]);
+        }
+
+        let mut matches: Vec<YaraMatch> = matches.into_iter().flat_map(|(_, matches)| matches).collect();
+        matches.sort_by(|a, b| a.rva.cmp(&b.rva).then_with(|| a.identifier.cmp(&b.identifier)));
+        Ok(matches)
+    }
+}
+
+pub struct YaraAnalyzer {
+    rules: Vec<YaraRule>,
+}
+
+impl YaraAnalyzer {
+    pub fn new(rules: Vec<YaraRule>) -> YaraAnalyzer {
+        YaraAnalyzer { rules }
+    }
+}
+
+impl Analyzer for YaraAnalyzer {
+    fn get_name(&self) -> String {
+        "YARA-like pattern matcher".to_string()
+    }
+
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::comment::CommentType;
+    /// use lancelot::analysis::Analyzer;
+    /// use lancelot::analysis::yara::{YaraAnalyzer, YaraRule};
+    ///
+    /// let mut ws = test::get_shellcode32_workspace(b"\x00\x00\x00\x00evil.example.com\x00");
+    /// let rules = YaraRule::parse("rule c2 { strings: $ = \"evil.\" condition: any of them }").unwrap();
+    ///
+    /// YaraAnalyzer::new(rules).analyze(&mut ws).unwrap();
+    /// assert_eq!(ws.find_tagged("yara:c2"), vec![RVA(0x4)]);
+    /// assert_eq!(
+    ///     ws.get_comment(RVA(0x4), CommentType::Pre).unwrap(),
+    ///     "YARA rule c2 matched $0 (0x5 bytes)"
+    /// );
+    /// ```
+    fn analyze(&self, ws: &mut Workspace) -> Result<(), Error> {
+        let mut matches = vec![];
+        for rule in self.rules.iter() {
+            matches.extend(rule.find_matches(ws)?);
+        }
+
+        for m in matches.into_iter() {
+            debug!(
+                "YARA match: {}: {} {} ({:#x} bytes)",
+                m.rva, m.rule, m.identifier, m.length
+            );
+            ws.make_tag(m.rva, &format!("{}{}", YARA_TAG_PREFIX, m.rule))?;
+            ws.make_comment(
+                m.rva,
+                CommentType::Pre,
+                &format!("YARA rule {} matched {} ({:#x} bytes)", m.rule, m.identifier, m.length),
+            )?;
+        }
+        ws.analyze()
+    }
+}
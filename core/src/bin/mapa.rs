@@ -499,13 +499,10 @@ fn compute_map(ws: &Workspace) -> Result<Map, Error> {
     }
 
     for &function in ws.get_functions() {
-        let name = match ws.get_symbol(function) {
-            Some(name) => name.to_string(),
-            None => {
-                let va: u64 = ws.va(function).unwrap().into();
-                format!("sub_{:x}", va)
-            }
-        };
+        let mut name = ws.get_name(function);
+        for (_, comment) in ws.get_comments(function).into_iter() {
+            name.push_str(&format!(" ; {}", comment.replace('\n', " ")));
+        }
 
         // TODO: see git history (46de2af) for an attempt at function ranges.
         // this didn't work great while we used naive basic blocks.
@@ -0,0 +1,9 @@
+#[derive(Debug, Copy, Clone, Hash, PartialEq, Eq, PartialOrd, Ord)]
+pub enum CommentType {
+    // rendered on the line(s) before the address.
+    Pre,
+    // rendered at the end of the line for the address.
+    Inline,
+    // rendered on the line(s) after the address.
+    Post,
+}
@@ -0,0 +1,37 @@
+//! Demangle C++ symbol names, such as from exports, imports, and signatures,
+//!  so that they can be displayed like `int __cdecl foo(int)`.
+//!
+//! Symbols are stored mangled, since that is how they appear in the module,
+//! and are only demangled for display.
+use cpp_demangle;
+use msvc_demangler::{self, DemangleFlags};
+
+/// Demangle the given MSVC or Itanium-mangled name,
+///  which may be prefixed by its module, like `foo.dll!?bar@@YAXXZ`.
+///
+/// Returns `None` if the name is not mangled, or cannot be demangled.
+///
+/// ```
+/// use lancelot::demangle::demangle;
+///
+/// assert_eq!(demangle("?foo@@YAHH@Z").unwrap(), "int __cdecl foo(int)");
+/// assert_eq!(demangle("_ZN3foo3barEv").unwrap(), "foo::bar()");
+/// assert_eq!(demangle("foo.dll!_ZN3foo3barEv").unwrap(), "foo.dll!foo::bar()");
+/// assert!(demangle("CreateFileA").is_none());
+/// ```
+pub fn demangle(name: &str) -> Option<String> {
+    let (module, name) = match name.rfind('!') {
+        Some(i) => name.split_at(i + 1),
+        None => ("", name),
+    };
+
+    let demangled = if name.starts_with('?') {
+        msvc_demangler::demangle(name, DemangleFlags::COMPLETE).ok()?
+    } else if name.starts_with("_Z") || name.starts_with("__Z") {
+        cpp_demangle::Symbol::new(name.as_bytes()).ok()?.to_string()
+    } else {
+        return None;
+    };
+
+    Some(format!("{}{}", module, demangled))
+}
@@ -0,0 +1,123 @@
+//! Compare the analysis results of two workspaces,
+//! such as before/after changing analysis options.
+use std::{collections::HashSet, hash::Hash};
+
+use super::{arch::RVA, workspace::Workspace, xref::Xref};
+
+/// The items found in only one of two collections.
+#[derive(Debug, Clone)]
+pub struct Delta<T> {
+    /// items found in the second collection, but not the first.
+    pub added:   Vec<T>,
+    /// items found in the first collection, but not the second.
+    pub removed: Vec<T>,
+}
+
+impl<T> Delta<T> {
+    pub fn is_empty(&self) -> bool {
+        self.added.is_empty() && self.removed.is_empty()
+    }
+}
+
+fn delta<T: Eq + Hash + Clone>(a: &HashSet<T>, b: &HashSet<T>) -> Delta<T> {
+    Delta {
+        added:   b.difference(a).cloned().collect(),
+        removed: a.difference(b).cloned().collect(),
+    }
+}
+
+#[derive(Debug, Clone)]
+pub struct WorkspaceDiff {
+    pub functions:         Delta<RVA>,
+    pub basic_blocks:      Delta<RVA>,
+    pub xrefs:             Delta<Xref>,
+    /// functions found in both workspaces whose name or metadata differs.
+    pub changed_functions: Vec<RVA>,
+}
+
+impl WorkspaceDiff {
+    pub fn is_empty(&self) -> bool {
+        self.functions.is_empty()
+            && self.basic_blocks.is_empty()
+            && self.xrefs.is_empty()
+            && self.changed_functions.is_empty()
+    }
+}
+
+fn get_basic_blocks(ws: &Workspace) -> HashSet<RVA> {
+    ws.get_functions()
+        .filter_map(|&f| ws.get_basic_blocks(f).ok())
+        .flatten()
+        .map(|bb| bb.addr)
+        .collect()
+}
+
+fn get_xrefs(ws: &Workspace) -> HashSet<Xref> {
+    ws.analysis.flow.xrefs.from.values().flatten().cloned().collect()
+}
+
+/// Compute the functions, basic blocks, and xrefs found in only one of the
+/// given workspaces, along with the functions whose name or metadata changed.
+///
+/// Results are sorted by address.
+///
+/// ```
+/// use lancelot::test;
+/// use lancelot::diff;
+/// use lancelot::arch::RVA;
+///
+/// // 0: E8 01 00 00 00  CALL $+6
+/// // 5: C3              RETN
+/// // 6: C3              RETN
+/// let buf = b"\xE8\x01\x00\x00\x00\xC3\xC3";
+///
+/// let mut a = test::get_shellcode32_workspace(buf);
+/// a.make_insn(RVA(0x6)).unwrap();
+/// a.analyze().unwrap();
+///
+/// let mut b = test::get_shellcode32_workspace(buf);
+/// b.make_function(RVA(0x0)).unwrap();
+/// b.analyze().unwrap();
+///
+/// let d = diff::diff(&a, &a);
+/// assert!(d.is_empty());
+///
+/// let d = diff::diff(&a, &b);
+/// assert_eq!(d.functions.added, vec![RVA(0x0), RVA(0x6)]);
+/// assert!(d.functions.removed.is_empty());
+/// assert_eq!(d.xrefs.added.len(), 1);
+/// assert!(d.changed_functions.is_empty());
+///
+/// let d = diff::diff(&b, &a);
+/// assert_eq!(d.functions.removed, vec![RVA(0x0), RVA(0x6)]);
+/// ```
+pub fn diff(a: &Workspace, b: &Workspace) -> WorkspaceDiff {
+    let afunctions: HashSet<RVA> = a.get_functions().cloned().collect();
+    let bfunctions: HashSet<RVA> = b.get_functions().cloned().collect();
+
+    let mut functions = delta(&afunctions, &bfunctions);
+    functions.added.sort();
+    functions.removed.sort();
+
+    let mut basic_blocks = delta(&get_basic_blocks(a), &get_basic_blocks(b));
+    basic_blocks.added.sort();
+    basic_blocks.removed.sort();
+
+    let mut xrefs = delta(&get_xrefs(a), &get_xrefs(b));
+    xrefs.added.sort_by(|x, y| (x.src, x.dst).cmp(&(y.src, y.dst)));
+    xrefs.removed.sort_by(|x, y| (x.src, x.dst).cmp(&(y.src, y.dst)));
+
+    let mut changed_functions: Vec<RVA> = afunctions
+        .intersection(&bfunctions)
+        .filter(|&&f| a.get_symbol(f) != b.get_symbol(f) || a.get_function_meta(f) != b.get_function_meta(f))
+        .cloned()
+        .collect();
+    changed_functions.sort();
+
+    WorkspaceDiff {
+        functions,
+        basic_blocks,
+        xrefs,
+        changed_functions,
+    }
+}
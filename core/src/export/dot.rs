@@ -0,0 +1,179 @@
+//! Render the control flow graph of a function in the Graphviz DOT format.
+//!
+//! Each basic block is a node labeled with its disassembly and comments,
+//! and edges are colored by the type of flow:
+//!
+//!   - green: conditional jump, taken
+//!   - red: conditional jump, not taken
+//!   - blue: unconditional jump
+//!   - gray: conditional move
+//!   - black: fallthrough
+use std::io::Write;
+
+use failure::Error;
+use zydis;
+
+use super::super::{
+    arch::RVA,
+    basicblock::BasicBlock,
+    comment::CommentType,
+    function::StackFrame,
+    workspace::{Workspace, WorkspaceError},
+    xref::XrefType,
+};
+
+fn escape(s: &str) -> String {
+    s.replace('\\', "\\\\").replace('"', "\\\"")
+}
+
+fn node_name(rva: RVA) -> String {
+    format!("bb_{:x}", rva)
+}
+
+/// render an instruction, with the name of the stack slot it references and
+/// its inline comment, if any, like `mov eax, [ebp+0x08] ; arg_0`.
+fn format_insn(
+    ws: &Workspace,
+    formatter: &zydis::Formatter,
+    frame: Option<&StackFrame>,
+    rva: RVA,
+) -> Result<String, Error> {
+    let insn = ws.read_insn(rva)?;
+    let va: u64 = ws.va(rva).ok_or(WorkspaceError::InvalidAddress)?.into();
+
+    let mut buffer = [0u8; 200];
+    let mut buffer = zydis::OutputBuffer::new(&mut buffer[..]);
+    formatter
+        .format_instruction(&insn, &mut buffer, Some(va), None)
+        .map_err(|_| WorkspaceError::InvalidInstruction)?;
+
+    let mut line = format!("{:#x}: {}", va, buffer);
+    if let Some(slot) = frame.and_then(|frame| frame.get_reference(rva)) {
+        line.push_str(&format!(" ; {}", slot.name));
+    }
+    if let Some(comment) = ws.get_comment(rva, CommentType::Inline) {
+        line.push_str(&format!(" ; {}", comment.replace('\n', " ")));
+    }
+    Ok(line)
+}
+
+/// render the pre or post comment at the given address, one line per line
+/// of the comment, like `; decrypt the config`.
+fn format_comment(ws: &Workspace, rva: RVA, typ: CommentType) -> Vec<String> {
+    match ws.get_comment(rva, typ) {
+        Some(comment) => comment.lines().map(|line| format!("; {}", line)).collect(),
+        None => vec![],
+    }
+}
+
+fn edge_color(ws: &Workspace, bb: &BasicBlock, successor: RVA) -> Result<&'static str, Error> {
+    let last_insn = match bb.insns.last() {
+        Some(&last_insn) => last_insn,
+        None => return Ok("black"),
+    };
+
+    let xrefs = ws.get_xrefs_from(last_insn)?;
+    let is_cjmp = xrefs.iter().any(|xref| xref.typ == XrefType::ConditionalJump);
+
+    // the only flow that is not recorded as an explicit xref is the fallthrough.
+    let explicit = xrefs
+        .iter()
+        .find(|xref| xref.dst == successor && xref.typ != XrefType::Fallthrough);
+
+    Ok(match explicit {
+        Some(xref) => match xref.typ {
+            XrefType::ConditionalJump => "green",
+            XrefType::UnconditionalJump => "blue",
+            XrefType::ConditionalMove => "gray",
+            _ => "black",
+        },
+        None if is_cjmp => "red",
+        None => "black",
+    })
+}
+
+/// Render the control flow graph of the function that starts at the given
+/// RVA.
+///
+/// ```
+/// use lancelot::test;
+/// use lancelot::arch::RVA;
+/// use lancelot::comment::CommentType;
+/// use lancelot::export::dot;
+///
+/// // 0: 75 01  JNZ $+3
+/// // 2: 90     NOP
+/// // 3: C3     RETN
+/// let mut ws = test::get_shellcode32_workspace(b"\x75\x01\x90\xC3");
+/// ws.make_function(RVA(0x0)).unwrap();
+/// ws.make_comment(RVA(0x0), CommentType::Pre, "check the flag").unwrap();
+/// ws.make_comment(RVA(0x2), CommentType::Inline, "padding").unwrap();
+/// ws.make_comment(RVA(0x3), CommentType::Post, "done").unwrap();
+/// ws.analyze().unwrap();
+///
+/// let s = dot::render_function(&ws, RVA(0x0)).unwrap();
+/// assert!(s.starts_with("digraph"));
+/// assert!(s.contains("sub_0:\\l; check the flag\\l0x0: jnz"));
+/// assert!(s.contains("0x2: nop ; padding\\l"));
+/// assert!(s.contains("0x3: ret\\l; done\\l"));
+/// assert!(s.contains("bb_0 -> bb_3 [color=green];"));
+/// assert!(s.contains("bb_0 -> bb_2 [color=red];"));
+/// assert!(s.contains("bb_2 -> bb_3 [color=black];"));
+/// assert!(s.contains("nop"));
+/// assert!(s.contains("sub_0+0x2:"));
+/// ```
+pub fn render_function(ws: &Workspace, rva: RVA) -> Result<String, Error> {
+    let formatter = zydis::Formatter::new(zydis::FormatterStyle::INTEL).map_err(|_| WorkspaceError::NotSupported)?;
+
+    let mut bbs = ws.get_basic_blocks(rva)?;
+    bbs.sort_by(|a, b| a.addr.cmp(&b.addr));
+
+    let name = ws.get_name(rva);
+    let frame = ws.get_function_meta(rva).and_then(|meta| meta.frame.as_ref());
+
+    let mut lines = vec![];
+    lines.push(format!("digraph \"{}\" {{", escape(&name)));
+    lines.push("  node [shape=box fontname=\"Courier\"];".to_string());
+
+    for bb in bbs.iter() {
+        let mut label = String::new();
+        label.push_str(&escape(&ws.format_address(bb.addr)));
+        label.push_str(":\\l");
+        for &insn in bb.insns.iter() {
+            let mut insn_lines = format_comment(ws, insn, CommentType::Pre);
+            insn_lines.push(format_insn(ws, &formatter, frame, insn)?);
+            insn_lines.extend(format_comment(ws, insn, CommentType::Post));
+            for line in insn_lines.iter() {
+                label.push_str(&escape(line));
+                label.push_str("\\l");
+            }
+        }
+        lines.push(format!("  {} [label=\"{}\"];", node_name(bb.addr), label));
+    }
+
+    for bb in bbs.iter() {
+        let mut successors = bb.successors.clone();
+        successors.sort();
+        successors.dedup();
+        for &successor in successors.iter() {
+            lines.push(format!(
+                "  {} -> {} [color={}];",
+                node_name(bb.addr),
+                node_name(successor),
+                edge_color(ws, bb, successor)?
+            ));
+        }
+    }
+
+    lines.push("}".to_string());
+    lines.push("".to_string());
+
+    Ok(lines.join("\n"))
+}
+
+/// Write the control flow graph of the function that starts at the given RVA
+/// to the given writer.
+pub fn export<W: Write>(ws: &Workspace, rva: RVA, mut w: W) -> Result<(), Error> {
+    w.write_all(render_function(ws, rva)?.as_bytes())?;
+    Ok(())
+}
@@ -0,0 +1,373 @@
+//! Import and export DynamoRIO drcov coverage files,
+//!  so that basic blocks observed during real execution can be overlaid onto
+//!  the static analysis, and so that basic blocks found by the analysis can be
+//!  visualized by coverage tools like Lighthouse.
+//!
+//! layout:
+//!
+//! ```text
+//! DRCOV VERSION: 2
+//! DRCOV FLAVOR: drcov
+//! Module Table: version 2, count 1
+//! Columns: id, base, end, entry, checksum, timestamp, path
+//!   0, 0x400000, 0x401000, 0x0000000000000000, 0x00000000, 0x00000000, C:\foo.exe
+//! BB Table: 1 bbs
+//! <binary: [{start: u32, size: u16, module id: u16}; 1]>
+//! ```
+//!
+//! The older version 1 module table (columns: id, size, path) is also
+//! supported.
+use std::{
+    collections::{BTreeMap, BTreeSet},
+    io::Write,
+};
+
+use byteorder::{ByteOrder, LittleEndian, WriteBytesExt};
+use failure::{Error, Fail};
+
+use super::super::{arch::RVA, basicblock::BasicBlock, workspace::Workspace};
+
+/// the tag applied to the start of each covered basic block.
+pub const COVERAGE_TAG: &str = "coverage";
+
+#[derive(Debug, Fail)]
+pub enum DrcovError {
+    #[fail(display = "Invalid drcov file")]
+    InvalidFormat,
+    #[fail(display = "The module was not found in the coverage file")]
+    ModuleNotFound,
+}
+
+#[derive(Debug, Clone)]
+pub struct Module {
+    pub id:   u16,
+    pub base: u64,
+    pub end:  u64,
+    pub path: String,
+}
+
+#[derive(Debug, Clone, Copy)]
+pub struct Block {
+    pub module_id: u16,
+    /// offset from the base of the module, aka. RVA.
+    pub offset:    u32,
+    pub size:      u16,
+}
+
+#[derive(Debug, Clone)]
+pub struct Coverage {
+    pub modules: Vec<Module>,
+    pub blocks:  Vec<Block>,
+}
+
+impl Coverage {
+    /// Find the module with the given file name, ignoring its directory and
+    /// case.
+    pub fn find_module(&self, name: &str) -> Option<&Module> {
+        let name = basename(name);
+        self.modules
+            .iter()
+            .find(|module| basename(&module.path).eq_ignore_ascii_case(name))
+    }
+}
+
+fn basename(path: &str) -> &str {
+    path.rsplit(|c| c == '/' || c == '\\').next().unwrap_or(path)
+}
+
+/// read the line starting at `offset`, and advance `offset` past its newline.
+fn read_line<'a>(buf: &'a [u8], offset: &mut usize) -> Result<&'a str, Error> {
+    let rest = &buf[*offset..];
+    let end = rest.iter().position(|&b| b == b'\n').ok_or(DrcovError::InvalidFormat)?;
+    *offset += end + 1;
+    Ok(std::str::from_utf8(&rest[..end])?.trim_end_matches('\r'))
+}
+
+fn parse_int(s: &str) -> Result<u64, Error> {
+    let s = s.trim();
+    let v = if s.starts_with("0x") {
+        u64::from_str_radix(&s[2..], 16)
+    } else {
+        s.parse::<u64>()
+    };
+    v.map_err(|_| DrcovError::InvalidFormat.into())
+}
+
+/// Parse the given drcov file.
+///
+/// Errors:
+///
+///   - InvalidFormat - if the file is not a supported drcov file.
+pub fn parse(buf: &[u8]) -> Result<Coverage, Error> {
+    let mut offset = 0;
+
+    if !read_line(buf, &mut offset)?.starts_with("DRCOV VERSION: ") {
+        return Err(DrcovError::InvalidFormat.into());
+    }
+
+    let mut line = read_line(buf, &mut offset)?;
+    if line.starts_with("DRCOV FLAVOR: ") {
+        line = read_line(buf, &mut offset)?;
+    }
+
+    // either `Module Table: 1` (v1) or `Module Table: version 2, count 1`.
+    if !line.starts_with("Module Table: ") {
+        return Err(DrcovError::InvalidFormat.into());
+    }
+    let count = parse_int(line.rsplit(' ').next().unwrap())?;
+
+    // v1 module tables don't have a columns header.
+    let mut columns: Vec<String> = vec!["id".to_string(), "size".to_string(), "path".to_string()];
+    let mut peek = offset;
+    let line = read_line(buf, &mut peek)?;
+    if line.starts_with("Columns: ") {
+        columns = line["Columns: ".len()..]
+            .split(',')
+            .map(|column| column.trim().to_string())
+            .collect();
+        offset = peek;
+    }
+    let column = |name: &str| columns.iter().position(|c| c == name);
+
+    let mut modules = vec![];
+    for _ in 0..count {
+        // the path is the final column, and may itself contain commas.
+        let fields: Vec<&str> = read_line(buf, &mut offset)?
+            .splitn(columns.len(), ',')
+            .map(|field| field.trim())
+            .collect();
+        if fields.len() != columns.len() {
+            return Err(DrcovError::InvalidFormat.into());
+        }
+
+        let field = |name: &str| column(name).map(|i| fields[i]);
+        let id = parse_int(field("id").ok_or(DrcovError::InvalidFormat)?)? as u16;
+        let base = match field("base").or_else(|| field("start")) {
+            Some(base) => parse_int(base)?,
+            None => 0,
+        };
+        let end = match (field("end"), field("size")) {
+            (Some(end), _) => parse_int(end)?,
+            (None, Some(size)) => base + parse_int(size)?,
+            (None, None) => return Err(DrcovError::InvalidFormat.into()),
+        };
+        let path = field("path").ok_or(DrcovError::InvalidFormat)?.to_string();
+
+        modules.push(Module { id, base, end, path });
+    }
+
+    // `BB Table: 1 bbs`
+    let line = read_line(buf, &mut offset)?;
+    if !line.starts_with("BB Table: ") {
+        return Err(DrcovError::InvalidFormat.into());
+    }
+    let count = parse_int(line["BB Table: ".len()..].trim_end_matches(" bbs"))? as usize;
+
+    let table = &buf[offset..];
+    match count.checked_mul(8) {
+        Some(size) if size <= table.len() => (),
+        _ => return Err(DrcovError::InvalidFormat.into()),
+    }
+
+    let blocks = table
+        .chunks_exact(8)
+        .take(count)
+        .map(|entry| Block {
+            offset:    LittleEndian::read_u32(&entry[0..4]),
+            size:      LittleEndian::read_u16(&entry[4..6]),
+            module_id: LittleEndian::read_u16(&entry[6..8]),
+        })
+        .collect();
+
+    Ok(Coverage { modules, blocks })
+}
+
+/// the start and length of each basic block of the functions in the workspace.
+fn get_basic_block_extents(ws: &Workspace) -> BTreeMap<RVA, u64> {
+    ws.get_functions()
+        .filter_map(|&f| ws.get_basic_blocks(f).ok())
+        .flatten()
+        .map(|bb| (bb.addr, bb.length))
+        .collect()
+}
+
+/// the basic blocks that overlap the given range of addresses:
+/// the one that contains its start, and any that start within it.
+fn get_overlapping_blocks(extents: &BTreeMap<RVA, u64>, start: RVA, end: RVA) -> Vec<RVA> {
+    let mut addrs = vec![];
+    if let Some((&addr, &length)) = extents.range(..=start).next_back() {
+        if start < addr + RVA::from(length as i64) {
+            addrs.push(addr);
+        }
+    }
+    addrs.extend(
+        extents
+            .range(start..end)
+            .map(|(&addr, _)| addr)
+            .filter(|&addr| addr != start),
+    );
+    addrs
+}
+
+/// Tag the basic blocks covered in the given drcov file with `COVERAGE_TAG`.
+/// The module is matched against the file name of the workspace.
+///
+/// Since dynamic basic blocks don't always line up with the ones found by the
+/// analysis, each covered range is mapped onto the analysis basic blocks that
+/// it overlaps. Ranges outside of any known basic block are tagged at their
+/// offset, since the code there was executed but hasn't been found yet.
+///
+/// Returns the number of distinct covered blocks.
+///
+/// ```
+/// use lancelot::test;
+/// use lancelot::arch::RVA;
+/// use lancelot::export::drcov;
+///
+/// let mut buf = b"DRCOV VERSION: 2\n\
+///                 DRCOV FLAVOR: drcov\n\
+///                 Module Table: version 2, count 2\n\
+///                 Columns: id, base, end, entry, path\n\
+///                 0, 0x400000, 0x401000, 0x0, C:\\Windows\\kernel32.dll\n\
+///                 1, 0x10000000, 0x10001000, 0x0, C:\\Users\\user\\FOO.BIN\n\
+///                 BB Table: 3 bbs\n"
+///     .to_vec();
+/// buf.extend_from_slice(b"\x01\x00\x00\x00\x01\x00\x01\x00"); // foo.bin+0x1, 1 byte
+/// buf.extend_from_slice(b"\x02\x00\x00\x00\x02\x00\x01\x00"); // foo.bin+0x2, 2 bytes
+/// buf.extend_from_slice(b"\x10\x00\x00\x00\x01\x00\x00\x00"); // kernel32.dll+0x10
+///
+/// let coverage = drcov::parse(&buf).unwrap();
+/// assert_eq!(coverage.modules.len(), 2);
+/// assert_eq!(coverage.blocks.len(), 3);
+/// assert_eq!(coverage.find_module("foo.bin").unwrap().base, 0x1000_0000);
+///
+/// // 0: 75 01  JNZ $+3
+/// // 2: 90     NOP
+/// // 3: C3     RETN
+/// let mut ws = test::get_shellcode32_workspace(b"\x75\x01\x90\xC3");
+/// ws.make_function(RVA(0x0)).unwrap();
+/// ws.analyze().unwrap();
+///
+/// // the range at 0x1 is within the block at 0x0,
+/// // and the range at 0x2 spans the blocks at 0x2 and 0x3.
+/// assert_eq!(drcov::import(&mut ws, &buf).unwrap(), 3);
+/// assert_eq!(ws.find_tagged(drcov::COVERAGE_TAG), vec![RVA(0x0), RVA(0x2), RVA(0x3)]);
+/// ```
+///
+/// Errors:
+///
+///   - InvalidFormat - if the file is not a supported drcov file.
+///   - ModuleNotFound - if the workspace's module is not in the file.
+pub fn import(ws: &mut Workspace, buf: &[u8]) -> Result<usize, Error> {
+    let coverage = parse(buf)?;
+    let module = coverage.find_module(&ws.filename).ok_or(DrcovError::ModuleNotFound)?;
+
+    let extents = get_basic_block_extents(ws);
+
+    let mut blocks: BTreeSet<RVA> = BTreeSet::new();
+    for block in coverage.blocks.iter().filter(|block| block.module_id == module.id) {
+        let start = RVA::from(block.offset as i64);
+        let end = start + block.size.max(1);
+        let overlapping = get_overlapping_blocks(&extents, start, end);
+        if overlapping.is_empty() {
+            blocks.insert(start);
+        } else {
+            blocks.extend(overlapping);
+        }
+    }
+
+    for &rva in blocks.iter() {
+        ws.make_tag(rva, COVERAGE_TAG)?;
+    }
+    ws.analyze()?;
+
+    Ok(blocks.len())
+}
+
+/// Write the given basic blocks as a drcov file, with the workspace as its
+/// single module.
+///
+/// Since drcov records the size of a block in 16 bits, a basic block larger
+/// than 0xFFFF bytes is written as consecutive entries.
+///
+/// ```
+/// use lancelot::test;
+/// use lancelot::arch::RVA;
+/// use lancelot::export::drcov;
+///
+/// // 0: 75 01  JNZ $+3
+/// // 2: 90     NOP
+/// // 3: C3     RETN
+/// let mut ws = test::get_shellcode32_workspace(b"\x75\x01\x90\xC3");
+/// ws.make_function(RVA(0x0)).unwrap();
+/// ws.analyze().unwrap();
+///
+/// let bbs = ws.get_basic_blocks(RVA(0x0)).unwrap();
+/// let mut buf = vec![];
+/// drcov::export(&ws, &bbs, &mut buf).unwrap();
+///
+/// let coverage = drcov::parse(&buf).unwrap();
+/// assert_eq!(coverage.find_module("foo.bin").unwrap().id, 0);
+/// let mut offsets: Vec<u32> = coverage.blocks.iter().map(|block| block.offset).collect();
+/// offsets.sort();
+/// assert_eq!(offsets, vec![0x0, 0x2, 0x3]);
+/// ```
+pub fn export<W: Write>(ws: &Workspace, bbs: &[BasicBlock], mut w: W) -> Result<(), Error> {
+    let base: u64 = ws.module.base_address.into();
+    let size: u64 = ws.module.max_address().into();
+
+    writeln!(w, "DRCOV VERSION: 2")?;
+    writeln!(w, "DRCOV FLAVOR: lancelot")?;
+    writeln!(w, "Module Table: version 2, count 1")?;
+    writeln!(w, "Columns: id, base, end, entry, path")?;
+    writeln!(w, "  0, {:#x}, {:#x}, 0x0, {}", base, base + size, ws.filename)?;
+
+    let mut entries: Vec<(u64, u16)> = vec![];
+    for bb in bbs.iter() {
+        let mut offset: u64 = bb.addr.into();
+        let mut remaining = bb.length;
+        loop {
+            let size = remaining.min(u64::from(u16::max_value()));
+            entries.push((offset, size as u16));
+            offset += size;
+            remaining -= size;
+            if remaining == 0 {
+                break;
+            }
+        }
+    }
+
+    writeln!(w, "BB Table: {} bbs", entries.len())?;
+    for (offset, size) in entries.into_iter() {
+        w.write_u32::<LittleEndian>(offset as u32)?;
+        w.write_u16::<LittleEndian>(size)?;
+        w.write_u16::<LittleEndian>(0)?;
+    }
+
+    Ok(())
+}
+
+/// Render the given basic blocks in the `module+offset` format,
+///  one per line, as accepted by Lighthouse and lightkeeper.
+///
+/// ```
+/// use lancelot::test;
+/// use lancelot::arch::RVA;
+/// use lancelot::export::drcov;
+///
+/// // 0: 75 01  JNZ $+3
+/// // 2: 90     NOP
+/// // 3: C3     RETN
+/// let mut ws = test::get_shellcode32_workspace(b"\x75\x01\x90\xC3");
+/// ws.make_function(RVA(0x0)).unwrap();
+/// ws.analyze().unwrap();
+///
+/// let bbs = ws.get_basic_blocks(RVA(0x0)).unwrap();
+/// assert_eq!(drcov::render_module_offsets(&ws, &bbs), "foo.bin+0\nfoo.bin+2\nfoo.bin+3\n");
+/// ```
+pub fn render_module_offsets(ws: &Workspace, bbs: &[BasicBlock]) -> String {
+    let name = basename(&ws.filename);
+    let mut addrs: Vec<RVA> = bbs.iter().map(|bb| bb.addr).collect();
+    addrs.sort();
+    addrs.iter().map(|addr| format!("{}+{:x}\n", name, addr)).collect()
+}
@@ -0,0 +1,177 @@
+//! Render the call graph of a workspace in the GraphML format,
+//! which can be consumed by tools like Gephi and NetworkX.
+//!
+//! Nodes are functions and imports, with the attributes:
+//!
+//!   - name: the demangled symbol name, or `sub_<address>`
+//!   - size: the total size of the function's basic blocks, in bytes
+//!   - import: true when the node is an import, rather than a function
+//!   - tags: the tags at the node's address, comma-separated
+use std::{
+    collections::{BTreeMap, BTreeSet},
+    io::Write,
+};
+
+use failure::Error;
+use zydis;
+
+use super::super::{
+    analysis,
+    arch::{RVA, VA},
+    demangle::demangle,
+    workspace::Workspace,
+    xref::XrefType,
+};
+
+fn escape(s: &str) -> String {
+    s.replace('&', "&amp;")
+        .replace('<', "&lt;")
+        .replace('>', "&gt;")
+        .replace('"', "&quot;")
+        .replace('\'', "&apos;")
+}
+
+/// Fetch the address of the import slot referenced by the call at the
+/// given RVA, like `call [__imp_ExitProcess]`.
+fn get_import_slot(ws: &Workspace, rva: RVA) -> Option<RVA> {
+    let insn = ws.read_insn(rva).ok()?;
+    let op = analysis::get_first_operand(&insn)?;
+
+    if op.ty != zydis::OperandType::MEMORY || op.mem.index != zydis::Register::NONE || !op.mem.disp.has_displacement {
+        return None;
+    }
+
+    let slot = if op.mem.base == zydis::Register::NONE {
+        if op.mem.disp.displacement < 0 {
+            return None;
+        }
+        ws.rva(VA::from(op.mem.disp.displacement as u64))?
+    } else if op.mem.base == zydis::Register::RIP {
+        rva + RVA::from(op.mem.disp.displacement) + insn.length
+    } else {
+        return None;
+    };
+
+    ws.get_symbol(slot).map(|_| slot)
+}
+
+struct Node {
+    size:   u64,
+    import: bool,
+}
+
+/// Render the call graph of the given workspace.
+///
+/// ```
+/// use lancelot::test;
+/// use lancelot::arch::RVA;
+/// use lancelot::export::graphml;
+///
+/// //  0: E8 07 00 00 00     CALL $+0xC
+/// //  5: FF 15 10 00 00 00  CALL [0x10]
+/// //  B: C3                 RETN
+/// //  C: C3                 RETN
+/// // 10: F0 FF FF FF        dd 0xFFFFFFF0
+/// let mut ws = test::get_shellcode32_workspace(
+///     b"\xE8\x07\x00\x00\x00\xFF\x15\x10\x00\x00\x00\xC3\xC3\x00\x00\x00\xF0\xFF\xFF\xFF");
+/// ws.make_symbol(RVA(0x10), "kernel32.dll!ExitProcess").unwrap();
+/// ws.make_function(RVA(0x0)).unwrap();
+/// ws.make_tag(RVA(0x0), "entry").unwrap();
+/// ws.analyze().unwrap();
+///
+/// let s = graphml::render_call_graph(&ws).unwrap();
+/// assert!(s.contains("<edge source=\"0x0\" target=\"0xc\"/>"));
+/// assert!(s.contains("<edge source=\"0x0\" target=\"0x10\"/>"));
+/// assert!(s.contains("<data key=\"name\">kernel32.dll!ExitProcess</data>"));
+/// assert!(s.contains("<data key=\"name\">sub_c</data>"));
+/// assert!(s.contains("<data key=\"size\">12</data>"));
+/// assert!(s.contains("<data key=\"tags\">entry</data>"));
+/// ```
+pub fn render_call_graph(ws: &Workspace) -> Result<String, Error> {
+    let mut nodes: BTreeMap<RVA, Node> = BTreeMap::new();
+    let mut edges: BTreeSet<(RVA, RVA)> = BTreeSet::new();
+
+    for &function in ws.get_functions() {
+        let bbs = ws.get_basic_blocks(function)?;
+
+        nodes.insert(
+            function,
+            Node {
+                size:   bbs.iter().map(|bb| bb.length).sum(),
+                import: false,
+            },
+        );
+
+        for &insn in bbs.iter().flat_map(|bb| bb.insns.iter()) {
+            let mut found = false;
+            for xref in ws
+                .get_xrefs_from(insn)?
+                .iter()
+                .filter(|xref| xref.typ == XrefType::Call)
+            {
+                edges.insert((function, xref.dst));
+                found = true;
+            }
+
+            if found {
+                continue;
+            }
+
+            match ws.read_insn(insn) {
+                Ok(ref i) if i.mnemonic == zydis::Mnemonic::CALL => {}
+                _ => continue,
+            }
+
+            if let Some(slot) = get_import_slot(ws, insn) {
+                nodes.entry(slot).or_insert(Node {
+                    size:   0,
+                    import: true,
+                });
+                edges.insert((function, slot));
+            }
+        }
+    }
+
+    let mut lines = vec![];
+    lines.push("<?xml version=\"1.0\" encoding=\"UTF-8\"?>".to_string());
+    lines.push("<graphml xmlns=\"http://graphml.graphdrawing.org/xmlns\">".to_string());
+    lines.push("  <key id=\"name\" for=\"node\" attr.name=\"name\" attr.type=\"string\"/>".to_string());
+    lines.push("  <key id=\"size\" for=\"node\" attr.name=\"size\" attr.type=\"long\"/>".to_string());
+    lines.push("  <key id=\"import\" for=\"node\" attr.name=\"import\" attr.type=\"boolean\"/>".to_string());
+    lines.push("  <key id=\"tags\" for=\"node\" attr.name=\"tags\" attr.type=\"string\"/>".to_string());
+    lines.push("  <graph id=\"callgraph\" edgedefault=\"directed\">".to_string());
+
+    for (&rva, node) in nodes.iter() {
+        let name = ws.get_name(rva);
+        let name = demangle(&name).unwrap_or(name);
+        let tags: Vec<String> = ws.get_tags(rva).iter().map(|tag| tag.to_string()).collect();
+
+        lines.push(format!("    <node id=\"{}\">", rva));
+        lines.push(format!("      <data key=\"name\">{}</data>", escape(&name)));
+        lines.push(format!("      <data key=\"size\">{}</data>", node.size));
+        lines.push(format!("      <data key=\"import\">{}</data>", node.import));
+        lines.push(format!("      <data key=\"tags\">{}</data>", escape(&tags.join(","))));
+        lines.push("    </node>".to_string());
+    }
+
+    for (src, dst) in edges.iter() {
+        if !nodes.contains_key(dst) {
+            // calls to addresses that are not functions, such as into the middle of a
+            // function.
+            continue;
+        }
+        lines.push(format!("    <edge source=\"{}\" target=\"{}\"/>", src, dst));
+    }
+
+    lines.push("  </graph>".to_string());
+    lines.push("</graphml>".to_string());
+    lines.push("".to_string());
+
+    Ok(lines.join("\n"))
+}
+
+/// Write the call graph of the given workspace to the given writer.
+pub fn export<W: Write>(ws: &Workspace, mut w: W) -> Result<(), Error> {
+    w.write_all(render_call_graph(ws)?.as_bytes())?;
+    Ok(())
+}
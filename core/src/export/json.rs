@@ -0,0 +1,599 @@
+//! Export and import the analysis results of a workspace as JSON.
+//!
+//! The document is sorted by address, so that two exports of the same
+//! analysis are byte-for-byte identical, and can be diffed.
+//!
+//! layout:
+//!
+//! ```json
+//! {
+//!   "version": 1,
+//!   "filename": "kernel32.dll",
+//!   "base_address": 6442450944,
+//!   "sections": [{"name": ".text", "rva": 4096, "size": 512, "perms": "r-x"}],
+//!   "functions": [{"rva": 4096, "basic_blocks": [{"rva": 4096, "length": 5, "successors": []}],
+//!                  "meta": {"calling_convention": "stdcall", "argument_count": 1, "frame_size": 8,
+//!                           "is_noreturn": false, "source": "PE entry point analyzer", "classes": ["Foo"],
+//!                           "md5": "0cc175b9c0f1b6a831c399e269772661",
+//!                           "sha256": "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb",
+//!                           "frame": {"slots": [{"offset": -8, "size": 4, "kind": "local", "name": "var_8"}],
+//!                                     "references": [{"rva": 4099, "offset": -8}]}}}],
+//!   "symbols": [{"rva": 4096, "name": "entry", "source": "analysis"}],
+//!   "xrefs": [{"src": 4096, "dst": 4101, "type": "call"}],
+//!   "comments": [{"rva": 4096, "type": "pre", "text": "entry point"}],
+//!   "tags": [{"rva": 4096, "tag": "crypto"}],
+//!   "strings": [{"rva": 8192, "encoding": "ascii", "text": "kernel32.dll", "source": "static scan", "references": [4096]}],
+//!   "properties": [{"name": "imphash", "value": "419a52bf699bf96906d83fa6b634c66e"}]
+//! }
+//! ```
+use std::io::{Read, Write};
+
+use failure::{Error, Fail};
+use serde_json::{self, json, Value};
+
+use super::super::{
+    arch::RVA,
+    comment::CommentType,
+    function::{CallingConvention, FunctionMeta, SlotKind, StackFrame, StackSlot},
+    loader::Permissions,
+    strings::{RecoveredString, StringEncoding},
+    symbol::SymbolSource,
+    workspace::Workspace,
+    xref::{Xref, XrefType},
+};
+
+/// the version of the document layout produced by `to_json`.
+pub const VERSION: u64 = 1;
+
+#[derive(Debug, Fail)]
+pub enum JsonError {
+    #[fail(display = "Unsupported document version")]
+    UnsupportedVersion,
+    #[fail(display = "Invalid document structure")]
+    InvalidDocument,
+}
+
+/// The name used for the given xref type in exported documents.
+pub fn xref_type_name(typ: XrefType) -> &'static str {
+    match typ {
+        XrefType::Fallthrough => "fallthrough",
+        XrefType::Call => "call",
+        XrefType::UnconditionalJump => "jmp",
+        XrefType::ConditionalJump => "cjmp",
+        XrefType::ConditionalMove => "cmov",
+    }
+}
+
+fn xref_type_from_name(name: &str) -> Option<XrefType> {
+    match name {
+        "fallthrough" => Some(XrefType::Fallthrough),
+        "call" => Some(XrefType::Call),
+        "jmp" => Some(XrefType::UnconditionalJump),
+        "cjmp" => Some(XrefType::ConditionalJump),
+        "cmov" => Some(XrefType::ConditionalMove),
+        _ => None,
+    }
+}
+
+fn comment_type_name(typ: CommentType) -> &'static str {
+    match typ {
+        CommentType::Pre => "pre",
+        CommentType::Inline => "inline",
+        CommentType::Post => "post",
+    }
+}
+
+fn comment_type_from_name(name: &str) -> Option<CommentType> {
+    match name {
+        "pre" => Some(CommentType::Pre),
+        "inline" => Some(CommentType::Inline),
+        "post" => Some(CommentType::Post),
+        _ => None,
+    }
+}
+
+fn string_encoding_name(encoding: StringEncoding) -> &'static str {
+    match encoding {
+        StringEncoding::Ascii => "ascii",
+        StringEncoding::Utf16 => "utf16",
+    }
+}
+
+fn string_encoding_from_name(name: &str) -> Option<StringEncoding> {
+    match name {
+        "ascii" => Some(StringEncoding::Ascii),
+        "utf16" => Some(StringEncoding::Utf16),
+        _ => None,
+    }
+}
+
+fn calling_convention_name(cc: CallingConvention) -> &'static str {
+    match cc {
+        CallingConvention::Unknown => "unknown",
+        CallingConvention::Cdecl => "cdecl",
+        CallingConvention::Stdcall => "stdcall",
+        CallingConvention::Fastcall => "fastcall",
+        CallingConvention::Thiscall => "thiscall",
+        CallingConvention::Win64 => "win64",
+    }
+}
+
+fn calling_convention_from_name(name: &str) -> Option<CallingConvention> {
+    match name {
+        "unknown" => Some(CallingConvention::Unknown),
+        "cdecl" => Some(CallingConvention::Cdecl),
+        "stdcall" => Some(CallingConvention::Stdcall),
+        "fastcall" => Some(CallingConvention::Fastcall),
+        "thiscall" => Some(CallingConvention::Thiscall),
+        "win64" => Some(CallingConvention::Win64),
+        _ => None,
+    }
+}
+
+fn slot_kind_name(kind: &SlotKind) -> &'static str {
+    match kind {
+        SlotKind::Local => "local",
+        SlotKind::SavedRegister(_) => "saved register",
+        SlotKind::ReturnAddress => "return address",
+        SlotKind::Argument => "argument",
+    }
+}
+
+fn perms_name(perms: Permissions) -> String {
+    [(Permissions::R, 'r'), (Permissions::W, 'w'), (Permissions::X, 'x')]
+        .iter()
+        .map(|&(p, c)| if perms.intersects(p) { c } else { '-' })
+        .collect()
+}
+
+fn get_rva(v: &Value, key: &str) -> Result<RVA, Error> {
+    v.get(key)
+        .and_then(Value::as_i64)
+        .map(RVA::from)
+        .ok_or_else(|| JsonError::InvalidDocument.into())
+}
+
+fn get_str<'a>(v: &'a Value, key: &str) -> Result<&'a str, Error> {
+    v.get(key)
+        .and_then(Value::as_str)
+        .ok_or_else(|| JsonError::InvalidDocument.into())
+}
+
+fn get_i64(v: &Value, key: &str) -> Result<i64, Error> {
+    v.get(key)
+        .and_then(Value::as_i64)
+        .ok_or_else(|| JsonError::InvalidDocument.into())
+}
+
+fn get_array<'a>(v: &'a Value, key: &str) -> Result<&'a Vec<Value>, Error> {
+    v.get(key)
+        .and_then(Value::as_array)
+        .ok_or_else(|| JsonError::InvalidDocument.into())
+}
+
+/// fetch a field that must be present, but is `null` when unknown.
+fn get_nullable<'a>(v: &'a Value, key: &str) -> Result<Option<&'a Value>, Error> {
+    match v.get(key) {
+        Some(Value::Null) => Ok(None),
+        Some(value) => Ok(Some(value)),
+        None => Err(JsonError::InvalidDocument.into()),
+    }
+}
+
+fn get_nullable_str(v: &Value, key: &str) -> Result<Option<String>, Error> {
+    match get_nullable(v, key)? {
+        Some(_) => Ok(Some(get_str(v, key)?.to_string())),
+        None => Ok(None),
+    }
+}
+
+fn frame_to_json(frame: &StackFrame) -> Value {
+    let jslots: Vec<Value> = frame
+        .slots
+        .iter()
+        .map(|slot| {
+            let mut jslot = json!({
+                "offset": slot.offset,
+                "size": slot.size,
+                "kind": slot_kind_name(&slot.kind),
+                "name": slot.name,
+            });
+            if let SlotKind::SavedRegister(register) = &slot.kind {
+                jslot["register"] = json!(register);
+            }
+            jslot
+        })
+        .collect();
+
+    let jreferences: Vec<Value> = frame
+        .references
+        .iter()
+        .map(|(&rva, &offset)| {
+            let addr: i64 = rva.into();
+            json!({
+                "rva": addr,
+                "offset": offset,
+            })
+        })
+        .collect();
+
+    json!({
+        "slots": jslots,
+        "references": jreferences,
+    })
+}
+
+fn frame_from_json(jframe: &Value) -> Result<StackFrame, Error> {
+    let mut frame = StackFrame::default();
+
+    for jslot in get_array(jframe, "slots")?.iter() {
+        let kind = match get_str(jslot, "kind")? {
+            "local" => SlotKind::Local,
+            "saved register" => SlotKind::SavedRegister(get_str(jslot, "register")?.to_string()),
+            "return address" => SlotKind::ReturnAddress,
+            "argument" => SlotKind::Argument,
+            _ => return Err(JsonError::InvalidDocument.into()),
+        };
+        let size = jslot
+            .get("size")
+            .and_then(Value::as_u64)
+            .filter(|&size| size <= u64::from(std::u8::MAX))
+            .ok_or(JsonError::InvalidDocument)? as u8;
+        frame.slots.push(StackSlot {
+            offset: get_i64(jslot, "offset")?,
+            size,
+            kind,
+            name: get_str(jslot, "name")?.to_string(),
+        });
+    }
+
+    for jreference in get_array(jframe, "references")?.iter() {
+        frame
+            .references
+            .insert(get_rva(jreference, "rva")?, get_i64(jreference, "offset")?);
+    }
+
+    Ok(frame)
+}
+
+fn meta_to_json(meta: &FunctionMeta) -> Value {
+    json!({
+        "calling_convention": calling_convention_name(meta.calling_convention),
+        "argument_count": meta.argument_count,
+        "frame_size": meta.frame_size,
+        "is_noreturn": meta.is_noreturn,
+        "source": meta.source,
+        "classes": meta.classes,
+        "md5": meta.md5,
+        "sha256": meta.sha256,
+        "frame": meta.frame.as_ref().map(frame_to_json),
+    })
+}
+
+fn meta_from_json(jmeta: &Value) -> Result<FunctionMeta, Error> {
+    let calling_convention =
+        calling_convention_from_name(get_str(jmeta, "calling_convention")?).ok_or(JsonError::InvalidDocument)?;
+
+    // optional fields are `null` when unknown.
+    let argument_count = match get_nullable(jmeta, "argument_count")? {
+        None => None,
+        Some(v) => Some(
+            v.as_u64()
+                .filter(|&count| count <= u64::from(std::u32::MAX))
+                .ok_or(JsonError::InvalidDocument)? as u32,
+        ),
+    };
+    let frame_size = match get_nullable(jmeta, "frame_size")? {
+        None => None,
+        Some(v) => Some(v.as_u64().ok_or(JsonError::InvalidDocument)?),
+    };
+    let frame = match get_nullable(jmeta, "frame")? {
+        None => None,
+        Some(jframe) => Some(frame_from_json(jframe)?),
+    };
+    let classes = get_array(jmeta, "classes")?
+        .iter()
+        .map(|class| {
+            class
+                .as_str()
+                .map(str::to_string)
+                .ok_or_else(|| JsonError::InvalidDocument.into())
+        })
+        .collect::<Result<Vec<String>, Error>>()?;
+
+    Ok(FunctionMeta {
+        calling_convention,
+        argument_count,
+        frame_size,
+        frame,
+        is_noreturn: jmeta
+            .get("is_noreturn")
+            .and_then(Value::as_bool)
+            .ok_or(JsonError::InvalidDocument)?,
+        source: get_nullable_str(jmeta, "source")?,
+        classes,
+        md5: get_nullable_str(jmeta, "md5")?,
+        sha256: get_nullable_str(jmeta, "sha256")?,
+    })
+}
+
+/// Render the analysis results of the given workspace into a JSON document.
+///
+/// Fallthrough flows are not included, since they're recomputed
+///  when the instructions are re-analyzed.
+/// Sections are included for reference, but are not imported,
+///  since they're provided by the loader.
+pub fn to_json(ws: &Workspace) -> Result<Value, Error> {
+    let jsections: Vec<Value> = ws
+        .module
+        .sections
+        .iter()
+        .map(|section| {
+            let addr: i64 = section.addr.into();
+            json!({
+                "name": section.name,
+                "rva": addr,
+                "size": section.size,
+                "perms": perms_name(section.perms),
+            })
+        })
+        .collect();
+
+    let mut functions: Vec<RVA> = ws.get_functions().cloned().collect();
+    functions.sort();
+
+    let mut jfunctions = vec![];
+    for &function in functions.iter() {
+        let mut bbs = ws.get_basic_blocks(function).unwrap_or_else(|_| vec![]);
+        bbs.sort_by(|a, b| a.addr.cmp(&b.addr));
+
+        let jbbs: Vec<Value> = bbs
+            .iter()
+            .map(|bb| {
+                let mut successors: Vec<i64> = bb.successors.iter().map(|&s| s.into()).collect();
+                successors.sort();
+                let addr: i64 = bb.addr.into();
+                json!({
+                    "rva": addr,
+                    "length": bb.length,
+                    "successors": successors,
+                })
+            })
+            .collect();
+
+        let meta = ws.get_function_meta(function).cloned().unwrap_or_default();
+        let addr: i64 = function.into();
+        jfunctions.push(json!({
+            "rva": addr,
+            "basic_blocks": jbbs,
+            "meta": meta_to_json(&meta),
+        }));
+    }
+
+    let mut symbols: Vec<(&RVA, &String)> = ws.analysis.symbols.iter().collect();
+    symbols.sort();
+    let jsymbols: Vec<Value> = symbols
+        .iter()
+        .map(|(rva, name)| {
+            let addr: i64 = (**rva).into();
+            let source = ws.get_symbol_source(**rva).unwrap_or(SymbolSource::Analysis);
+            json!({
+                "rva": addr,
+                "name": name,
+                "source": source.name(),
+            })
+        })
+        .collect();
+
+    let mut xrefs: Vec<&Xref> = ws.analysis.flow.xrefs.from.values().flatten().collect();
+    xrefs.sort_by(|a, b| (a.src, a.dst).cmp(&(b.src, b.dst)));
+    let jxrefs: Vec<Value> = xrefs
+        .iter()
+        .map(|xref| {
+            let src: i64 = xref.src.into();
+            let dst: i64 = xref.dst.into();
+            json!({
+                "src": src,
+                "dst": dst,
+                "type": xref_type_name(xref.typ),
+            })
+        })
+        .collect();
+
+    let mut comments: Vec<(&(RVA, CommentType), &String)> = ws.analysis.comments.iter().collect();
+    comments.sort();
+    let jcomments: Vec<Value> = comments
+        .iter()
+        .map(|((rva, typ), text)| {
+            let addr: i64 = (*rva).into();
+            json!({
+                "rva": addr,
+                "type": comment_type_name(*typ),
+                "text": text,
+            })
+        })
+        .collect();
+
+    let mut tags: Vec<(RVA, &String)> = ws
+        .analysis
+        .tags
+        .iter()
+        .flat_map(|(&rva, tags)| tags.iter().map(move |tag| (rva, tag)))
+        .collect();
+    tags.sort();
+    let jtags: Vec<Value> = tags
+        .iter()
+        .map(|(rva, tag)| {
+            let addr: i64 = (*rva).into();
+            json!({
+                "rva": addr,
+                "tag": tag,
+            })
+        })
+        .collect();
+
+    let jstrings: Vec<Value> = ws
+        .get_strings()
+        .iter()
+        .map(|s| {
+            let addr: i64 = s.rva.into();
+            let references: Vec<i64> = s.references.iter().map(|&r| r.into()).collect();
+            json!({
+                "rva": addr,
+                "encoding": string_encoding_name(s.encoding),
+                "text": s.text,
+                "source": s.source,
+                "references": references,
+            })
+        })
+        .collect();
+
+    let jproperties: Vec<Value> = ws
+        .get_properties()
+        .iter()
+        .map(|(name, value)| {
+            json!({
+                "name": name,
+                "value": value,
+            })
+        })
+        .collect();
+
+    let base_address: u64 = ws.module.base_address.into();
+    Ok(json!({
+        "version": VERSION,
+        "filename": ws.filename,
+        "base_address": base_address,
+        "sections": jsections,
+        "functions": jfunctions,
+        "symbols": jsymbols,
+        "xrefs": jxrefs,
+        "comments": jcomments,
+        "tags": jtags,
+        "strings": jstrings,
+        "properties": jproperties,
+    }))
+}
+
+/// Write the analysis results of the given workspace as JSON to the given
+/// writer.
+pub fn export<W: Write>(ws: &Workspace, w: W) -> Result<(), Error> {
+    serde_json::to_writer_pretty(w, &to_json(ws)?)?;
+    Ok(())
+}
+
+/// Apply the analysis results found in the given JSON document to the
+/// workspace.
+///
+/// Basic blocks are not imported directly,
+///  as they're reconstructed from the imported functions and flows.
+/// Function metadata is applied once the functions have been analyzed,
+///  replacing whatever the analysis inferred.
+///
+/// Errors:
+///
+///   - UnsupportedVersion - if the document was produced by an incompatible
+///     version.
+///   - InvalidDocument - if the document is missing expected fields.
+pub fn from_json(ws: &mut Workspace, doc: &Value) -> Result<(), Error> {
+    match doc.get("version").and_then(Value::as_u64) {
+        Some(VERSION) => {}
+        _ => return Err(JsonError::UnsupportedVersion.into()),
+    };
+
+    for xref in get_array(doc, "xrefs")?.iter() {
+        let typ = xref_type_from_name(get_str(xref, "type")?).ok_or(JsonError::InvalidDocument)?;
+        ws.make_xref(Xref {
+            src: get_rva(xref, "src")?,
+            dst: get_rva(xref, "dst")?,
+            typ,
+        })?;
+    }
+
+    for function in get_array(doc, "functions")?.iter() {
+        ws.make_function(get_rva(function, "rva")?)?;
+    }
+
+    for symbol in get_array(doc, "symbols")?.iter() {
+        let source = SymbolSource::from_name(get_str(symbol, "source")?).ok_or(JsonError::InvalidDocument)?;
+        ws.make_symbol_from(get_rva(symbol, "rva")?, get_str(symbol, "name")?, source)?;
+    }
+
+    for comment in get_array(doc, "comments")?.iter() {
+        let typ = comment_type_from_name(get_str(comment, "type")?).ok_or(JsonError::InvalidDocument)?;
+        ws.make_comment(get_rva(comment, "rva")?, typ, get_str(comment, "text")?)?;
+    }
+
+    for tag in get_array(doc, "tags")?.iter() {
+        ws.make_tag(get_rva(tag, "rva")?, get_str(tag, "tag")?)?;
+    }
+
+    for s in get_array(doc, "strings")?.iter() {
+        let encoding = string_encoding_from_name(get_str(s, "encoding")?).ok_or(JsonError::InvalidDocument)?;
+        let references = get_array(s, "references")?
+            .iter()
+            .map(|r| {
+                r.as_i64()
+                    .map(RVA::from)
+                    .ok_or_else(|| JsonError::InvalidDocument.into())
+            })
+            .collect::<Result<Vec<RVA>, Error>>()?;
+        ws.make_string(RecoveredString {
+            rva: get_rva(s, "rva")?,
+            encoding,
+            text: get_str(s, "text")?.to_string(),
+            source: get_str(s, "source")?.to_string(),
+            references,
+        })?;
+    }
+
+    for property in get_array(doc, "properties")?.iter() {
+        ws.set_property(get_str(property, "name")?, get_str(property, "value")?);
+    }
+
+    ws.analyze()?;
+
+    for function in get_array(doc, "functions")?.iter() {
+        let jmeta = function.get("meta").ok_or(JsonError::InvalidDocument)?;
+        ws.set_function_meta(get_rva(function, "rva")?, meta_from_json(jmeta)?)?;
+    }
+
+    Ok(())
+}
+
+/// Read a JSON document from the given reader and apply its analysis results
+/// to the workspace.
+///
+/// ```
+/// use lancelot::test;
+/// use lancelot::arch::RVA;
+/// use lancelot::export::json;
+/// use lancelot::comment::CommentType;
+///
+/// // E8 00 00 00 00  CALL $+5
+/// // C3              RETN
+/// let mut ws = test::get_shellcode32_workspace(b"\xE8\x00\x00\x00\x00\xC3");
+/// ws.make_function(RVA(0x0)).unwrap();
+/// ws.make_symbol(RVA(0x0), "entry").unwrap();
+/// ws.make_comment(RVA(0x5), CommentType::Inline, "return").unwrap();
+/// ws.make_tag(RVA(0x0), "triage").unwrap();
+/// ws.set_property("imphash", "00112233445566778899aabbccddeeff");
+/// ws.analyze().unwrap();
+///
+/// let mut buf = vec![];
+/// json::export(&ws, &mut buf).unwrap();
+///
+/// let mut ws2 = test::get_shellcode32_workspace(b"\xE8\x00\x00\x00\x00\xC3");
+/// json::import(&mut ws2, &buf[..]).unwrap();
+/// assert_eq!(ws2.get_symbol(RVA(0x0)).unwrap(), "entry");
+/// assert_eq!(ws2.get_functions().count(), 2);
+/// assert_eq!(ws2.get_comment(RVA(0x5), CommentType::Inline).unwrap(), "return");
+/// assert_eq!(ws2.find_tagged("triage"), vec![RVA(0x0)]);
+/// assert_eq!(ws2.get_property("imphash").unwrap(), "00112233445566778899aabbccddeeff");
+/// assert_eq!(json::to_json(&ws).unwrap(), json::to_json(&ws2).unwrap());
+/// assert_eq!(json::to_json(&ws).unwrap()["sections"][0]["perms"], "rwx");
+/// ```
+pub fn import<R: Read>(ws: &mut Workspace, r: R) -> Result<(), Error> {
+    let doc: Value = serde_json::from_reader(r)?;
+    from_json(ws, &doc)
+}
@@ -0,0 +1,187 @@
+//! Stream analysis events as JSON lines, one object per newly discovered
+//! artifact, so that the output can be piped into `jq` or a log collector.
+//!
+//! layout:
+//!
+//! ```json
+//! {"timestamp": "2019-08-01T12:00:00.000000000+00:00", "type": "function", "rva": 4096}
+//! {"timestamp": "2019-08-01T12:00:00.000000000+00:00", "type": "xref", "src": 4096, "dst": 4101, "xref_type": "call"}
+//! {"timestamp": "2019-08-01T12:00:00.000000000+00:00", "type": "symbol", "rva": 4096, "name": "entry"}
+//! {"timestamp": "2019-08-01T12:00:00.000000000+00:00", "type": "string", "rva": 8192, "text": "kernel32.dll"}
+//! {"timestamp": "2019-08-01T12:00:00.000000000+00:00", "type": "rename", "rva": 4096, "previous": "entry", "name": "main"}
+//! {"timestamp": "2019-08-01T12:00:00.000000000+00:00", "type": "pass", "name": "PE exports analyzer", "error": null}
+//! {"timestamp": "2019-08-01T12:00:00.000000000+00:00", "type": "progress", "pass": 1, "total": 4, "fraction": 0.25, "remaining": 1.5}
+//! ```
+//!
+//! `pass` is the number of analyzers that have run, and `remaining` is the
+//! estimated number of seconds until the rest complete, or null if unknown.
+use std::io::Write;
+
+use chrono;
+use failure::Error;
+use log::warn;
+use serde_json::{json, Value};
+
+use super::{
+    super::{
+        analysis::{listener::Progress, AnalysisListener},
+        arch::RVA,
+        strings::RecoveredString,
+        xref::Xref,
+    },
+    json::xref_type_name,
+};
+
+/// An analysis listener that writes each new artifact as a line of JSON
+/// to the given writer.
+///
+/// ```
+/// use std::{cell::RefCell, io::Write, rc::Rc, time::Duration};
+/// use lancelot::test;
+/// use lancelot::arch::RVA;
+/// use lancelot::analysis::listener::Progress;
+/// use lancelot::export::jsonl::JsonlListener;
+///
+/// struct Shared(Rc<RefCell<Vec<u8>>>);
+///
+/// impl Write for Shared {
+///     fn write(&mut self, buf: &[u8]) -> std::io::Result<usize> {
+///         self.0.borrow_mut().write(buf)
+///     }
+///
+///     fn flush(&mut self) -> std::io::Result<()> {
+///         Ok(())
+///     }
+/// }
+///
+/// // 0: E8 00 00 00 00  CALL $+5
+/// // 5: C3              RETN
+/// let mut ws = test::get_shellcode32_workspace(b"\xE8\x00\x00\x00\x00\xC3");
+/// let buf = Rc::new(RefCell::new(vec![]));
+/// ws.add_listener(Box::new(JsonlListener::new(Shared(buf.clone()))));
+/// ws.make_function(RVA(0x0)).unwrap();
+/// ws.make_symbol(RVA(0x0), "entry").unwrap();
+/// ws.analyze().unwrap();
+/// ws.rename_symbol(RVA(0x0), "main").unwrap();
+/// ws.analyze().unwrap();
+/// ws.report_progress(&Progress {
+///     completed: 1,
+///     total:     4,
+///     elapsed:   Duration::from_millis(500),
+/// });
+///
+/// let buf = buf.borrow();
+/// let lines: Vec<serde_json::Value> = std::str::from_utf8(&buf)
+///     .unwrap()
+///     .lines()
+///     .map(|line| serde_json::from_str(line).unwrap())
+///     .collect();
+/// assert_eq!(lines[0]["type"], "function");
+/// assert_eq!(lines[0]["rva"], 0);
+/// assert!(lines
+///     .iter()
+///     .any(|line| line["type"] == "xref" && line["xref_type"] == "call" && line["dst"] == 5));
+/// assert!(lines
+///     .iter()
+///     .any(|line| line["type"] == "rename" && line["previous"] == "entry" && line["name"] == "main"));
+///
+/// let progress = lines.last().unwrap();
+/// assert_eq!(progress["type"], "progress");
+/// assert_eq!(progress["pass"], 1);
+/// assert_eq!(progress["total"], 4);
+/// assert_eq!(progress["fraction"], 0.25);
+/// assert_eq!(progress["remaining"], 1.5);
+/// ```
+pub struct JsonlListener<W: Write> {
+    w: W,
+}
+
+impl<W: Write> JsonlListener<W> {
+    pub fn new(w: W) -> JsonlListener<W> {
+        JsonlListener { w }
+    }
+
+    fn emit(&mut self, mut event: Value) {
+        event["timestamp"] = json!(chrono::Utc::now().to_rfc3339());
+
+        // listeners can't fail the analysis, so the best we can do is complain.
+        if let Err(e) = writeln!(self.w, "{}", event) {
+            warn!("failed to write event: {}", e);
+        }
+    }
+}
+
+impl<W: Write> AnalysisListener for JsonlListener<W> {
+    fn on_new_function(&mut self, rva: RVA) {
+        let addr: i64 = rva.into();
+        self.emit(json!({
+            "type": "function",
+            "rva": addr,
+        }));
+    }
+
+    fn on_new_xref(&mut self, xref: &Xref) {
+        let src: i64 = xref.src.into();
+        let dst: i64 = xref.dst.into();
+        self.emit(json!({
+            "type": "xref",
+            "src": src,
+            "dst": dst,
+            "xref_type": xref_type_name(xref.typ),
+        }));
+    }
+
+    fn on_new_symbol(&mut self, rva: RVA, name: &str) {
+        let addr: i64 = rva.into();
+        self.emit(json!({
+            "type": "symbol",
+            "rva": addr,
+            "name": name,
+        }));
+    }
+
+    fn on_new_string(&mut self, s: &RecoveredString) {
+        let addr: i64 = s.rva.into();
+        self.emit(json!({
+            "type": "string",
+            "rva": addr,
+            "text": s.text,
+        }));
+    }
+
+    fn on_symbol_renamed(&mut self, rva: RVA, previous: &str, name: &str) {
+        let addr: i64 = rva.into();
+        self.emit(json!({
+            "type": "rename",
+            "rva": addr,
+            "previous": previous,
+            "name": name,
+        }));
+    }
+
+    fn on_pass_completed(&mut self, name: &str) {
+        self.emit(json!({
+            "type": "pass",
+            "name": name,
+            "error": Value::Null,
+        }));
+    }
+
+    fn on_pass_failed(&mut self, name: &str, error: &Error) {
+        self.emit(json!({
+            "type": "pass",
+            "name": name,
+            "error": error.to_string(),
+        }));
+    }
+
+    fn on_progress(&mut self, progress: &Progress) {
+        self.emit(json!({
+            "type": "progress",
+            "pass": progress.completed,
+            "total": progress.total,
+            "fraction": progress.fraction(),
+            "remaining": progress.remaining().map(|remaining| remaining.as_secs_f64()),
+        }));
+    }
+}
@@ -0,0 +1,113 @@
+//! Import names from map files produced by IDA Pro or the MSVC linker,
+//!  so that labels from other tools carry over into the workspace.
+//!
+//! Both formats list symbols as `section:offset name` following a
+//! `Publics by Value` header, where sections are numbered from 1 in the order
+//! of the PE section table:
+//!
+//! ```text
+//!   Address         Publics by Value              Rva+Base       Lib:Object
+//!
+//!  0001:00000000       _main                      00401000 f   main.obj
+//! ```
+//!
+//! Symbols in section 0 are absolute values, not addresses, and are ignored.
+use failure::Error;
+use lazy_static::lazy_static;
+use log::{debug, warn};
+use regex::Regex;
+
+use super::super::{arch::RVA, loader::Section, symbol::SymbolSource, workspace::Workspace};
+
+/// Parse the `(section, offset, name)` entries from the given map file.
+///
+/// ```
+/// use lancelot::export::mapfile;
+///
+/// let map = " Start         Length     Name                   Class
+///  0001:00000000 000000010H .text                  CODE
+///
+///   Address         Publics by Value
+///
+///  0001:00000000       _main
+///  0001:00000004       _helper                    00401004 f   main.obj
+/// ";
+/// assert_eq!(
+///     mapfile::parse(map),
+///     vec![(1, 0x0, "_main".to_string()), (1, 0x4, "_helper".to_string())]
+/// );
+/// ```
+pub fn parse(s: &str) -> Vec<(u16, u64, String)> {
+    lazy_static! {
+        static ref SYMBOL_RE: Regex = Regex::new(r"^\s*([0-9A-Fa-f]{4}):([0-9A-Fa-f]{8,16})\s+(\S+)").unwrap();
+    }
+
+    let mut entries = vec![];
+    // the segment table at the start of the file uses the same address syntax,
+    // so only consider lines after the symbol header.
+    let mut in_publics = false;
+    for line in s.lines() {
+        if line.contains("Publics by") {
+            in_publics = true;
+            continue;
+        }
+        if !in_publics {
+            continue;
+        }
+
+        if let Some(caps) = SYMBOL_RE.captures(line) {
+            // the regex guarantees these are valid hex.
+            let section = u16::from_str_radix(&caps[1], 16).unwrap();
+            let offset = u64::from_str_radix(&caps[2], 16).unwrap();
+            entries.push((section, offset, caps[3].to_string()));
+        }
+    }
+    entries
+}
+
+/// Apply the names from the given map file to the workspace as debug info,
+///  replacing symbols from lower priority sources, like exports,
+///  and return the number of names applied.
+///
+/// ```
+/// use lancelot::test;
+/// use lancelot::arch::RVA;
+/// use lancelot::export::mapfile;
+///
+/// let mut ws = test::get_shellcode32_workspace(b"\x90\xC3");
+/// let map = "  Address         Publics by Value\n\n 0001:00000001       _ret\n";
+/// assert_eq!(mapfile::import(&mut ws, map).unwrap(), 1);
+/// assert_eq!(ws.get_symbol(RVA(0x1)).unwrap(), "_ret");
+/// ```
+pub fn import(ws: &mut Workspace, s: &str) -> Result<usize, Error> {
+    // the PE loader maps the headers as an additional, leading section.
+    let sections: Vec<&Section> = ws
+        .module
+        .sections
+        .iter()
+        .filter(|section| section.name != "header")
+        .collect();
+
+    let mut names: Vec<(RVA, String)> = vec![];
+    for (index, offset, name) in parse(s).into_iter() {
+        if index == 0 {
+            continue;
+        }
+
+        match sections.get(index as usize - 1) {
+            Some(section) => names.push((section.addr + RVA::from(offset as i64), name)),
+            None => warn!(
+                "map file symbol in unknown section: {:04x}:{:08x} {}",
+                index, offset, name
+            ),
+        }
+    }
+
+    debug!("importing {} names from map file", names.len());
+    for (rva, name) in names.iter() {
+        ws.make_symbol_from(*rva, name, SymbolSource::DebugInfo)?;
+    }
+    ws.analyze()?;
+
+    Ok(names.len())
+}
@@ -0,0 +1,9 @@
+//! Routines that serialize the contents of a workspace into formats
+//! consumed by other tools.
+
+pub mod json;
+pub mod dot;
+pub mod graphml;
+pub mod jsonl;
+pub mod drcov;
+pub mod mapfile;
@@ -134,6 +134,22 @@ impl FlowMeta {
         self.0 |= 0b0010_0000;
     }
 
+    /// Unset the bit indicating that another instruction falls through to
+    /// this instruction.
+    ///
+    /// ```
+    /// use lancelot::flowmeta::*;
+    /// let mut m = FlowMeta::zero();
+    /// m.set_other_fallthrough_to();
+    /// assert_eq!(m.does_other_fallthrough_to(), true);
+    ///
+    /// m.unset_other_fallthrough_to();
+    /// assert_eq!(m.does_other_fallthrough_to(), false);
+    /// ```
+    pub fn unset_other_fallthrough_to(&mut self) {
+        self.0 &= 0b1101_1111
+    }
+
     /// Does the instruction have flow xrefs from it?
     /// This does not include the fallthrough flow.
     pub fn has_xrefs_from(self) -> bool {
@@ -189,6 +205,27 @@ impl FlowMeta {
     pub fn unset_xrefs_to(&mut self) {
         self.0 &= 0b0111_1111
     }
+
+    /// Forget the instruction here: its length, fallthrough, and flow xrefs
+    /// from it. The flow to this address from other instructions is kept.
+    ///
+    /// ```
+    /// use lancelot::flowmeta::*;
+    /// let mut m = FlowMeta::zero();
+    /// m.set_insn_length(2);
+    /// m.set_fallthrough();
+    /// m.set_xrefs_from();
+    /// m.set_xrefs_to();
+    ///
+    /// m.unset_insn();
+    /// assert_eq!(m.is_insn(), false);
+    /// assert_eq!(m.does_fallthrough(), false);
+    /// assert_eq!(m.has_xrefs_from(), false);
+    /// assert_eq!(m.has_xrefs_to(), true);
+    /// ```
+    pub fn unset_insn(&mut self) {
+        self.0 &= 0b1010_0000
+    }
 }
 
 impl fmt::Display for FlowMeta {
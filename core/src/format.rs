@@ -0,0 +1,127 @@
+//! Render addresses for display, like `CreateFileA`, `sub_401000+0x10`,
+//!  or `.text+0x10`.
+//!
+//! An address is rendered by the first formatter in a chain that recognizes
+//! it. Users can add their own formatters to the front of the chain via
+//! `Workspace::add_address_formatter`, such as to consult an external database
+//! of names.
+use super::{arch::RVA, demangle::demangle, workspace::Workspace};
+
+pub trait AddressFormatter {
+    /// Render the given address, or return `None` to defer to the next
+    /// formatter in the chain.
+    fn format_address(&self, ws: &Workspace, rva: RVA) -> Option<String>;
+}
+
+/// Render addresses that have a symbol or that start a function,
+///  like `CreateFileA` or `sub_401000`. C++ names are demangled.
+pub struct SymbolFormatter;
+
+impl AddressFormatter for SymbolFormatter {
+    fn format_address(&self, ws: &Workspace, rva: RVA) -> Option<String> {
+        if ws.get_symbol(rva).is_some() || ws.analysis.functions.contains_key(&rva) {
+            let name = ws.get_name(rva);
+            Some(demangle(&name).unwrap_or(name))
+        } else {
+            None
+        }
+    }
+}
+
+/// Render addresses relative to the closest preceding symbol or function
+///  in the same section, like `sub_401000+0x10`.
+pub struct NearestLabelFormatter;
+
+impl AddressFormatter for NearestLabelFormatter {
+    fn format_address(&self, ws: &Workspace, rva: RVA) -> Option<String> {
+        let section = ws.get_section(rva)?;
+
+        // TODO: this is a linear scan, consider an ordered index if it shows up in
+        // profiles.
+        let label = ws
+            .analysis
+            .symbols
+            .keys()
+            .chain(ws.analysis.functions.keys())
+            .filter(|&&label| section.contains(label) && label <= rva)
+            .max()?;
+
+        Some(format!("{}+{}", ws.get_name(*label), rva - *label))
+    }
+}
+
+/// Render addresses relative to the start of their section, like
+/// `.text+0x10`.
+pub struct SectionFormatter;
+
+impl AddressFormatter for SectionFormatter {
+    fn format_address(&self, ws: &Workspace, rva: RVA) -> Option<String> {
+        let section = ws.get_section(rva)?;
+        Some(format!("{}+{}", section.name, rva - section.addr))
+    }
+}
+
+/// The formatters consulted after any user formatters, in order.
+pub fn default_formatters() -> Vec<Box<dyn AddressFormatter>> {
+    vec![
+        Box::new(SymbolFormatter),
+        Box::new(NearestLabelFormatter),
+        Box::new(SectionFormatter),
+    ]
+}
+
+impl Workspace {
+    /// Add a formatter that is consulted before the existing formatters.
+    pub fn add_address_formatter(&mut self, formatter: Box<dyn AddressFormatter>) {
+        self.formatters.insert(0, formatter);
+    }
+
+    /// Render the given address for display using the chain of formatters,
+    ///  falling back to its virtual address.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::workspace::Workspace;
+    /// use lancelot::format::AddressFormatter;
+    ///
+    /// struct Entry;
+    ///
+    /// impl AddressFormatter for Entry {
+    ///     fn format_address(&self, _ws: &Workspace, rva: RVA) -> Option<String> {
+    ///         if rva == RVA(0x0) {
+    ///             Some("entry".to_string())
+    ///         } else {
+    ///             None
+    ///         }
+    ///     }
+    /// }
+    ///
+    /// // 0: 75 01  JNZ $+3
+    /// // 2: 90     NOP
+    /// // 3: C3     RETN
+    /// let mut ws = test::get_shellcode32_workspace(b"\x75\x01\x90\xC3");
+    /// assert_eq!(ws.format_address(RVA(0x2)), "raw+0x2");
+    /// assert_eq!(ws.format_address(RVA(0x10)), "0x10");
+    ///
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.analyze().unwrap();
+    /// assert_eq!(ws.format_address(RVA(0x0)), "sub_0");
+    /// assert_eq!(ws.format_address(RVA(0x2)), "sub_0+0x2");
+    ///
+    /// ws.add_address_formatter(Box::new(Entry));
+    /// assert_eq!(ws.format_address(RVA(0x0)), "entry");
+    /// ```
+    pub fn format_address(&self, rva: RVA) -> String {
+        for formatter in self.formatters.iter() {
+            if let Some(s) = formatter.format_address(self, rva) {
+                return s;
+            }
+        }
+
+        match self.va(rva) {
+            Some(va) => format!("{}", va),
+            None => format!("{}", rva),
+        }
+    }
+}
@@ -0,0 +1,142 @@
+use std::collections::BTreeMap;
+
+use super::arch::RVA;
+
+#[derive(Debug, Copy, Clone, PartialEq, Eq)]
+pub enum CallingConvention {
+    Unknown,
+    Cdecl,
+    Stdcall,
+    Fastcall,
+    Thiscall,
+    // the x64 Windows convention: rcx, rdx, r8, r9
+    Win64,
+}
+
+impl Default for CallingConvention {
+    fn default() -> CallingConvention {
+        CallingConvention::Unknown
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum SlotKind {
+    Local,
+    /// the entry value of a callee-saved register, like `ebp`.
+    SavedRegister(String),
+    ReturnAddress,
+    Argument,
+}
+
+/// A location in the stack frame of a function.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct StackSlot {
+    /// offset from the stack pointer on entry to the function,
+    /// which points to the return address.
+    pub offset: i64,
+    /// size in bytes of the largest access, or 0 if only its address is taken.
+    pub size:   u8,
+    pub kind:   SlotKind,
+    /// symbolic name, like `var_8`, `arg_0`, or `saved_ebp`.
+    pub name:   String,
+}
+
+/// The stack frame of a function: its locals, saved registers, and
+/// arguments.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct StackFrame {
+    /// the slots, sorted by offset.
+    pub slots:      Vec<StackSlot>,
+    /// the offset of the slot accessed by the explicit memory operand of each
+    /// instruction, like `MOV EAX, [EBP+0x8]`.
+    pub references: BTreeMap<RVA, i64>,
+}
+
+impl StackFrame {
+    pub fn get_slot(&self, offset: i64) -> Option<&StackSlot> {
+        self.slots.iter().find(|slot| slot.offset == offset)
+    }
+
+    /// the slot accessed by the explicit memory operand of the instruction at
+    /// the given address, if any.
+    pub fn get_reference(&self, rva: RVA) -> Option<&StackSlot> {
+        self.references.get(&rva).and_then(|&offset| self.get_slot(offset))
+    }
+
+    /// size in bytes of the locals and saved registers.
+    pub fn get_frame_size(&self) -> u64 {
+        self.slots
+            .iter()
+            .filter(|slot| slot.offset < 0)
+            .map(|slot| -slot.offset as u64)
+            .max()
+            .unwrap_or(0)
+    }
+
+    /// number of pointer-sized arguments passed on the stack,
+    /// up to the last one that's accessed.
+    pub fn get_argument_count(&self, pointer_size: u8) -> u32 {
+        let pointer_size = i64::from(pointer_size);
+        self.slots
+            .iter()
+            .filter(|slot| slot.kind == SlotKind::Argument)
+            .map(|slot| ((slot.offset - pointer_size) / pointer_size + 1) as u32)
+            .max()
+            .unwrap_or(0)
+    }
+}
+
+/// the source of the functions discovered as the target of a call instruction,
+/// rather than by an analyzer.
+pub const CALL_TARGET_SOURCE: &str = "call target";
+
+/// FunctionMeta is the place where analysis passes record what they've
+/// inferred about a function.
+///
+/// Fields are `None` until some analyzer fills them in.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct FunctionMeta {
+    pub calling_convention: CallingConvention,
+
+    /// number of arguments passed to the function.
+    pub argument_count: Option<u32>,
+
+    /// size in bytes of the local stack frame.
+    pub frame_size: Option<u64>,
+
+    /// the layout of the stack frame.
+    pub frame: Option<StackFrame>,
+
+    /// true when calls to the function never return, like `ExitProcess`.
+    pub is_noreturn: bool,
+
+    /// the name of the analyzer that discovered the function, if known,
+    /// or `CALL_TARGET_SOURCE` when found as the target of a call.
+    pub source: Option<String>,
+
+    /// the C++ classes whose virtual function tables reference the function,
+    /// sorted. more than one when the function is shared, like `_purecall`.
+    pub classes: Vec<String>,
+
+    /// the MD5 of the function's instructions, with immediates and
+    /// displacements zeroed, see `Workspace::md5_function`.
+    pub md5: Option<String>,
+
+    /// the SHA-256 of the same bytes, see `Workspace::sha256_function`.
+    pub sha256: Option<String>,
+}
+
+/// A summary of a function assembled from the workspace.
+#[derive(Debug, Clone)]
+pub struct Function {
+    /// start RVA of the function.
+    pub addr: RVA,
+
+    /// symbol name of the function, if any.
+    pub name: Option<String>,
+
+    /// RVAs of start addresses of the basic blocks in this function, sorted.
+    pub basic_blocks: Vec<RVA>,
+
+    pub meta: FunctionMeta,
+}
@@ -0,0 +1,167 @@
+//! Compute MD5 and SHA-256 hashes of mapped regions and functions,
+//!  to deduplicate and correlate them across samples.
+//!
+//! Hashes are rendered as lowercase hex strings.
+//!
+//! The `analysis::hashes::ImageHashAnalyzer` records them in the workspace,
+//!  so that they are exported along with the rest of the analysis.
+use failure::Error;
+use md5;
+use sha2::{Digest, Sha256};
+
+use super::{arch::RVA, loader::Section, workspace::Workspace};
+
+fn md5(buf: &[u8]) -> String {
+    format!("{:x}", md5::compute(buf))
+}
+
+fn sha256(buf: &[u8]) -> String {
+    let mut h = Sha256::new();
+    h.input(buf);
+    format!("{:x}", h.result())
+}
+
+impl Workspace {
+    fn read_section(&self, section: &Section) -> Result<Vec<u8>, Error> {
+        self.read_bytes(section.addr, section.size as usize)
+    }
+
+    /// the mapped contents of all sections, in address order.
+    fn read_image(&self) -> Result<Vec<u8>, Error> {
+        let mut sections: Vec<&Section> = self.module.sections.iter().collect();
+        sections.sort_by(|a, b| a.addr.cmp(&b.addr));
+
+        let mut buf = vec![];
+        for section in sections.into_iter() {
+            buf.extend(self.read_section(section)?);
+        }
+        Ok(buf)
+    }
+
+    /// the instructions of the function, in address order,
+    /// with immediates and displacements zeroed.
+    fn read_function_normalized(&self, rva: RVA) -> Result<Vec<u8>, Error> {
+        let mut bbs = self.get_basic_blocks(rva)?;
+        bbs.sort_by(|a, b| a.addr.cmp(&b.addr));
+
+        let mut ret = vec![];
+        for bb in bbs.iter() {
+            for &insn in bb.insns.iter() {
+                let (buf, mask) = self.read_insn_masked(insn)?;
+                ret.extend(buf.iter().zip(mask.iter()).map(|(&b, &k)| b & k));
+            }
+        }
+        Ok(ret)
+    }
+
+    /// Hash the mapped contents of the given section.
+    pub fn md5_section(&self, section: &Section) -> Result<String, Error> {
+        Ok(md5(&self.read_section(section)?))
+    }
+
+    /// Hash the mapped contents of the given section with SHA-256.
+    pub fn sha256_section(&self, section: &Section) -> Result<String, Error> {
+        Ok(sha256(&self.read_section(section)?))
+    }
+
+    /// Hash the mapped contents of all sections, in address order.
+    ///
+    /// This differs from the hash of the file, because it reflects the image
+    /// as loaded, with sections placed at their virtual addresses.
+    ///
+    /// ```
+    /// use lancelot::test;
+    ///
+    /// let ws = test::get_shellcode32_workspace(b"\xEB\xFE");
+    /// let section = &ws.module.sections[0];
+    /// assert_eq!(ws.md5_image().unwrap(), ws.md5_section(section).unwrap());
+    /// assert_eq!(ws.sha256_image().unwrap(), ws.sha256_section(section).unwrap());
+    /// assert_eq!(ws.sha256_image().unwrap().len(), 64);
+    /// ```
+    pub fn md5_image(&self) -> Result<String, Error> {
+        Ok(md5(&self.read_image()?))
+    }
+
+    /// Hash the mapped contents of all sections with SHA-256, like `md5_image`.
+    pub fn sha256_image(&self) -> Result<String, Error> {
+        Ok(sha256(&self.read_image()?))
+    }
+
+    /// Read the bytes of the instruction at the given address,
+    ///  along with a mask that is `0x00` for the bytes of immediates and
+    ///  displacements, and `0xFF` otherwise.
+    ///
+    /// The masked bytes typically depend on where the code or its data is
+    /// located, so they are ignored when comparing code across samples.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    ///
+    /// // 0: B8 01 00 00 00  MOV EAX, 1
+    /// let ws = test::get_shellcode32_workspace(b"\xB8\x01\x00\x00\x00");
+    /// let (buf, mask) = ws.read_insn_masked(RVA(0x0)).unwrap();
+    /// assert_eq!(buf, b"\xB8\x01\x00\x00\x00");
+    /// assert_eq!(mask, b"\xFF\x00\x00\x00\x00");
+    /// ```
+    ///
+    /// Errors: same as `read_insn`.
+    pub fn read_insn_masked(&self, rva: RVA) -> Result<(Vec<u8>, Vec<u8>), Error> {
+        let insn = self.read_insn(rva)?;
+        let buf = self.read_bytes(rva, insn.length as usize)?;
+        let mut mask = vec![0xFFu8; buf.len()];
+
+        let disp = &insn.raw.disp;
+        let wildcards = std::iter::once((disp.offset, disp.size))
+            .chain(insn.raw.imm.iter().map(|imm| (imm.offset, imm.size)))
+            .filter(|&(_, size)| size > 0);
+        for (offset, size) in wildcards {
+            // sizes are in bits.
+            let start = offset as usize;
+            let end = std::cmp::min(start + size as usize / 8, mask.len());
+            for m in mask[start..end].iter_mut() {
+                *m = 0x00;
+            }
+        }
+
+        Ok((buf, mask))
+    }
+
+    /// Hash the instructions of the function that starts at the given address,
+    ///  with immediates and displacements zeroed, so that the hash does not
+    ///  depend on where the function or its data is located.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    ///
+    /// // 0: B8 01 00 00 00  MOV EAX, 1
+    /// // 5: C3              RETN
+    /// let mut ws1 = test::get_shellcode32_workspace(b"\xB8\x01\x00\x00\x00\xC3");
+    /// ws1.make_function(RVA(0x0)).unwrap();
+    /// ws1.analyze().unwrap();
+    ///
+    /// // 0: B8 02 00 00 00  MOV EAX, 2
+    /// // 5: C3              RETN
+    /// let mut ws2 = test::get_shellcode32_workspace(b"\xB8\x02\x00\x00\x00\xC3");
+    /// ws2.make_function(RVA(0x0)).unwrap();
+    /// ws2.analyze().unwrap();
+    ///
+    /// assert_eq!(ws1.md5_function(RVA(0x0)).unwrap(), ws2.md5_function(RVA(0x0)).unwrap());
+    /// assert_eq!(ws1.sha256_function(RVA(0x0)).unwrap(), ws2.sha256_function(RVA(0x0)).unwrap());
+    /// assert_ne!(ws1.md5_image().unwrap(), ws2.md5_image().unwrap());
+    /// ```
+    ///
+    /// Errors: same as `get_basic_blocks` and `read_insn`.
+    pub fn md5_function(&self, rva: RVA) -> Result<String, Error> {
+        Ok(md5(&self.read_function_normalized(rva)?))
+    }
+
+    /// Hash the normalized instructions of the function with SHA-256,
+    ///  like `md5_function`.
+    ///
+    /// Errors: same as `get_basic_blocks` and `read_insn`.
+    pub fn sha256_function(&self, rva: RVA) -> Result<String, Error> {
+        Ok(sha256(&self.read_function_normalized(rva)?))
+    }
+}
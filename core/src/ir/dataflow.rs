@@ -0,0 +1,466 @@
+//! Compute reaching definitions and def-use chains of the registers and
+//!  stack slots of a function, for analyses like argument inference and dead
+//!  code detection.
+//!
+//! Stack slots are identified by their offset from the stack pointer on entry
+//!  to the function, like `stack[-0x8]` for a local or `stack[+0x4]` for the
+//!  first argument of a 32-bit function. The offsets of the stack and frame
+//!  pointers are tracked through pushes, pops, and constant adjustments.
+//!
+//! This is a simple model of memory:
+//!
+//!   - slots are matched by exact offset, so overlapping accesses, like a DWORD
+//!     store and a BYTE load within it, don't reach each other,
+//!   - stores through other pointers don't define any slot, and
+//!   - calls don't define any slot.
+use std::{
+    collections::{BTreeMap, BTreeSet, VecDeque},
+    fmt,
+};
+
+use failure::Error;
+
+use super::{
+    super::{arch::RVA, util::format_constant, workspace::Workspace},
+    lift, BinaryOp, Expr, IrFunction, Stmt, Value,
+};
+
+/// the position of a statement in a lifted function.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, PartialOrd, Ord)]
+pub struct Site {
+    /// the address of the block.
+    pub block: RVA,
+    /// the index of the statement within the block.
+    pub index: usize,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, Hash, PartialOrd, Ord)]
+pub enum Location {
+    /// a register or temporary, by name.
+    Var(String),
+    /// a stack slot, by offset from the stack pointer on entry.
+    Stack(i64),
+}
+
+impl fmt::Display for Location {
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        match self {
+            Location::Var(name) => write!(f, "{}", name),
+            Location::Stack(offset) if *offset < 0 => write!(f, "stack[{}]", format_constant(*offset)),
+            Location::Stack(offset) => write!(f, "stack[+{}]", format_constant(*offset)),
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, PartialOrd, Ord)]
+pub enum Definition {
+    /// the value on entry to the function.
+    Entry,
+    At(Site),
+}
+
+/// the known offsets of variables from the stack pointer on entry.
+pub type Offsets = BTreeMap<String, i64>;
+
+/// the definitions of each location that may reach a statement.
+type Reaching = BTreeMap<Location, BTreeSet<Definition>>;
+
+/// update the known stack offsets after the given statement.
+fn transfer_offsets(offsets: &mut Offsets, stmt: &Stmt) {
+    let dst = match stmt {
+        Stmt::Assign { dst, .. } | Stmt::Phi { dst, .. } => dst,
+        _ => return,
+    };
+
+    let get = |v: &Value| v.as_var().and_then(|var| offsets.get(&var.name)).cloned();
+    let offset = match stmt {
+        Stmt::Assign {
+            expr: Expr::Value(v), ..
+        } => get(v),
+        Stmt::Assign {
+            expr:
+                Expr::Binary {
+                    op: BinaryOp::Add,
+                    left,
+                    right: Value::Const(c),
+                },
+            ..
+        } => get(left).map(|offset| offset + c),
+        Stmt::Assign {
+            expr:
+                Expr::Binary {
+                    op: BinaryOp::Sub,
+                    left,
+                    right: Value::Const(c),
+                },
+            ..
+        } => get(left).map(|offset| offset - c),
+        _ => None,
+    };
+
+    match offset {
+        Some(offset) => offsets.insert(dst.name.clone(), offset),
+        None => offsets.remove(&dst.name),
+    };
+}
+
+/// the stack offset of the address accessed by the given statement, if any.
+fn get_stack_address(offsets: &Offsets, stmt: &Stmt) -> Option<i64> {
+    let address = match stmt {
+        Stmt::Assign {
+            expr: Expr::Load { address, .. },
+            ..
+        }
+        | Stmt::Store { address, .. } => address,
+        _ => return None,
+    };
+    address.as_var().and_then(|var| offsets.get(&var.name)).cloned()
+}
+
+/// merge the states flowing into a block from its processed predecessors.
+fn meet<K: Ord + Clone, V: Clone>(
+    states: &BTreeMap<RVA, BTreeMap<K, V>>,
+    preds: &[RVA],
+    merge: impl Fn(&V, &V) -> Option<V>,
+) -> Option<BTreeMap<K, V>> {
+    let mut incoming = preds.iter().filter_map(|pred| states.get(pred));
+    let first = incoming.next()?.clone();
+    Some(incoming.fold(first, |acc, state| {
+        acc.into_iter()
+            .filter_map(|(k, v)| match state.get(&k) {
+                Some(other) => merge(&v, other).map(|v| (k, v)),
+                None => None,
+            })
+            .collect()
+    }))
+}
+
+/// Compute the known offsets of the variables from the stack pointer on entry,
+/// like `ebp: -0x4`, before each statement of the given lifted function.
+pub fn get_stack_offsets(f: &IrFunction, stack_pointer: &str) -> BTreeMap<Site, Offsets> {
+    // the offsets at the end of each block.
+    let mut states: BTreeMap<RVA, Offsets> = BTreeMap::new();
+    let mut queue: VecDeque<RVA> = VecDeque::new();
+    queue.push_back(f.addr);
+
+    let get_entry_state = |states: &BTreeMap<RVA, Offsets>, addr: RVA| {
+        let incoming = meet(states, &f.blocks[&addr].predecessors, |a, b| {
+            if a == b {
+                Some(*a)
+            } else {
+                None
+            }
+        });
+        if addr != f.addr {
+            return incoming.unwrap_or_default();
+        }
+
+        // the entry may also be the target of a back edge,
+        // in which case only the offsets that agree with it are known.
+        let mut state: Offsets = BTreeMap::new();
+        if incoming.map_or(true, |incoming| incoming.get(stack_pointer) == Some(&0)) {
+            state.insert(stack_pointer.to_string(), 0);
+        }
+        state
+    };
+
+    while let Some(addr) = queue.pop_front() {
+        let mut state = get_entry_state(&states, addr);
+        for (_, stmt) in f.blocks[&addr].stmts.iter() {
+            transfer_offsets(&mut state, stmt);
+        }
+
+        if states.get(&addr) != Some(&state) {
+            states.insert(addr, state);
+            for succ in f.blocks[&addr].successors.iter() {
+                if f.blocks.contains_key(succ) {
+                    queue.push_back(*succ);
+                }
+            }
+        }
+    }
+
+    let mut offsets = BTreeMap::new();
+    for &addr in states.keys() {
+        let mut state = get_entry_state(&states, addr);
+        for (index, (_, stmt)) in f.blocks[&addr].stmts.iter().enumerate() {
+            offsets.insert(Site { block: addr, index }, state.clone());
+            transfer_offsets(&mut state, stmt);
+        }
+    }
+    offsets
+}
+
+/// compute the stack offset of each load and store of the stack.
+fn get_stack_addresses(f: &IrFunction, stack_pointer: &str) -> BTreeMap<Site, i64> {
+    get_stack_offsets(f, stack_pointer)
+        .into_iter()
+        .filter_map(|(site, offsets)| {
+            let (_, stmt) = &f.blocks[&site.block].stmts[site.index];
+            get_stack_address(&offsets, stmt).map(|offset| (site, offset))
+        })
+        .collect()
+}
+
+pub struct DefUse {
+    pub function: IrFunction,
+    /// the definitions of each location that reach each use.
+    reaching:     BTreeMap<(Site, Location), BTreeSet<Definition>>,
+    /// the location defined at each site.
+    defs:         BTreeMap<Site, Location>,
+}
+
+impl DefUse {
+    /// Compute the reaching definitions of the given lifted function,
+    /// which must not be in SSA form.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::ir::{lift, dataflow::{DefUse, Definition, Location, Site}};
+    ///
+    /// // 0: 41     INC ECX
+    /// // 1: 75 FD  JNZ 0x0
+    /// // 3: C3     RETN
+    /// let mut ws = test::get_shellcode32_workspace(b"\x41\x75\xFD\xC3");
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// let f = lift::lift_function(&ws, RVA(0x0)).unwrap();
+    /// let du = DefUse::new(f, "esp");
+    /// let site = |index| Site { block: RVA(0x0), index };
+    ///
+    /// // the loop is at the entry, so the increment reaches the top of the block.
+    /// assert_eq!(du.get_stmt(site(1)).unwrap().to_string(), "ecx = t0");
+    /// assert_eq!(
+    ///     du.get_reaching_definitions(site(0), &Location::Var("ecx".to_string())),
+    ///     vec![Definition::Entry, Definition::At(site(1))]
+    /// );
+    /// assert!(!du.get_dead_definitions().contains(&site(1)));
+    /// ```
+    pub fn new(function: IrFunction, stack_pointer: &str) -> DefUse {
+        let addresses = get_stack_addresses(&function, stack_pointer);
+
+        // the locations read and written by each statement.
+        let mut uses: BTreeMap<Site, Vec<Location>> = BTreeMap::new();
+        let mut defs: BTreeMap<Site, Location> = BTreeMap::new();
+        for (&addr, block) in function.blocks.iter() {
+            for (index, (_, stmt)) in block.stmts.iter().enumerate() {
+                let site = Site { block: addr, index };
+                let mut locations: Vec<Location> = stmt
+                    .get_uses()
+                    .into_iter()
+                    .map(|var| Location::Var(var.name.clone()))
+                    .collect();
+
+                match (stmt, addresses.get(&site)) {
+                    (Stmt::Store { .. }, Some(&offset)) => {
+                        defs.insert(site, Location::Stack(offset));
+                    }
+                    (Stmt::Assign { .. }, Some(&offset)) => locations.push(Location::Stack(offset)),
+                    _ => {}
+                }
+                if let Some(var) = stmt.get_def() {
+                    defs.insert(site, Location::Var(var.name.clone()));
+                }
+
+                uses.insert(site, locations);
+            }
+        }
+
+        // a definition kills the others of the same location.
+        let apply = |state: &mut Reaching, site: Site| {
+            if let Some(location) = defs.get(&site) {
+                let mut definitions = BTreeSet::new();
+                definitions.insert(Definition::At(site));
+                state.insert(location.clone(), definitions);
+            }
+        };
+
+        // every location is defined on entry.
+        let mut entry: Reaching = BTreeMap::new();
+        for location in uses.values().flatten().chain(defs.values()) {
+            entry
+                .entry(location.clone())
+                .or_insert_with(BTreeSet::new)
+                .insert(Definition::Entry);
+        }
+
+        // the reaching definitions at the end of each block.
+        let mut states: BTreeMap<RVA, Reaching> = BTreeMap::new();
+        let get_entry_state = |states: &BTreeMap<RVA, Reaching>, addr: RVA| {
+            let mut state = meet_union(states, &function.blocks[&addr].predecessors);
+            // the entry may also be the target of a back edge.
+            if addr == function.addr {
+                for (location, definitions) in entry.iter() {
+                    state
+                        .entry(location.clone())
+                        .or_insert_with(BTreeSet::new)
+                        .extend(definitions.iter().cloned());
+                }
+            }
+            state
+        };
+
+        let mut queue: VecDeque<RVA> = VecDeque::new();
+        queue.push_back(function.addr);
+        while let Some(addr) = queue.pop_front() {
+            let mut state = get_entry_state(&states, addr);
+            for index in 0..function.blocks[&addr].stmts.len() {
+                apply(&mut state, Site { block: addr, index });
+            }
+
+            if states.get(&addr) != Some(&state) {
+                states.insert(addr, state);
+                for succ in function.blocks[&addr].successors.iter() {
+                    if function.blocks.contains_key(succ) {
+                        queue.push_back(*succ);
+                    }
+                }
+            }
+        }
+
+        let mut reaching = BTreeMap::new();
+        for &addr in states.keys() {
+            let mut state = get_entry_state(&states, addr);
+            for index in 0..function.blocks[&addr].stmts.len() {
+                let site = Site { block: addr, index };
+                for location in uses[&site].iter() {
+                    let definitions = state.get(location).cloned().unwrap_or_default();
+                    reaching.insert((site, location.clone()), definitions);
+                }
+                apply(&mut state, site);
+            }
+        }
+
+        DefUse {
+            function,
+            reaching,
+            defs,
+        }
+    }
+
+    /// the statement at the given site.
+    pub fn get_stmt(&self, site: Site) -> Option<&Stmt> {
+        self.function
+            .blocks
+            .get(&site.block)
+            .and_then(|block| block.stmts.get(site.index))
+            .map(|(_, stmt)| stmt)
+    }
+
+    /// the location defined at the given site, if any.
+    pub fn get_def(&self, site: Site) -> Option<&Location> {
+        self.defs.get(&site)
+    }
+
+    /// the definitions of the given location that reach its use at the given
+    /// site (the use-def chain), or none if it's not used there.
+    pub fn get_reaching_definitions(&self, site: Site, location: &Location) -> Vec<Definition> {
+        self.reaching
+            .get(&(site, location.clone()))
+            .map(|definitions| definitions.iter().cloned().collect())
+            .unwrap_or_default()
+    }
+
+    /// the sites that use the definition at the given site (the def-use chain).
+    pub fn get_uses(&self, site: Site) -> Vec<Site> {
+        self.reaching
+            .iter()
+            .filter(|(_, definitions)| definitions.contains(&Definition::At(site)))
+            .map(|((use_site, _), _)| *use_site)
+            .collect::<BTreeSet<Site>>()
+            .into_iter()
+            .collect()
+    }
+
+    /// the locations that may be read before they're defined,
+    /// such as arguments and callee-saved registers.
+    pub fn get_live_in(&self) -> BTreeSet<Location> {
+        self.reaching
+            .iter()
+            .filter(|(_, definitions)| definitions.contains(&Definition::Entry))
+            .map(|((_, location), _)| location.clone())
+            .collect()
+    }
+
+    /// the sites of the definitions that are never used,
+    /// including the flags of most arithmetic.
+    pub fn get_dead_definitions(&self) -> Vec<Site> {
+        let used: BTreeSet<Site> = self
+            .reaching
+            .values()
+            .flatten()
+            .filter_map(|definition| match definition {
+                Definition::At(site) => Some(*site),
+                Definition::Entry => None,
+            })
+            .collect();
+
+        self.defs.keys().filter(|site| !used.contains(site)).cloned().collect()
+    }
+}
+
+/// merge the reaching definitions flowing into a block from its processed
+/// predecessors.
+fn meet_union(states: &BTreeMap<RVA, Reaching>, preds: &[RVA]) -> Reaching {
+    let mut state: Reaching = BTreeMap::new();
+    for pred in preds.iter().filter_map(|pred| states.get(pred)) {
+        for (location, definitions) in pred.iter() {
+            state
+                .entry(location.clone())
+                .or_insert_with(BTreeSet::new)
+                .extend(definitions.iter().cloned());
+        }
+    }
+    state
+}
+
+impl Workspace {
+    /// Lift the function that starts at the given address,
+    ///  and compute the def-use chains of its registers and stack slots.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::ir::dataflow::{Definition, Location, Site};
+    ///
+    /// // 0: 55        PUSH EBP
+    /// // 1: 8B EC     MOV EBP, ESP
+    /// // 3: 8B 45 08  MOV EAX, [EBP+0x8]
+    /// // 6: 89 45 FC  MOV [EBP-0x4], EAX
+    /// // 9: 8B 4D FC  MOV ECX, [EBP-0x4]
+    /// // C: 5D        POP EBP
+    /// // D: C3        RETN
+    /// let mut ws = test::get_shellcode32_workspace(
+    ///     b"\x55\x8B\xEC\x8B\x45\x08\x89\x45\xFC\x8B\x4D\xFC\x5D\xC3",
+    /// );
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// let du = ws.get_def_use(RVA(0x0)).unwrap();
+    /// let site = |index| Site { block: RVA(0x0), index };
+    ///
+    /// // `[t2]:4 = eax` stores to the local, and `t4 = [t3]:4` loads it.
+    /// assert_eq!(du.get_stmt(site(7)).unwrap().to_string(), "[t2]:4 = eax");
+    /// assert_eq!(du.get_def(site(7)), Some(&Location::Stack(-0x8)));
+    /// assert_eq!(du.get_uses(site(7)), vec![site(9)]);
+    /// assert_eq!(
+    ///     du.get_reaching_definitions(site(9), &Location::Stack(-0x8)),
+    ///     vec![Definition::At(site(7))]
+    /// );
+    ///
+    /// // the argument, and the saved registers.
+    /// let live: Vec<String> = du.get_live_in().iter().map(|l| l.to_string()).collect();
+    /// assert_eq!(live, vec!["ebp", "esp", "stack[+0x4]"]);
+    ///
+    /// // `ecx = t4` is never used.
+    /// assert!(du.get_dead_definitions().contains(&site(10)));
+    /// ```
+    ///
+    /// Errors: same as `lift_function`.
+    pub fn get_def_use(&self, rva: RVA) -> Result<DefUse, Error> {
+        let function = lift::lift_function(self, rva)?;
+        Ok(DefUse::new(function, lift::get_stack_pointer(self.loader.get_arch())))
+    }
+}
@@ -0,0 +1,453 @@
+//! Lift the x86 instructions of a function into the IR.
+use std::collections::BTreeMap;
+
+use failure::Error;
+use zydis;
+
+use super::{
+    super::{
+        analysis::pe::thunks,
+        arch::{Arch, RVA},
+        types::Prototype,
+        workspace::Workspace,
+        x86::{get_operands, is_conditional_jump, mnemonic_name, register_name},
+    },
+    BinaryOp, Expr, IrBlock, IrFunction, Stmt, Value, Var,
+};
+
+/// the variable assigned by comparisons and arithmetic.
+pub const FLAGS: &str = "flags";
+
+/// the name of the stack pointer register of the given architecture.
+pub fn get_stack_pointer(arch: Arch) -> &'static str {
+    match arch {
+        Arch::X32 => "esp",
+        Arch::X64 => "rsp",
+    }
+}
+
+/// the binary operator of an instruction that updates its first operand,
+/// like `Add` for `ADD`.
+pub fn get_operator(mnemonic: zydis::Mnemonic) -> Option<BinaryOp> {
+    match mnemonic {
+        zydis::Mnemonic::ADD => Some(BinaryOp::Add),
+        zydis::Mnemonic::SUB => Some(BinaryOp::Sub),
+        zydis::Mnemonic::IMUL => Some(BinaryOp::Mul),
+        zydis::Mnemonic::AND => Some(BinaryOp::And),
+        zydis::Mnemonic::OR => Some(BinaryOp::Or),
+        zydis::Mnemonic::XOR => Some(BinaryOp::Xor),
+        zydis::Mnemonic::SHL => Some(BinaryOp::Shl),
+        zydis::Mnemonic::SHR => Some(BinaryOp::Shr),
+        zydis::Mnemonic::SAR => Some(BinaryOp::Sar),
+        _ => None,
+    }
+}
+
+struct Lifter<'a> {
+    ws:            &'a Workspace,
+    /// the address of the instruction being lifted.
+    rva:           RVA,
+    stmts:         Vec<(RVA, Stmt)>,
+    /// the number of temporaries allocated so far, in the whole function.
+    temps:         usize,
+    stack_pointer: &'static str,
+    frame_pointer: &'static str,
+    return_value:  &'static str,
+    pointer_size:  u8,
+}
+
+impl<'a> Lifter<'a> {
+    fn new(ws: &'a Workspace) -> Lifter<'a> {
+        let arch = ws.loader.get_arch();
+        let (frame_pointer, return_value) = match arch {
+            Arch::X32 => ("ebp", "eax"),
+            Arch::X64 => ("rbp", "rax"),
+        };
+
+        Lifter {
+            ws,
+            rva: RVA(0x0),
+            stmts: vec![],
+            temps: 0,
+            stack_pointer: get_stack_pointer(arch),
+            frame_pointer,
+            return_value,
+            pointer_size: arch.get_pointer_size(),
+        }
+    }
+
+    fn emit(&mut self, stmt: Stmt) {
+        self.stmts.push((self.rva, stmt));
+    }
+
+    fn assign(&mut self, dst: Var, expr: Expr) {
+        self.emit(Stmt::Assign { dst, expr });
+    }
+
+    /// assign the given expression to a new temporary.
+    fn temp(&mut self, expr: Expr) -> Value {
+        let var = Var::new(&format!("t{}", self.temps));
+        self.temps += 1;
+        self.assign(var.clone(), expr);
+        Value::Var(var)
+    }
+
+    fn register(&self, register: zydis::Register) -> Var {
+        Var::new(&register_name(register).unwrap_or_else(|| "?".to_string()))
+    }
+
+    /// compute the address of the given memory operand.
+    fn address(&mut self, insn: &zydis::DecodedInstruction, op: &zydis::DecodedOperand) -> Value {
+        if op.mem.base == zydis::Register::RIP {
+            let target = self.rva + RVA::from(op.mem.disp.displacement) + insn.length;
+            return match self.ws.va(target) {
+                Some(va) => {
+                    let va: u64 = va.into();
+                    Value::Const(va as i64)
+                }
+                None => Value::Const(target.0),
+            };
+        }
+
+        let mut terms: Vec<Value> = vec![];
+        if let zydis::Register::FS | zydis::Register::GS = op.mem.segment {
+            terms.push(Value::Var(self.register(op.mem.segment)));
+        }
+        if op.mem.base != zydis::Register::NONE {
+            terms.push(Value::Var(self.register(op.mem.base)));
+        }
+        if op.mem.index != zydis::Register::NONE {
+            let index = Value::Var(self.register(op.mem.index));
+            if op.mem.scale > 1 {
+                let scaled = self.temp(Expr::Binary {
+                    op:    BinaryOp::Mul,
+                    left:  index,
+                    right: Value::Const(i64::from(op.mem.scale)),
+                });
+                terms.push(scaled);
+            } else {
+                terms.push(index);
+            }
+        }
+        if op.mem.disp.displacement != 0 || terms.is_empty() {
+            terms.push(Value::Const(op.mem.disp.displacement));
+        }
+
+        let mut terms = terms.into_iter();
+        // there's always at least one term.
+        let first = terms.next().unwrap();
+        terms.fold(first, |left, right| {
+            // like `ebp - 0x4`, rather than `ebp + -0x4`.
+            let (op, right) = match right {
+                Value::Const(v) if v < 0 => (BinaryOp::Sub, Value::Const(v.wrapping_neg())),
+                right => (BinaryOp::Add, right),
+            };
+            self.temp(Expr::Binary { op, left, right })
+        })
+    }
+
+    /// read the value of the given operand, loading it from memory if
+    /// necessary.
+    fn read(&mut self, insn: &zydis::DecodedInstruction, op: &zydis::DecodedOperand) -> Value {
+        match op.ty {
+            zydis::OperandType::REGISTER => Value::Var(self.register(op.reg)),
+            zydis::OperandType::IMMEDIATE if op.imm.is_relative => {
+                let target = self.rva + RVA::from(op.imm.value as i64) + insn.length;
+                Value::Const(target.0)
+            }
+            zydis::OperandType::IMMEDIATE => Value::Const(op.imm.value as i64),
+            zydis::OperandType::MEMORY => {
+                let address = self.address(insn, op);
+                self.temp(Expr::Load {
+                    size: (op.size / 8) as u8,
+                    address,
+                })
+            }
+            _ => Value::Const(0),
+        }
+    }
+
+    /// write the given value to the given register or memory operand.
+    fn write(&mut self, insn: &zydis::DecodedInstruction, op: &zydis::DecodedOperand, value: Value) {
+        match op.ty {
+            zydis::OperandType::REGISTER => {
+                let dst = self.register(op.reg);
+                self.assign(dst, Expr::Value(value));
+            }
+            zydis::OperandType::MEMORY => {
+                let address = self.address(insn, op);
+                self.emit(Stmt::Store {
+                    size: (op.size / 8) as u8,
+                    address,
+                    value,
+                });
+            }
+            _ => {}
+        }
+    }
+
+    /// push or pop a pointer sized value from the stack, with `Sub` or `Add`.
+    fn adjust_stack(&mut self, op: BinaryOp) {
+        let sp = Var::new(self.stack_pointer);
+        self.assign(
+            sp.clone(),
+            Expr::Binary {
+                op,
+                left: Value::Var(sp),
+                right: Value::Const(i64::from(self.pointer_size)),
+            },
+        );
+    }
+
+    fn lift_insn(&mut self, rva: RVA) -> Result<(), Error> {
+        self.rva = rva;
+        let insn = self.ws.read_insn(rva)?;
+        let ops = get_operands(&insn);
+        let sp = Value::Var(Var::new(self.stack_pointer));
+
+        match insn.mnemonic {
+            zydis::Mnemonic::NOP => {}
+            zydis::Mnemonic::MOV | zydis::Mnemonic::MOVZX | zydis::Mnemonic::MOVSX | zydis::Mnemonic::MOVSXD => {
+                let value = self.read(&insn, ops[1]);
+                self.write(&insn, ops[0], value);
+            }
+            zydis::Mnemonic::LEA => {
+                let address = self.address(&insn, ops[1]);
+                self.write(&insn, ops[0], address);
+            }
+            zydis::Mnemonic::XOR | zydis::Mnemonic::SUB
+                if ops[0].ty == zydis::OperandType::REGISTER
+                    && ops[1].ty == zydis::OperandType::REGISTER
+                    && ops[0].reg == ops[1].reg =>
+            {
+                self.write(&insn, ops[0], Value::Const(0));
+                self.assign(Var::new(FLAGS), Expr::Value(Value::Const(0)));
+            }
+            zydis::Mnemonic::CMP | zydis::Mnemonic::TEST => {
+                let left = self.read(&insn, ops[0]);
+                let right = self.read(&insn, ops[1]);
+                let op = if insn.mnemonic == zydis::Mnemonic::CMP {
+                    BinaryOp::Sub
+                } else {
+                    BinaryOp::And
+                };
+                self.assign(Var::new(FLAGS), Expr::Binary { op, left, right });
+            }
+            zydis::Mnemonic::INC | zydis::Mnemonic::DEC => {
+                let left = self.read(&insn, ops[0]);
+                let op = if insn.mnemonic == zydis::Mnemonic::INC {
+                    BinaryOp::Add
+                } else {
+                    BinaryOp::Sub
+                };
+                let result = self.temp(Expr::Binary {
+                    op,
+                    left,
+                    right: Value::Const(1),
+                });
+                self.write(&insn, ops[0], result.clone());
+                self.assign(Var::new(FLAGS), Expr::Value(result));
+            }
+            // the three operand form, like `IMUL EAX, ECX, 0x10`.
+            zydis::Mnemonic::IMUL if ops.len() == 3 => {
+                let left = self.read(&insn, ops[1]);
+                let right = self.read(&insn, ops[2]);
+                let result = self.temp(Expr::Binary {
+                    op: BinaryOp::Mul,
+                    left,
+                    right,
+                });
+                self.write(&insn, ops[0], result.clone());
+                self.assign(Var::new(FLAGS), Expr::Value(result));
+            }
+            mnemonic if ops.len() == 2 && get_operator(mnemonic).is_some() => {
+                let left = self.read(&insn, ops[0]);
+                let right = self.read(&insn, ops[1]);
+                let result = self.temp(Expr::Binary {
+                    op: get_operator(mnemonic).unwrap(),
+                    left,
+                    right,
+                });
+                self.write(&insn, ops[0], result.clone());
+                self.assign(Var::new(FLAGS), Expr::Value(result));
+            }
+            zydis::Mnemonic::PUSH => {
+                let value = self.read(&insn, ops[0]);
+                self.adjust_stack(BinaryOp::Sub);
+                self.emit(Stmt::Store {
+                    size: self.pointer_size,
+                    address: sp,
+                    value,
+                });
+            }
+            zydis::Mnemonic::POP => {
+                let value = self.temp(Expr::Load {
+                    size:    self.pointer_size,
+                    address: sp,
+                });
+                self.adjust_stack(BinaryOp::Add);
+                self.write(&insn, ops[0], value);
+            }
+            zydis::Mnemonic::LEAVE => {
+                let bp = Var::new(self.frame_pointer);
+                self.assign(Var::new(self.stack_pointer), Expr::Value(Value::Var(bp.clone())));
+                let value = self.temp(Expr::Load {
+                    size:    self.pointer_size,
+                    address: sp,
+                });
+                self.assign(bp, Expr::Value(value));
+                self.adjust_stack(BinaryOp::Add);
+            }
+            zydis::Mnemonic::CALL => {
+                let target = self.read(&insn, ops[0]);
+                self.emit(Stmt::Call(target.clone()));
+                // the callee defines the return value.
+                self.assign(
+                    Var::new(self.return_value),
+                    Expr::Unknown {
+                        mnemonic: "call".to_string(),
+                        args:     vec![target],
+                    },
+                );
+
+                // an import like a `stdcall` API pops its arguments from the stack.
+                let cleanup = match self.ws.loader.get_arch() {
+                    Arch::X32 => {
+                        thunks::get_callee_prototype(self.ws, self.rva).map_or(0, Prototype::get_stack_cleanup)
+                    }
+                    // the caller cleans up the stack on x64.
+                    Arch::X64 => 0,
+                };
+                if cleanup > 0 {
+                    let sp = Var::new(self.stack_pointer);
+                    self.assign(
+                        sp.clone(),
+                        Expr::Binary {
+                            op:    BinaryOp::Add,
+                            left:  Value::Var(sp),
+                            right: Value::Const(cleanup as i64),
+                        },
+                    );
+                }
+            }
+            zydis::Mnemonic::JMP => {
+                let target = self.read(&insn, ops[0]);
+                self.emit(Stmt::Jump(target));
+            }
+            zydis::Mnemonic::RET => self.emit(Stmt::Return),
+            mnemonic if is_conditional_jump(mnemonic) => {
+                let target = self.read(&insn, ops[0]);
+                let condition = self.temp(Expr::Condition {
+                    code:  mnemonic_name(mnemonic).trim_start_matches('j').to_string(),
+                    flags: Value::Var(Var::new(FLAGS)),
+                });
+                self.emit(Stmt::Branch { condition, target });
+            }
+            mnemonic => match ops.first() {
+                Some(&dst) if dst.ty == zydis::OperandType::REGISTER || dst.ty == zydis::OperandType::MEMORY => {
+                    let args = ops.iter().map(|op| self.read(&insn, op)).collect();
+                    let value = self.temp(Expr::Unknown {
+                        mnemonic: mnemonic_name(mnemonic),
+                        args,
+                    });
+                    self.write(&insn, dst, value);
+                }
+                _ => self.emit(Stmt::Unknown(mnemonic_name(mnemonic))),
+            },
+        }
+
+        Ok(())
+    }
+}
+
+/// Lift the function that starts at the given address into the IR.
+///
+/// ```
+/// use lancelot::test;
+/// use lancelot::arch::RVA;
+/// use lancelot::ir::lift;
+///
+/// // 0: 55        PUSH EBP
+/// // 1: 8B EC     MOV EBP, ESP
+/// // 3: 8B 45 08  MOV EAX, [EBP+0x8]
+/// // 6: 85 C0     TEST EAX, EAX
+/// // 8: 74 01     JZ 0xB
+/// // A: 40        INC EAX
+/// // B: 5D        POP EBP
+/// // C: C3        RETN
+/// let mut ws = test::get_shellcode32_workspace(b"\x55\x8B\xEC\x8B\x45\x08\x85\xC0\x74\x01\x40\x5D\xC3");
+/// ws.make_function(RVA(0x0)).unwrap();
+/// ws.analyze().unwrap();
+///
+/// let f = lift::lift_function(&ws, RVA(0x0)).unwrap();
+/// assert_eq!(f.blocks[&RVA(0xB)].predecessors, vec![RVA(0x0), RVA(0xA)]);
+/// assert_eq!(
+///     f.to_string(),
+///     "0x0:\n    esp = esp - 0x4\n    [esp]:4 = ebp\n    ebp = esp\n    \
+///      t0 = ebp + 0x8\n    t1 = [t0]:4\n    eax = t1\n    \
+///      flags = eax & eax\n    t2 = z(flags)\n    if t2 goto 0xb\n\
+///      0xa:\n    t3 = eax + 0x1\n    eax = t3\n    flags = t3\n\
+///      0xb:\n    t4 = [esp]:4\n    esp = esp + 0x4\n    ebp = t4\n    return\n"
+/// );
+/// ```
+///
+/// Calls to imports with a known prototype pop the arguments that the callee
+///  cleans up, like for a `stdcall` API:
+///
+/// ```
+/// use lancelot::test;
+/// use lancelot::arch::RVA;
+/// use lancelot::ir::lift;
+/// use lancelot::symbol::SymbolSource;
+///
+/// // 0: 6A 00              PUSH 0
+/// // 2: FF 15 10 00 00 00  CALL [0x10]
+/// // 8: C3                 RETN
+/// // 9: ...                padding
+/// // 10: 00 00 00 00       kernel32.dll!CloseHandle
+/// let mut ws = test::get_shellcode32_workspace(
+///     b"\x6A\x00\xFF\x15\x10\x00\x00\x00\xC3\x00\x00\x00\x00\x00\x00\x00\
+///       \x00\x00\x00\x00",
+/// );
+/// ws.make_symbol_from(RVA(0x10), "kernel32.dll!CloseHandle", SymbolSource::Import)
+///     .unwrap();
+/// ws.make_function(RVA(0x0)).unwrap();
+/// ws.analyze().unwrap();
+///
+/// let f = lift::lift_function(&ws, RVA(0x0)).unwrap();
+/// assert_eq!(
+///     f.to_string(),
+///     "0x0:\n    esp = esp - 0x4\n    [esp]:4 = 0x0\n    \
+///      t0 = [0x10]:4\n    call t0\n    eax = unknown(call, t0)\n    esp = esp + 0x4\n    \
+///      return\n"
+/// );
+/// ```
+///
+/// Errors: same as `get_basic_blocks` and `read_insn`.
+pub fn lift_function(ws: &Workspace, rva: RVA) -> Result<IrFunction, Error> {
+    let mut bbs = ws.get_basic_blocks(rva)?;
+    bbs.sort_by(|a, b| a.addr.cmp(&b.addr));
+
+    let mut lifter = Lifter::new(ws);
+    let mut blocks: BTreeMap<RVA, IrBlock> = BTreeMap::new();
+    for bb in bbs.iter() {
+        for &insn in bb.insns.iter() {
+            lifter.lift_insn(insn)?;
+        }
+
+        let mut predecessors = bb.predecessors.clone();
+        predecessors.sort();
+
+        blocks.insert(
+            bb.addr,
+            IrBlock {
+                addr: bb.addr,
+                predecessors,
+                successors: bb.successors.clone(),
+                stmts: lifter.stmts.drain(..).collect(),
+            },
+        );
+    }
+
+    Ok(IrFunction { addr: rva, blocks })
+}
@@ -0,0 +1,309 @@
+//! A simple, architecture-neutral intermediate representation (IR),
+//!  so that data flow analyses don't depend on the details of x86 operands.
+//!
+//! Each instruction is lifted to a few three-address statements over
+//!  variables, like registers and temporaries, and constants:
+//!
+//!   - memory is only accessed by loads and stores, with the address computed
+//!     into a temporary first, like `t0 = ebp + 0x8` and `t1 = [t0]:4`,
+//!   - the flags are a single variable, `flags`, assigned by comparisons and
+//!     arithmetic, and tested by conditions, like `t2 = z(flags)`, and
+//!   - instructions without a translation assign an unknown value to their
+//!     first operand, like `eax = unknown(bswap, eax)`.
+//!
+//! Only explicit operands are lifted, and sub-registers, like `al`, are
+//!  distinct variables from their enclosing register, like `eax`.
+//!
+//! A lifted function can be converted to static single assignment (SSA)
+//!  form, see the `ssa` module.
+use std::{collections::BTreeMap, fmt};
+
+use super::{arch::RVA, util::format_constant};
+
+pub mod dataflow;
+pub mod lift;
+pub mod ssa;
+
+#[derive(Debug, Clone, PartialEq, Eq, Hash, PartialOrd, Ord)]
+pub struct Var {
+    /// the name of the register, like `eax`, or temporary, like `t0`.
+    pub name:    String,
+    /// the SSA version, or `None` before SSA construction.
+    /// version 0 is the value on entry to the function.
+    pub version: Option<u32>,
+}
+
+impl Var {
+    pub fn new(name: &str) -> Var {
+        Var {
+            name:    name.to_string(),
+            version: None,
+        }
+    }
+}
+
+impl fmt::Display for Var {
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        match self.version {
+            Some(version) => write!(f, "{}.{}", self.name, version),
+            None => write!(f, "{}", self.name),
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub enum Value {
+    Var(Var),
+    /// a constant, including addresses:
+    /// branch targets are RVAs, while data addresses are VAs.
+    Const(i64),
+}
+
+impl Value {
+    pub fn as_var(&self) -> Option<&Var> {
+        match self {
+            Value::Var(var) => Some(var),
+            Value::Const(_) => None,
+        }
+    }
+
+    fn as_var_mut(&mut self) -> Option<&mut Var> {
+        match self {
+            Value::Var(var) => Some(var),
+            Value::Const(_) => None,
+        }
+    }
+}
+
+impl fmt::Display for Value {
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        match self {
+            Value::Var(var) => write!(f, "{}", var),
+            Value::Const(v) => write!(f, "{}", format_constant(*v)),
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum BinaryOp {
+    Add,
+    Sub,
+    Mul,
+    And,
+    Or,
+    Xor,
+    Shl,
+    /// logical shift right.
+    Shr,
+    /// arithmetic shift right.
+    Sar,
+}
+
+impl BinaryOp {
+    /// the symbol of the operator, like `+`.
+    pub fn get_symbol(self) -> &'static str {
+        match self {
+            BinaryOp::Add => "+",
+            BinaryOp::Sub => "-",
+            BinaryOp::Mul => "*",
+            BinaryOp::And => "&",
+            BinaryOp::Or => "|",
+            BinaryOp::Xor => "^",
+            BinaryOp::Shl => "<<",
+            BinaryOp::Shr => ">>",
+            BinaryOp::Sar => "s>>",
+        }
+    }
+}
+
+impl fmt::Display for BinaryOp {
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        write!(f, "{}", self.get_symbol())
+    }
+}
+
+/// the right hand side of an assignment.
+#[derive(Debug, Clone, PartialEq)]
+pub enum Expr {
+    Value(Value),
+    Binary {
+        op:    BinaryOp,
+        left:  Value,
+        right: Value,
+    },
+    /// read the given number of bytes from memory.
+    Load {
+        size:    u8,
+        address: Value,
+    },
+    /// a condition code, like `z` or `nle`, computed from the flags.
+    Condition {
+        code:  String,
+        flags: Value,
+    },
+    /// the result of an instruction without a translation,
+    /// computed from its operands.
+    Unknown {
+        mnemonic: String,
+        args:     Vec<Value>,
+    },
+}
+
+impl Expr {
+    fn get_values(&self) -> Vec<&Value> {
+        match self {
+            Expr::Value(v) => vec![v],
+            Expr::Binary { left, right, .. } => vec![left, right],
+            Expr::Load { address, .. } => vec![address],
+            Expr::Condition { flags, .. } => vec![flags],
+            Expr::Unknown { args, .. } => args.iter().collect(),
+        }
+    }
+
+    fn get_values_mut(&mut self) -> Vec<&mut Value> {
+        match self {
+            Expr::Value(v) => vec![v],
+            Expr::Binary { left, right, .. } => vec![left, right],
+            Expr::Load { address, .. } => vec![address],
+            Expr::Condition { flags, .. } => vec![flags],
+            Expr::Unknown { args, .. } => args.iter_mut().collect(),
+        }
+    }
+}
+
+impl fmt::Display for Expr {
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        match self {
+            Expr::Value(v) => write!(f, "{}", v),
+            Expr::Binary { op, left, right } => write!(f, "{} {} {}", left, op, right),
+            Expr::Load { size, address } => write!(f, "[{}]:{}", address, size),
+            Expr::Condition { code, flags } => write!(f, "{}({})", code, flags),
+            Expr::Unknown { mnemonic, args } => {
+                let mut parts = vec![mnemonic.clone()];
+                parts.extend(args.iter().map(|arg| arg.to_string()));
+                write!(f, "unknown({})", parts.join(", "))
+            }
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub enum Stmt {
+    Assign {
+        dst:  Var,
+        expr: Expr,
+    },
+    /// write the given number of bytes to memory.
+    Store {
+        size:    u8,
+        address: Value,
+        value:   Value,
+    },
+    Call(Value),
+    Jump(Value),
+    Branch {
+        condition: Value,
+        target:    Value,
+    },
+    Return,
+    /// select the value of a variable by the predecessor block that flowed
+    /// here. only present in SSA form.
+    Phi {
+        dst:     Var,
+        sources: Vec<(RVA, Var)>,
+    },
+    /// an instruction without a translation or operands, like `int3`.
+    Unknown(String),
+}
+
+impl Stmt {
+    /// the variable assigned by this statement, if any.
+    pub fn get_def(&self) -> Option<&Var> {
+        match self {
+            Stmt::Assign { dst, .. } | Stmt::Phi { dst, .. } => Some(dst),
+            _ => None,
+        }
+    }
+
+    /// the variables read by this statement.
+    pub fn get_uses(&self) -> Vec<&Var> {
+        let values = match self {
+            Stmt::Assign { expr, .. } => expr.get_values(),
+            Stmt::Store { address, value, .. } => vec![address, value],
+            Stmt::Call(target) | Stmt::Jump(target) => vec![target],
+            Stmt::Branch { condition, target } => vec![condition, target],
+            Stmt::Phi { sources, .. } => return sources.iter().map(|(_, var)| var).collect(),
+            Stmt::Return | Stmt::Unknown(_) => vec![],
+        };
+        values.into_iter().filter_map(|v| v.as_var()).collect()
+    }
+
+    fn get_def_mut(&mut self) -> Option<&mut Var> {
+        match self {
+            Stmt::Assign { dst, .. } | Stmt::Phi { dst, .. } => Some(dst),
+            _ => None,
+        }
+    }
+
+    /// the variables read by this statement, excluding the sources of a phi,
+    /// which are read at the end of the predecessor blocks.
+    fn get_uses_mut(&mut self) -> Vec<&mut Var> {
+        let values = match self {
+            Stmt::Assign { expr, .. } => expr.get_values_mut(),
+            Stmt::Store { address, value, .. } => vec![address, value],
+            Stmt::Call(target) | Stmt::Jump(target) => vec![target],
+            Stmt::Branch { condition, target } => vec![condition, target],
+            Stmt::Return | Stmt::Phi { .. } | Stmt::Unknown(_) => vec![],
+        };
+        values.into_iter().filter_map(|v| v.as_var_mut()).collect()
+    }
+}
+
+impl fmt::Display for Stmt {
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        match self {
+            Stmt::Assign { dst, expr } => write!(f, "{} = {}", dst, expr),
+            Stmt::Store { size, address, value } => write!(f, "[{}]:{} = {}", address, size, value),
+            Stmt::Call(target) => write!(f, "call {}", target),
+            Stmt::Jump(target) => write!(f, "goto {}", target),
+            Stmt::Branch { condition, target } => write!(f, "if {} goto {}", condition, target),
+            Stmt::Return => write!(f, "return"),
+            Stmt::Phi { dst, sources } => {
+                let sources: Vec<String> = sources.iter().map(|(_, var)| var.to_string()).collect();
+                write!(f, "{} = phi({})", dst, sources.join(", "))
+            }
+            Stmt::Unknown(mnemonic) => write!(f, "unknown({})", mnemonic),
+        }
+    }
+}
+
+#[derive(Debug, Clone)]
+pub struct IrBlock {
+    pub addr:         RVA,
+    /// start addresses of the blocks in this function that flow here.
+    pub predecessors: Vec<RVA>,
+    /// start addresses of the blocks in this function that flow from here.
+    pub successors:   Vec<RVA>,
+    /// the statements, each with the address of the instruction it was lifted
+    /// from. phis are at the address of the block.
+    pub stmts:        Vec<(RVA, Stmt)>,
+}
+
+#[derive(Debug, Clone)]
+pub struct IrFunction {
+    pub addr:   RVA,
+    pub blocks: BTreeMap<RVA, IrBlock>,
+}
+
+impl fmt::Display for IrFunction {
+    /// render each block under its address, in address order.
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        for block in self.blocks.values() {
+            writeln!(f, "{}:", block.addr)?;
+            for (_, stmt) in block.stmts.iter() {
+                writeln!(f, "    {}", stmt)?;
+            }
+        }
+        Ok(())
+    }
+}
@@ -0,0 +1,313 @@
+//! Convert a lifted function to static single assignment (SSA) form,
+//!  in which each variable is assigned exactly once, like `eax.1`,
+//!  and phis merge the versions that flow into a block.
+//!
+//! Phis are placed at the iterated dominance frontier of the definitions of
+//!  each variable that's live across blocks (semi-pruned SSA), and then the
+//!  variables are versioned by a walk of the dominator tree.
+//!
+//! See: Cytron et al., "Efficiently Computing Static Single Assignment Form
+//!  and the Control Dependence Graph", and Cooper, Harvey, and Kennedy,
+//!  "A Simple, Fast Dominance Algorithm".
+use std::collections::{BTreeMap, BTreeSet, HashMap};
+
+use super::{super::arch::RVA, IrFunction, Stmt, Var};
+
+/// the blocks reachable from the entry of the function, in reverse postorder.
+fn get_reverse_postorder(f: &IrFunction) -> Vec<RVA> {
+    let mut order = vec![];
+    let mut seen: BTreeSet<RVA> = BTreeSet::new();
+    // pairs of block and whether its successors have been visited.
+    let mut stack = vec![(f.addr, false)];
+    while let Some((addr, done)) = stack.pop() {
+        if done {
+            order.push(addr);
+            continue;
+        }
+        if !f.blocks.contains_key(&addr) || !seen.insert(addr) {
+            continue;
+        }
+
+        stack.push((addr, true));
+        for &succ in f.blocks[&addr].successors.iter().rev() {
+            stack.push((succ, false));
+        }
+    }
+
+    order.reverse();
+    order
+}
+
+/// Compute the immediate dominator of each block reachable from the entry.
+/// The entry is its own immediate dominator.
+///
+/// ```
+/// use lancelot::test;
+/// use lancelot::arch::RVA;
+/// use lancelot::ir::{lift, ssa};
+///
+/// // 0: 85 C0           TEST EAX, EAX
+/// // 2: 74 07           JZ 0xB
+/// // 4: B9 01 00 00 00  MOV ECX, 1
+/// // 9: EB 05           JMP 0x10
+/// // B: B9 02 00 00 00  MOV ECX, 2
+/// // 10: 89 C8          MOV EAX, ECX
+/// // 12: C3             RETN
+/// let mut ws = test::get_shellcode32_workspace(
+///     b"\x85\xC0\x74\x07\xB9\x01\x00\x00\x00\xEB\x05\xB9\x02\x00\x00\x00\x89\xC8\xC3",
+/// );
+/// ws.make_function(RVA(0x0)).unwrap();
+/// ws.analyze().unwrap();
+///
+/// let f = lift::lift_function(&ws, RVA(0x0)).unwrap();
+/// let idom = ssa::get_dominators(&f);
+/// assert_eq!(idom[&RVA(0x0)], RVA(0x0));
+/// assert_eq!(idom[&RVA(0xB)], RVA(0x0));
+/// assert_eq!(idom[&RVA(0x10)], RVA(0x0));
+/// ```
+pub fn get_dominators(f: &IrFunction) -> BTreeMap<RVA, RVA> {
+    let order = get_reverse_postorder(f);
+    let index: HashMap<RVA, usize> = order.iter().enumerate().map(|(i, &addr)| (addr, i)).collect();
+
+    let mut idom: BTreeMap<RVA, RVA> = BTreeMap::new();
+    idom.insert(f.addr, f.addr);
+
+    let intersect = |idom: &BTreeMap<RVA, RVA>, mut a: RVA, mut b: RVA| {
+        while a != b {
+            while index[&a] > index[&b] {
+                a = idom[&a];
+            }
+            while index[&b] > index[&a] {
+                b = idom[&b];
+            }
+        }
+        a
+    };
+
+    let mut changed = true;
+    while changed {
+        changed = false;
+        for &addr in order.iter().skip(1) {
+            let mut preds = f.blocks[&addr]
+                .predecessors
+                .iter()
+                .filter(|pred| idom.contains_key(pred))
+                .cloned();
+
+            // in reverse postorder, at least one predecessor has been processed.
+            let first = match preds.next() {
+                Some(first) => first,
+                None => continue,
+            };
+            let new_idom = preds.fold(first, |new_idom, pred| intersect(&idom, pred, new_idom));
+
+            if idom.get(&addr) != Some(&new_idom) {
+                idom.insert(addr, new_idom);
+                changed = true;
+            }
+        }
+    }
+
+    idom
+}
+
+/// Compute the dominance frontier of each block reachable from the entry:
+/// the blocks where its dominance ends, and so where phis are needed.
+pub fn get_dominance_frontiers(f: &IrFunction, idom: &BTreeMap<RVA, RVA>) -> BTreeMap<RVA, BTreeSet<RVA>> {
+    let mut frontiers: BTreeMap<RVA, BTreeSet<RVA>> = idom.keys().map(|&addr| (addr, BTreeSet::new())).collect();
+
+    for &addr in idom.keys() {
+        let preds: Vec<RVA> = f.blocks[&addr]
+            .predecessors
+            .iter()
+            .filter(|pred| idom.contains_key(pred))
+            .cloned()
+            .collect();
+        if preds.len() < 2 {
+            continue;
+        }
+
+        for pred in preds.into_iter() {
+            let mut runner = pred;
+            while runner != idom[&addr] {
+                frontiers.get_mut(&runner).unwrap().insert(addr);
+                runner = idom[&runner];
+            }
+        }
+    }
+
+    frontiers
+}
+
+/// the variables that are read in some block before they're assigned there,
+/// and so may need a phi.
+fn get_live_across_blocks(f: &IrFunction) -> BTreeSet<String> {
+    let mut live = BTreeSet::new();
+    for block in f.blocks.values() {
+        let mut defined: BTreeSet<&str> = BTreeSet::new();
+        for (_, stmt) in block.stmts.iter() {
+            for var in stmt.get_uses().into_iter() {
+                if !defined.contains(var.name.as_str()) {
+                    live.insert(var.name.clone());
+                }
+            }
+            if let Some(var) = stmt.get_def() {
+                defined.insert(&var.name);
+            }
+        }
+    }
+    live
+}
+
+enum Visit {
+    Enter(RVA),
+    /// pop the versions pushed by a block, once its dominator subtree is done.
+    Exit(Vec<String>),
+}
+
+impl IrFunction {
+    /// Convert this function to SSA form, in place.
+    ///
+    /// Uses of a variable that's not assigned on some path from the entry
+    ///  read version 0, the value on entry to the function.
+    ///  Blocks that aren't reachable from the entry aren't versioned.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::ir::lift;
+    ///
+    /// // 0: 85 C0           TEST EAX, EAX
+    /// // 2: 74 07           JZ 0xB
+    /// // 4: B9 01 00 00 00  MOV ECX, 1
+    /// // 9: EB 05           JMP 0x10
+    /// // B: B9 02 00 00 00  MOV ECX, 2
+    /// // 10: 89 C8          MOV EAX, ECX
+    /// // 12: C3             RETN
+    /// let mut ws = test::get_shellcode32_workspace(
+    ///     b"\x85\xC0\x74\x07\xB9\x01\x00\x00\x00\xEB\x05\xB9\x02\x00\x00\x00\x89\xC8\xC3",
+    /// );
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// let mut f = lift::lift_function(&ws, RVA(0x0)).unwrap();
+    /// f.to_ssa();
+    /// assert_eq!(
+    ///     f.to_string(),
+    ///     "0x0:\n    flags.1 = eax.0 & eax.0\n    t0.1 = z(flags.1)\n    if t0.1 goto 0xb\n\
+    ///      0x4:\n    ecx.1 = 0x1\n    goto 0x10\n\
+    ///      0xb:\n    ecx.2 = 0x2\n\
+    ///      0x10:\n    ecx.3 = phi(ecx.1, ecx.2)\n    eax.1 = ecx.3\n    return\n"
+    /// );
+    /// ```
+    pub fn to_ssa(&mut self) {
+        let idom = get_dominators(self);
+        let frontiers = get_dominance_frontiers(self, &idom);
+
+        // place the phis.
+        let mut defsites: BTreeMap<String, BTreeSet<RVA>> = BTreeMap::new();
+        for (&addr, block) in self.blocks.iter().filter(|(addr, _)| idom.contains_key(addr)) {
+            for (_, stmt) in block.stmts.iter() {
+                if let Some(var) = stmt.get_def() {
+                    defsites.entry(var.name.clone()).or_default().insert(addr);
+                }
+            }
+        }
+
+        for name in get_live_across_blocks(self).into_iter() {
+            let mut worklist: Vec<RVA> = defsites.get(&name).into_iter().flatten().cloned().collect();
+            let mut placed: BTreeSet<RVA> = BTreeSet::new();
+            while let Some(addr) = worklist.pop() {
+                for &frontier in frontiers[&addr].iter() {
+                    if !placed.insert(frontier) {
+                        continue;
+                    }
+
+                    let block = self.blocks.get_mut(&frontier).unwrap();
+                    let sources = block
+                        .predecessors
+                        .iter()
+                        .filter(|pred| idom.contains_key(pred))
+                        .map(|&pred| (pred, Var::new(&name)))
+                        .collect();
+                    let phi = Stmt::Phi {
+                        dst: Var::new(&name),
+                        sources,
+                    };
+                    let index = block
+                        .stmts
+                        .iter()
+                        .take_while(|(_, stmt)| match stmt {
+                            Stmt::Phi { .. } => true,
+                            _ => false,
+                        })
+                        .count();
+                    block.stmts.insert(index, (frontier, phi));
+
+                    if !defsites[&name].contains(&frontier) {
+                        worklist.push(frontier);
+                    }
+                }
+            }
+        }
+
+        // version the variables, walking the dominator tree.
+        let mut children: BTreeMap<RVA, Vec<RVA>> = BTreeMap::new();
+        for (&addr, &parent) in idom.iter().filter(|(addr, parent)| addr != parent) {
+            children.entry(parent).or_default().push(addr);
+        }
+
+        let mut counters: HashMap<String, u32> = HashMap::new();
+        let mut stacks: HashMap<String, Vec<u32>> = HashMap::new();
+        let current = |stacks: &HashMap<String, Vec<u32>>, name: &str| {
+            stacks.get(name).and_then(|stack| stack.last()).cloned().unwrap_or(0)
+        };
+
+        let mut work = vec![Visit::Enter(self.addr)];
+        while let Some(visit) = work.pop() {
+            let addr = match visit {
+                Visit::Enter(addr) => addr,
+                Visit::Exit(pushed) => {
+                    for name in pushed.into_iter() {
+                        stacks.get_mut(&name).unwrap().pop();
+                    }
+                    continue;
+                }
+            };
+
+            let mut pushed = vec![];
+            let block = self.blocks.get_mut(&addr).unwrap();
+            for (_, stmt) in block.stmts.iter_mut() {
+                for var in stmt.get_uses_mut().into_iter() {
+                    var.version = Some(current(&stacks, &var.name));
+                }
+                if let Some(var) = stmt.get_def_mut() {
+                    let counter = counters.entry(var.name.clone()).or_insert(0);
+                    *counter += 1;
+                    var.version = Some(*counter);
+                    stacks.entry(var.name.clone()).or_default().push(*counter);
+                    pushed.push(var.name.clone());
+                }
+            }
+
+            // the sources of the phis of the successors are read at the end of
+            // this block.
+            for succ in block.successors.clone().into_iter() {
+                if let Some(succ) = self.blocks.get_mut(&succ) {
+                    for (_, stmt) in succ.stmts.iter_mut() {
+                        if let Stmt::Phi { sources, .. } = stmt {
+                            for (_, var) in sources.iter_mut().filter(|(pred, _)| *pred == addr) {
+                                var.version = Some(current(&stacks, &var.name));
+                            }
+                        }
+                    }
+                }
+            }
+
+            work.push(Visit::Exit(pushed));
+            for &child in children.get(&addr).into_iter().flatten().rev() {
+                work.push(Visit::Enter(child));
+            }
+        }
+    }
+}
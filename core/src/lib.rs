@@ -5,16 +5,36 @@ extern crate rust_embed;
 pub mod analysis;
 pub mod arch;
 pub mod basicblock;
+pub mod comment;
 pub mod config;
+pub mod demangle;
+pub mod diff;
+pub mod export;
 pub mod flowmeta;
+pub mod format;
+pub mod function;
+pub mod hashes;
+pub mod ir;
 pub mod loader;
 pub mod loaders;
 pub mod pagemap;
+pub mod patch;
+pub mod project;
+pub mod pseudocode;
+pub mod search;
+pub mod similarity;
+pub mod stats;
+pub mod strings;
+pub mod symbol;
+pub mod types;
+pub mod usernames;
 pub mod util;
 pub mod workspace;
+pub mod x86;
 pub mod xref;
 
 pub use basicblock::BasicBlock;
+pub use function::Function;
 pub use workspace::Workspace;
 pub use xref::Xref;
 
@@ -42,7 +42,7 @@ bitflags! {
     }
 }
 
-#[derive(Debug)]
+#[derive(Debug, Clone)]
 pub struct Section {
     pub addr:  RVA,
     pub size:  u32,
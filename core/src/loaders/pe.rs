@@ -3,7 +3,7 @@ use goblin::{pe::section_table::SectionTable, Object};
 use log::debug;
 
 use super::super::{
-    analysis::{pe, Analyzer, OrphanFunctionAnalyzer},
+    analysis::{frame::FrameAnalyzer, pe, Analyzer, GoPclntabAnalyzer, OrphanFunctionAnalyzer},
     arch::{Arch, RVA, VA},
     config::Config,
     loader::{FileFormat, LoadedModule, Loader, LoaderError, Permissions, Platform, Section},
@@ -225,18 +225,24 @@ impl Loader for PELoader {
                 Box::new(pe::EntryPointAnalyzer::new()),
                 Box::new(pe::ExportsAnalyzer::new()),
                 Box::new(pe::ImportsAnalyzer::new()),
+                Box::new(pe::HashesAnalyzer::new()),
+                Box::new(pe::PackerAnalyzer::new()),
                 Box::new(pe::CFGuardTableAnalyzer::new()),
                 Box::new(pe::RelocAnalyzer::new()),
                 Box::new(pe::ByteSigAnalyzer::new()),
                 Box::new(pe::FlirtAnalyzer::new(config.analysis.flirt.clone())),
+                Box::new(pe::RttiAnalyzer::new()),
+                Box::new(GoPclntabAnalyzer::new()),
             ];
 
             if pe.is_64 {
                 analyzers.push(Box::new(pe::RuntimeFunctionAnalyzer::new()));
             }
 
-            // this always needs to go last
+            // these are scheduled after the analyzers above by their phase.
             analyzers.push(Box::new(OrphanFunctionAnalyzer::new()));
+            analyzers.push(Box::new(pe::ImportThunkAnalyzer::new()));
+            analyzers.push(Box::new(FrameAnalyzer::new()));
 
             Ok((
                 LoadedModule {
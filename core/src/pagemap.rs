@@ -56,8 +56,25 @@ impl<T: Default + Copy> Default for Page<T> {
 /// contiguous indices. At the moment, indices are `RVA`.
 ///
 /// Lookups should be quick, as they boil down to just a couple dereferences.
+///
+/// Pages are allocated only when mapped, and an unmapped page costs just a
+/// pointer, so sparse layouts (like 64-bit modules with far-apart sections)
+/// don't require a large contiguous allocation.
+///
+/// ```
+/// use lancelot::arch::RVA;
+/// use lancelot::pagemap::PageMap;
+///
+/// let mut d: PageMap<u8> = PageMap::with_capacity(0x1000_0000.into());
+/// d.write(0x0.into(), &[0x1; 0x1000]).expect("failed to map");
+/// d.write(0xFFF_F000.into(), &[0x2; 0x1000]).expect("failed to map");
+/// assert_eq!(d.get(0x0.into()), Some(0x1));
+/// assert_eq!(d.get(0x800_0000.into()), None);
+/// assert_eq!(d.get(0xFFF_FFFF.into()), Some(0x2));
+/// ```
 pub struct PageMap<T: Default + Copy> {
-    pages: Vec<Option<Page<T>>>,
+    // boxed, so that unmapped pages don't take up space.
+    pages: Vec<Option<Box<Page<T>>>>,
 }
 
 impl<T: Default + Copy> PageMap<T> {
@@ -84,7 +101,7 @@ impl<T: Default + Copy> PageMap<T> {
             return Err(PageMapError::NotMapped.into());
         }
 
-        self.pages[page(rva)] = Some(Page::new(items));
+        self.pages[page(rva)] = Some(Box::new(Page::new(items)));
 
         Ok(())
     }
@@ -0,0 +1,141 @@
+//! Modify the bytes of the loaded module, such as to NOP out an anti-analysis
+//! check, while keeping track of the original bytes so that changes can be
+//! reverted.
+//!
+//! Patches apply to the module's address space, not the raw file in
+//! `Workspace::buf`. Instructions are decoded from the address space on each
+//! read, so reads see the patched bytes right away. The analysis of the
+//! instructions that overlap a patch is invalidated and queued, so use
+//! `analyze` to bring it up to date, see `Workspace::invalidate_code`.
+//! Other results, like function metadata, aren't recomputed.
+use failure::{Error, Fail};
+use log::debug;
+
+use super::{arch::RVA, loader::Permissions, workspace::Workspace};
+
+#[derive(Debug, Fail)]
+pub enum PatchError {
+    #[fail(display = "The patch overlaps an existing patch")]
+    Overlap,
+    #[fail(display = "There is no patch at the given address")]
+    NotPatched,
+    #[fail(display = "The patch address is not mapped")]
+    InvalidAddress,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct Patch {
+    pub rva:      RVA,
+    pub original: Vec<u8>,
+    pub patched:  Vec<u8>,
+}
+
+impl Patch {
+    pub fn end(&self) -> RVA {
+        self.rva + self.patched.len()
+    }
+}
+
+impl Workspace {
+    fn write_bytes(&mut self, rva: RVA, bytes: &[u8]) {
+        for (i, &b) in bytes.iter().enumerate() {
+            // the range was probed by the caller.
+            *self.module.address_space.get_mut(rva + i).unwrap() = b;
+        }
+    }
+
+    /// Overwrite the bytes at the given address, recording the original bytes,
+    /// and invalidate the analysis of the instructions there.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    ///
+    /// // 0: 75 01  JNZ $+3
+    /// // 2: 90     NOP
+    /// // 3: C3     RETN
+    /// let mut ws = test::get_shellcode32_workspace(b"\x75\x01\x90\xC3");
+    /// ws.patch_bytes(RVA(0x0), b"\x90\x90").unwrap();
+    /// assert_eq!(ws.read_bytes(RVA(0x0), 4).unwrap(), b"\x90\x90\x90\xC3");
+    /// assert_eq!(ws.read_insn(RVA(0x0)).unwrap().length, 1);
+    /// assert!(ws.patch_bytes(RVA(0x1), b"\xCC").is_err());
+    ///
+    /// let patches = ws.get_patches();
+    /// assert_eq!(patches.len(), 1);
+    /// assert_eq!(patches[0].original, b"\x75\x01");
+    ///
+    /// ws.revert_patch(RVA(0x0)).unwrap();
+    /// assert_eq!(ws.read_bytes(RVA(0x0), 4).unwrap(), b"\x75\x01\x90\xC3");
+    /// assert!(ws.get_patches().is_empty());
+    /// ```
+    ///
+    /// The patched instructions are re-analyzed by `analyze`:
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    ///
+    /// // 0: 75 01  JNZ $+3
+    /// // 2: 90     NOP
+    /// // 3: C3     RETN
+    /// let mut ws = test::get_shellcode32_workspace(b"\x75\x01\x90\xC3");
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.analyze().unwrap();
+    /// assert_eq!(ws.get_basic_blocks(RVA(0x0)).unwrap().len(), 3);
+    ///
+    /// ws.patch_bytes(RVA(0x0), b"\x90\x90").unwrap();
+    /// ws.analyze().unwrap();
+    /// let bbs = ws.get_basic_blocks(RVA(0x0)).unwrap();
+    /// assert_eq!(bbs.len(), 1);
+    /// assert_eq!(bbs[0].insns, vec![RVA(0x0), RVA(0x1), RVA(0x2), RVA(0x3)]);
+    /// ```
+    ///
+    /// Errors:
+    ///
+    ///   - InvalidAddress - if the range is not entirely mapped.
+    ///   - Overlap - if the range overlaps an existing patch.
+    pub fn patch_bytes(&mut self, rva: RVA, bytes: &[u8]) -> Result<(), Error> {
+        if !self.probe(rva, bytes.len(), Permissions::R) {
+            return Err(PatchError::InvalidAddress.into());
+        }
+
+        let end = rva + bytes.len();
+        if self.patches.values().any(|patch| rva < patch.end() && patch.rva < end) {
+            return Err(PatchError::Overlap.into());
+        }
+
+        debug!("patching {} bytes at {}", bytes.len(), rva);
+        let original = self.read_bytes(rva, bytes.len())?;
+        self.write_bytes(rva, bytes);
+        self.invalidate_code(rva, bytes.len());
+        self.patches.insert(
+            rva,
+            Patch {
+                rva,
+                original,
+                patched: bytes.to_vec(),
+            },
+        );
+
+        Ok(())
+    }
+
+    /// Restore the original bytes of the patch at the given address,
+    /// and invalidate the analysis of the instructions there.
+    ///
+    /// Errors:
+    ///
+    ///   - NotPatched - if no patch starts at the given address.
+    pub fn revert_patch(&mut self, rva: RVA) -> Result<(), Error> {
+        let patch = self.patches.remove(&rva).ok_or(PatchError::NotPatched)?;
+        debug!("reverting {} bytes at {}", patch.original.len(), rva);
+        self.write_bytes(rva, &patch.original);
+        self.invalidate_code(rva, patch.original.len());
+        Ok(())
+    }
+
+    /// Fetch the active patches, ordered by address.
+    pub fn get_patches(&self) -> Vec<&Patch> {
+        self.patches.values().collect()
+    }
+}
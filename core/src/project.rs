@@ -0,0 +1,212 @@
+//! Save a workspace to a project directory, and open it again later,
+//!  so that analysis doesn't have to start from scratch.
+//!
+//! layout:
+//!
+//! ```text
+//! project/
+//!   project.json   -- version, filename, loader, configuration, and patches
+//!   image.bin      -- the raw bytes of the loaded file, without patches
+//!   analysis.json  -- the analysis results, see `export::json`
+//! ```
+use std::{fs, io::BufReader, path::PathBuf};
+
+use failure::{Error, Fail};
+use log::debug;
+use serde_json::{self, json, Value};
+
+use super::{analysis::pe::flirt::FlirtConfig, arch::RVA, config::Config, export::json, loader, workspace::Workspace};
+
+/// the version of the project layout produced by `Workspace::save`.
+pub const VERSION: u64 = 1;
+
+#[derive(Debug, Fail)]
+pub enum ProjectError {
+    #[fail(display = "Unsupported project version")]
+    UnsupportedVersion,
+    #[fail(display = "Invalid project structure")]
+    InvalidProject,
+    #[fail(display = "The project's loader is not available")]
+    UnknownLoader,
+}
+
+fn get_str<'a>(v: &'a Value, key: &str) -> Result<&'a str, Error> {
+    v.get(key)
+        .and_then(Value::as_str)
+        .ok_or_else(|| ProjectError::InvalidProject.into())
+}
+
+fn get_bytes(v: &Value, key: &str) -> Result<Vec<u8>, Error> {
+    v.get(key)
+        .and_then(Value::as_array)
+        .ok_or(ProjectError::InvalidProject)?
+        .iter()
+        .map(|b| {
+            b.as_u64()
+                .filter(|&b| b <= u64::from(std::u8::MAX))
+                .map(|b| b as u8)
+                .ok_or_else(|| ProjectError::InvalidProject.into())
+        })
+        .collect()
+}
+
+impl Workspace {
+    /// Save the workspace, including the loaded file, patches, and analysis
+    ///  results, to the given directory, creating it if necessary.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::workspace::Workspace;
+    /// use lancelot::function::{CallingConvention, FunctionMeta, SlotKind, StackFrame, StackSlot};
+    ///
+    /// // 0: E8 00 00 00 00  CALL $+5
+    /// // 5: C3              RETN
+    /// let mut ws = test::get_shellcode32_workspace(b"\xE8\x00\x00\x00\x00\xC3");
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.make_symbol(RVA(0x0), "entry").unwrap();
+    /// ws.analyze().unwrap();
+    /// ws.patch_bytes(RVA(0x5), b"\xCC").unwrap();
+    ///
+    /// let mut frame = StackFrame::default();
+    /// frame.slots.push(StackSlot {
+    ///     offset: -0x4,
+    ///     size:   4,
+    ///     kind:   SlotKind::SavedRegister("ebp".to_string()),
+    ///     name:   "saved_ebp".to_string(),
+    /// });
+    /// frame.references.insert(RVA(0x0), -0x4);
+    /// ws.set_function_meta(RVA(0x0), FunctionMeta {
+    ///     calling_convention: CallingConvention::Stdcall,
+    ///     argument_count: Some(1),
+    ///     frame_size: Some(0x4),
+    ///     frame: Some(frame),
+    ///     is_noreturn: true,
+    ///     source: Some("user".to_string()),
+    ///     classes: vec!["Foo".to_string()],
+    ///     md5: Some("0cc175b9c0f1b6a831c399e269772661".to_string()),
+    ///     sha256: Some("ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb".to_string()),
+    /// }).unwrap();
+    ///
+    /// let path = std::env::temp_dir().join(format!("lancelot-project-{}", std::process::id()));
+    /// let path = path.to_str().unwrap();
+    /// ws.save(path).unwrap();
+    ///
+    /// let ws2 = Workspace::open(path).unwrap();
+    /// assert_eq!(ws2.filename, "foo.bin");
+    /// assert_eq!(ws2.loader.get_name(), "Windows/x32/Raw");
+    /// assert_eq!(ws2.get_symbol(RVA(0x0)).unwrap(), "entry");
+    /// assert_eq!(ws2.get_functions().count(), 2);
+    /// assert_eq!(ws2.get_function_meta(RVA(0x0)), ws.get_function_meta(RVA(0x0)));
+    /// assert_eq!(ws2.get_function_meta(RVA(0x5)), ws.get_function_meta(RVA(0x5)));
+    /// assert_eq!(ws2.get_patches(), ws.get_patches());
+    /// assert_eq!(ws2.read_bytes(RVA(0x5), 1).unwrap(), b"\xCC");
+    ///
+    /// std::fs::remove_dir_all(path).unwrap();
+    /// ```
+    pub fn save(&self, path: &str) -> Result<(), Error> {
+        let path = PathBuf::from(path);
+        fs::create_dir_all(&path)?;
+
+        let patches: Vec<Value> = self
+            .get_patches()
+            .iter()
+            .map(|patch| {
+                let addr: i64 = patch.rva.into();
+                json!({
+                    "rva": addr,
+                    "original": patch.original,
+                    "patched": patch.patched,
+                })
+            })
+            .collect();
+
+        let project = json!({
+            "version": VERSION,
+            "filename": self.filename,
+            "loader": self.loader.get_name(),
+            "config": {
+                "flirt": {
+                    "pat_dir": self.config.analysis.flirt.pat_dir.to_string_lossy(),
+                    "sig_dir": self.config.analysis.flirt.sig_dir.to_string_lossy(),
+                },
+            },
+            "patches": patches,
+        });
+
+        debug!("saving project: {}", path.display());
+        serde_json::to_writer_pretty(fs::File::create(path.join("project.json"))?, &project)?;
+        fs::write(path.join("image.bin"), &self.buf)?;
+        json::export(self, fs::File::create(path.join("analysis.json"))?)
+    }
+
+    /// Open a workspace previously saved with `Workspace::save`.
+    ///
+    /// The file is loaded with the same loader and configuration,
+    ///  the saved patches are re-applied, and the saved analysis results
+    ///  are applied rather than recomputed by the default analyzers.
+    ///
+    /// Errors:
+    ///
+    ///   - UnsupportedVersion - if the project was saved by an incompatible
+    ///     version.
+    ///   - InvalidProject - if the project metadata is missing expected fields,
+    ///     or a patch doesn't match the original bytes of the file.
+    ///   - UnknownLoader - if the loader used by the project is not available.
+    pub fn open(path: &str) -> Result<Workspace, Error> {
+        let path = PathBuf::from(path);
+        debug!("opening project: {}", path.display());
+
+        let project: Value = serde_json::from_reader(BufReader::new(fs::File::open(path.join("project.json"))?))?;
+        match project.get("version").and_then(Value::as_u64) {
+            Some(VERSION) => {}
+            _ => return Err(ProjectError::UnsupportedVersion.into()),
+        };
+
+        let flirt = project
+            .get("config")
+            .and_then(|config| config.get("flirt"))
+            .ok_or(ProjectError::InvalidProject)?;
+        let mut config = Config::default();
+        config.analysis.flirt = FlirtConfig {
+            pat_dir: PathBuf::from(get_str(flirt, "pat_dir")?),
+            sig_dir: PathBuf::from(get_str(flirt, "sig_dir")?),
+        };
+
+        let name = get_str(&project, "loader")?;
+        let ldr = loader::default_loaders()
+            .into_iter()
+            .find(|ldr| ldr.get_name() == name)
+            .ok_or(ProjectError::UnknownLoader)?;
+
+        let buf = fs::read(path.join("image.bin"))?;
+        let mut ws = Workspace::from_bytes(get_str(&project, "filename")?, &buf)
+            .with_config(config)
+            .with_loader(ldr)
+            .disable_analysis()
+            .load()?;
+
+        // the patches are replayed before the analysis is imported,
+        // since the analysis was computed over the patched bytes.
+        let patches = project
+            .get("patches")
+            .and_then(Value::as_array)
+            .ok_or(ProjectError::InvalidProject)?;
+        for patch in patches.iter() {
+            let rva = patch
+                .get("rva")
+                .and_then(Value::as_i64)
+                .map(RVA::from)
+                .ok_or(ProjectError::InvalidProject)?;
+            let original = get_bytes(patch, "original")?;
+            if ws.read_bytes(rva, original.len())? != original {
+                return Err(ProjectError::InvalidProject.into());
+            }
+            ws.patch_bytes(rva, &get_bytes(patch, "patched")?)?;
+        }
+
+        json::import(&mut ws, BufReader::new(fs::File::open(path.join("analysis.json"))?))?;
+
+        Ok(ws)
+    }
+}
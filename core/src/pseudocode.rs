@@ -0,0 +1,569 @@
+//! Render functions as a simplified pseudocode, for triage.
+//!
+//! Each instruction is lifted to a statement, like `eax = eax + 0x4`,
+//!  and then common idioms within a basic block are folded together:
+//!
+//!   - an update of the register that was just assigned, like `eax = [ebp+0x8]`
+//!     and `eax = eax + 0x4`, becomes `eax = [ebp+0x8] + 0x4`,
+//!   - a comparison followed by a conditional jump, like `TEST EAX, EAX` and
+//!     `JZ loc_10`, becomes `if (eax == 0x0) goto loc_10`, and
+//!   - a zeroing idiom, like `XOR EAX, EAX`, becomes `eax = 0x0`.
+//!
+//! The comments of each instruction are rendered with the statement it was
+//!  lifted to, like `eax = eax + 0x4  // skip the header`.
+//!
+//! This is not a decompiler: there are no types, variables, or structured
+//!  control flow, and instructions without a translation are rendered as
+//!  assembly, like `asm(rep movsb)`.
+use std::fmt;
+
+use failure::Error;
+use zydis;
+
+use super::{
+    arch::{RVA, VA},
+    basicblock::BasicBlock,
+    comment::CommentType,
+    function::{StackFrame, StackSlot},
+    ir::lift::get_operator,
+    util::format_constant,
+    workspace::Workspace,
+    x86::{get_operands, is_conditional_jump, mnemonic_name, register_name},
+};
+
+#[derive(Debug, Clone, PartialEq)]
+pub enum Expr {
+    Register(String),
+    Constant(i64),
+    /// a label or symbol, like `loc_401000` or `CreateFileA`.
+    Name(String),
+    /// a memory reference, like `[ebp+0x8]` or `[eax+ecx*4]`.
+    Memory {
+        segment:      Option<String>,
+        base:         Option<String>,
+        index:        Option<(String, u8)>,
+        displacement: i64,
+    },
+    /// a memory reference to a named global, like `[CreateFileA]`.
+    Global(String),
+    Binary {
+        op:    &'static str,
+        left:  Box<Expr>,
+        right: Box<Expr>,
+    },
+}
+
+impl Expr {
+    fn binary(op: &'static str, left: Expr, right: Expr) -> Expr {
+        Expr::Binary {
+            op,
+            left: Box::new(left),
+            right: Box::new(right),
+        }
+    }
+
+    /// does this expression read the given register?
+    pub fn uses(&self, register: &str) -> bool {
+        match self {
+            Expr::Register(r) => r == register,
+            Expr::Memory { base, index, .. } => {
+                base.as_ref().map_or(false, |b| b == register) || index.as_ref().map_or(false, |(i, _)| i == register)
+            }
+            Expr::Binary { left, right, .. } => left.uses(register) || right.uses(register),
+            _ => false,
+        }
+    }
+}
+
+impl fmt::Display for Expr {
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        match self {
+            Expr::Register(r) => write!(f, "{}", r),
+            Expr::Constant(v) => write!(f, "{}", format_constant(*v)),
+            Expr::Name(name) => write!(f, "{}", name),
+            Expr::Memory {
+                segment,
+                base,
+                index,
+                displacement,
+            } => {
+                let mut parts: Vec<String> = vec![];
+                parts.extend(base.iter().cloned());
+                parts.extend(index.iter().map(|(index, scale)| {
+                    if *scale > 1 {
+                        format!("{}*{}", index, scale)
+                    } else {
+                        index.clone()
+                    }
+                }));
+
+                let mut address = parts.join("+");
+                if address.is_empty() {
+                    address = format_constant(*displacement);
+                } else if *displacement != 0 {
+                    let sign = if *displacement < 0 { "" } else { "+" };
+                    address.push_str(&format!("{}{}", sign, format_constant(*displacement)));
+                }
+
+                match segment {
+                    Some(segment) => write!(f, "{}:[{}]", segment, address),
+                    None => write!(f, "[{}]", address),
+                }
+            }
+            Expr::Global(name) => write!(f, "[{}]", name),
+            Expr::Binary { op, left, right } => {
+                let render = |e: &Expr| match e {
+                    Expr::Binary { .. } => format!("({})", e),
+                    _ => format!("{}", e),
+                };
+
+                match (op, right.as_ref()) {
+                    (&"+", Expr::Constant(v)) if *v < 0 => {
+                        write!(f, "{} - {}", render(left), format_constant(v.wrapping_neg()))
+                    }
+                    _ => write!(f, "{} {} {}", render(left), op, render(right)),
+                }
+            }
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub enum Statement {
+    Assign {
+        dst: Expr,
+        src: Expr,
+    },
+    Push(Expr),
+    Pop(Expr),
+    Call(Expr),
+    If {
+        condition: Expr,
+        target:    Expr,
+    },
+    Goto(Expr),
+    Return,
+    /// an instruction without a translation, like `rep movsb`.
+    Asm(String),
+}
+
+impl fmt::Display for Statement {
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        match self {
+            Statement::Assign { dst, src } => write!(f, "{} = {}", dst, src),
+            Statement::Push(e) => write!(f, "push({})", e),
+            Statement::Pop(e) => write!(f, "{} = pop()", e),
+            Statement::Call(e) => write!(f, "{}()", e),
+            Statement::If { condition, target } => write!(f, "if ({}) goto {}", condition, target),
+            Statement::Goto(e) => write!(f, "goto {}", e),
+            Statement::Return => write!(f, "return"),
+            Statement::Asm(s) => write!(f, "asm({})", s),
+        }
+    }
+}
+
+/// render an operand, using the name of the stack slot it references, if any.
+fn lift_operand(
+    ws: &Workspace,
+    rva: RVA,
+    insn: &zydis::DecodedInstruction,
+    op: &zydis::DecodedOperand,
+    slot: Option<&StackSlot>,
+) -> Expr {
+    match op.ty {
+        zydis::OperandType::REGISTER => Expr::Register(register_name(op.reg).unwrap_or_else(|| "?".to_string())),
+        zydis::OperandType::IMMEDIATE if op.imm.is_relative => {
+            let target = rva + RVA::from(op.imm.value as i64) + insn.length;
+            Expr::Name(ws.get_name(target))
+        }
+        zydis::OperandType::IMMEDIATE => Expr::Constant(op.imm.value as i64),
+        zydis::OperandType::MEMORY => {
+            let base = register_name(op.mem.base);
+            let index = register_name(op.mem.index).map(|index| (index, op.mem.scale));
+
+            // absolute and RIP-relative references to symbols, like imports.
+            let target = match (op.mem.base, &index) {
+                (zydis::Register::NONE, None) => ws.rva(VA::from(op.mem.disp.displacement as u64)),
+                (zydis::Register::RIP, None) => Some(rva + RVA::from(op.mem.disp.displacement) + insn.length),
+                _ => None,
+            };
+            if let Some(name) = target.and_then(|target| ws.get_symbol(target)) {
+                return Expr::Global(name.to_string());
+            }
+
+            if let Some(slot) = slot {
+                return Expr::Name(slot.name.clone());
+            }
+
+            let segment = match op.mem.segment {
+                zydis::Register::FS | zydis::Register::GS => register_name(op.mem.segment),
+                _ => None,
+            };
+
+            Expr::Memory {
+                segment,
+                base,
+                index,
+                displacement: op.mem.disp.displacement,
+            }
+        }
+        _ => Expr::Name("?".to_string()),
+    }
+}
+
+/// render the address computed by a memory operand, such as for `LEA`.
+fn lift_address(op: &zydis::DecodedOperand) -> Expr {
+    let mut terms = vec![];
+    if let Some(base) = register_name(op.mem.base) {
+        terms.push(Expr::Register(base));
+    }
+    if let Some(index) = register_name(op.mem.index) {
+        if op.mem.scale > 1 {
+            terms.push(Expr::binary(
+                "*",
+                Expr::Register(index),
+                Expr::Constant(i64::from(op.mem.scale)),
+            ));
+        } else {
+            terms.push(Expr::Register(index));
+        }
+    }
+    if op.mem.disp.displacement != 0 || terms.is_empty() {
+        terms.push(Expr::Constant(op.mem.disp.displacement));
+    }
+
+    let mut terms = terms.into_iter();
+    // there's always at least one term.
+    let first = terms.next().unwrap();
+    terms.fold(first, |left, right| Expr::binary("+", left, right))
+}
+
+/// the relation tested by a conditional jump, given the operands of the
+/// preceding comparison.
+fn get_relation(mnemonic: zydis::Mnemonic) -> Option<&'static str> {
+    match mnemonic {
+        zydis::Mnemonic::JZ => Some("=="),
+        zydis::Mnemonic::JNZ => Some("!="),
+        zydis::Mnemonic::JL | zydis::Mnemonic::JB => Some("<"),
+        zydis::Mnemonic::JLE | zydis::Mnemonic::JBE => Some("<="),
+        zydis::Mnemonic::JNLE | zydis::Mnemonic::JNBE => Some(">"),
+        zydis::Mnemonic::JNL | zydis::Mnemonic::JNB => Some(">="),
+        _ => None,
+    }
+}
+
+/// Lift the instructions of the given basic block to statements,
+///  and fold common idioms together.
+///
+/// Given the stack frame of the function, stack references are rendered
+///  by the names of their slots, like `var_8`.
+///
+/// ```
+/// use lancelot::test;
+/// use lancelot::arch::RVA;
+/// use lancelot::analysis::frame;
+/// use lancelot::pseudocode;
+///
+/// // 0: 55        PUSH EBP
+/// // 1: 8B EC     MOV EBP, ESP
+/// // 3: FF 55 08  CALL [EBP+0x8]
+/// // 6: 5D        POP EBP
+/// // 7: C3        RETN
+/// let mut ws = test::get_shellcode32_workspace(b"\x55\x8B\xEC\xFF\x55\x08\x5D\xC3");
+/// ws.make_function(RVA(0x0)).unwrap();
+/// ws.analyze().unwrap();
+///
+/// let frame = frame::recover_frame(&ws, RVA(0x0)).unwrap();
+/// let bb = ws.get_basic_blocks(RVA(0x0)).unwrap().into_iter().next().unwrap();
+/// let statements: Vec<String> = pseudocode::lift_basic_block(&ws, &bb, Some(&frame))
+///     .unwrap()
+///     .iter()
+///     .map(|statement| statement.to_string())
+///     .collect();
+/// assert_eq!(statements, vec!["push(ebp)", "ebp = esp", "arg_0()", "ebp = pop()", "return"]);
+/// ```
+///
+/// Errors: same as `read_insn`.
+pub fn lift_basic_block(ws: &Workspace, bb: &BasicBlock, frame: Option<&StackFrame>) -> Result<Vec<Statement>, Error> {
+    Ok(lift_basic_block_at(ws, bb, frame)?
+        .into_iter()
+        .map(|(_, statement)| statement)
+        .collect())
+}
+
+/// like `lift_basic_block`, but with the address of the last instruction
+/// lifted into each statement.
+fn lift_basic_block_at(
+    ws: &Workspace,
+    bb: &BasicBlock,
+    frame: Option<&StackFrame>,
+) -> Result<Vec<(RVA, Statement)>, Error> {
+    let mut statements: Vec<(RVA, Statement)> = vec![];
+    // the address and operands of the most recent comparison,
+    // if it's not yet used.
+    let mut comparison: Option<(RVA, Expr, Expr)> = None;
+
+    for &rva in bb.insns.iter() {
+        let insn = ws.read_insn(rva)?;
+        let slot = frame.and_then(|frame| frame.get_reference(rva));
+        let ops: Vec<Expr> = get_operands(&insn)
+            .into_iter()
+            .map(|op| lift_operand(ws, rva, &insn, op, slot))
+            .collect();
+
+        if let Some((cmp, left, right)) = comparison.take() {
+            match get_relation(insn.mnemonic) {
+                Some(relation) if is_conditional_jump(insn.mnemonic) => {
+                    statements.push((
+                        rva,
+                        Statement::If {
+                            condition: Expr::binary(relation, left, right),
+                            target:    ops[0].clone(),
+                        },
+                    ));
+                    continue;
+                }
+                _ => statements.push((cmp, Statement::Asm(format!("cmp {}, {}", left, right)))),
+            }
+        }
+
+        let statement = match insn.mnemonic {
+            zydis::Mnemonic::NOP => continue,
+            zydis::Mnemonic::CMP => {
+                comparison = Some((rva, ops[0].clone(), ops[1].clone()));
+                continue;
+            }
+            zydis::Mnemonic::TEST => {
+                comparison = if ops[0] == ops[1] {
+                    Some((rva, ops[0].clone(), Expr::Constant(0)))
+                } else {
+                    Some((
+                        rva,
+                        Expr::binary("&", ops[0].clone(), ops[1].clone()),
+                        Expr::Constant(0),
+                    ))
+                };
+                continue;
+            }
+            zydis::Mnemonic::MOV | zydis::Mnemonic::MOVZX | zydis::Mnemonic::MOVSX | zydis::Mnemonic::MOVSXD => {
+                Statement::Assign {
+                    dst: ops[0].clone(),
+                    src: ops[1].clone(),
+                }
+            }
+            zydis::Mnemonic::LEA => Statement::Assign {
+                dst: ops[0].clone(),
+                src: match slot {
+                    Some(slot) => Expr::Name(format!("&{}", slot.name)),
+                    None => lift_address(get_operands(&insn)[1]),
+                },
+            },
+            zydis::Mnemonic::XOR | zydis::Mnemonic::SUB if ops[0] == ops[1] => Statement::Assign {
+                dst: ops[0].clone(),
+                src: Expr::Constant(0),
+            },
+            zydis::Mnemonic::INC => Statement::Assign {
+                dst: ops[0].clone(),
+                src: Expr::binary("+", ops[0].clone(), Expr::Constant(1)),
+            },
+            zydis::Mnemonic::DEC => Statement::Assign {
+                dst: ops[0].clone(),
+                src: Expr::binary("-", ops[0].clone(), Expr::Constant(1)),
+            },
+            // the three operand form, like `IMUL EAX, ECX, 0x10`.
+            zydis::Mnemonic::IMUL if ops.len() == 3 => Statement::Assign {
+                dst: ops[0].clone(),
+                src: Expr::binary("*", ops[1].clone(), ops[2].clone()),
+            },
+            mnemonic if ops.len() == 2 && get_operator(mnemonic).is_some() => Statement::Assign {
+                dst: ops[0].clone(),
+                src: Expr::binary(
+                    get_operator(mnemonic).unwrap().get_symbol(),
+                    ops[0].clone(),
+                    ops[1].clone(),
+                ),
+            },
+            zydis::Mnemonic::PUSH => Statement::Push(ops[0].clone()),
+            zydis::Mnemonic::POP => Statement::Pop(ops[0].clone()),
+            zydis::Mnemonic::CALL => {
+                let op = get_operands(&insn)[0];
+                Statement::Call(match &ops[0] {
+                    // direct calls, by the name of the target.
+                    _ if op.ty == zydis::OperandType::IMMEDIATE && op.imm.is_relative => {
+                        let target = rva + RVA::from(op.imm.value as i64) + insn.length;
+                        Expr::Name(ws.format_address(target))
+                    }
+                    // calls through a pointer, like an import.
+                    Expr::Global(name) => Expr::Name(name.clone()),
+                    // calls through a register or stack slot, like `arg_0`.
+                    target => target.clone(),
+                })
+            }
+            zydis::Mnemonic::JMP => Statement::Goto(ops[0].clone()),
+            zydis::Mnemonic::RET => Statement::Return,
+            mnemonic if is_conditional_jump(mnemonic) => Statement::If {
+                condition: Expr::Name(mnemonic_name(mnemonic)),
+                target:    ops[0].clone(),
+            },
+            mnemonic => {
+                let ops: Vec<String> = ops.iter().map(|op| op.to_string()).collect();
+                Statement::Asm(
+                    format!("{} {}", mnemonic_name(mnemonic), ops.join(", "))
+                        .trim()
+                        .to_string(),
+                )
+            }
+        };
+
+        // fold an update into the preceding assignment to the same register,
+        // like `eax = [ebp+0x8]; eax = eax + 0x4`.
+        if let Statement::Assign {
+            dst: Expr::Register(register),
+            src: Expr::Binary { op, left, right },
+        } = &statement
+        {
+            if let Some((
+                _,
+                Statement::Assign {
+                    dst: Expr::Register(previous),
+                    src,
+                },
+            )) = statements.last()
+            {
+                if previous == register && **left == Expr::Register(register.clone()) && !right.uses(register) {
+                    let src = Expr::binary(*op, src.clone(), (**right).clone());
+                    statements.pop();
+                    statements.push((
+                        rva,
+                        Statement::Assign {
+                            dst: Expr::Register(register.clone()),
+                            src,
+                        },
+                    ));
+                    continue;
+                }
+            }
+        }
+
+        statements.push((rva, statement));
+    }
+
+    if let Some((cmp, left, right)) = comparison {
+        statements.push((cmp, Statement::Asm(format!("cmp {}, {}", left, right))));
+    }
+
+    Ok(statements)
+}
+
+impl Workspace {
+    /// the lines of the given comment at the given address, indented and
+    /// prefixed like `    // text`.
+    fn get_comment_lines(&self, rva: RVA, typ: CommentType) -> Vec<String> {
+        match self.get_comment(rva, typ) {
+            Some(comment) => comment.lines().map(|line| format!("    // {}", line)).collect(),
+            None => vec![],
+        }
+    }
+
+    /// Render the function that starts at the given address as pseudocode,
+    ///  with each basic block under its label, in address order.
+    ///  If its stack frame has been recovered, such as by the `FrameAnalyzer`,
+    ///  stack references are rendered by name.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    ///
+    /// // 0: 8B 45 08  MOV EAX, [EBP+0x8]
+    /// // 3: 83 C0 04  ADD EAX, 0x4
+    /// // 6: 85 C0     TEST EAX, EAX
+    /// // 8: 74 01     JZ 0xB
+    /// // A: 40        INC EAX
+    /// // B: C3        RETN
+    /// let mut ws = test::get_shellcode32_workspace(b"\x8B\x45\x08\x83\xC0\x04\x85\xC0\x74\x01\x40\xC3");
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// assert_eq!(
+    ///     ws.get_pseudocode(RVA(0x0)).unwrap(),
+    ///     "sub_0:\n    eax = [ebp+0x8] + 0x4\n    if (eax == 0x0) goto loc_b\n\
+    ///      loc_a:\n    eax = eax + 0x1\n\
+    ///      loc_b:\n    return\n"
+    /// );
+    /// ```
+    ///
+    /// Comments are rendered with the statement that their instruction was
+    /// lifted to:
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::comment::CommentType;
+    ///
+    /// // 0: 8B 45 08  MOV EAX, [EBP+0x8]
+    /// // 3: 83 C0 04  ADD EAX, 0x4
+    /// // 6: C3        RETN
+    /// let mut ws = test::get_shellcode32_workspace(b"\x8B\x45\x08\x83\xC0\x04\xC3");
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.make_comment(RVA(0x0), CommentType::Pre, "load the argument").unwrap();
+    /// ws.make_comment(RVA(0x3), CommentType::Inline, "skip the header").unwrap();
+    /// ws.make_comment(RVA(0x6), CommentType::Post, "done").unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// assert_eq!(
+    ///     ws.get_pseudocode(RVA(0x0)).unwrap(),
+    ///     "sub_0:\n    // load the argument\n    eax = [ebp+0x8] + 0x4  // skip the header\n\
+    ///      \x20   return\n    // done\n"
+    /// );
+    /// ```
+    ///
+    /// Errors: same as `get_basic_blocks` and `read_insn`.
+    pub fn get_pseudocode(&self, rva: RVA) -> Result<String, Error> {
+        let mut bbs = self.get_basic_blocks(rva)?;
+        bbs.sort_by(|a, b| a.addr.cmp(&b.addr));
+
+        let frame = self.get_function_meta(rva).and_then(|meta| meta.frame.as_ref());
+
+        let mut lines = vec![];
+        for bb in bbs.iter() {
+            lines.push(format!("{}:", self.get_name(bb.addr)));
+
+            let mut insns = bb.insns.iter().cloned().peekable();
+            for (addr, statement) in lift_basic_block_at(self, bb, frame)?.into_iter() {
+                let mut before = vec![];
+                let mut inline = vec![];
+                let mut after = vec![];
+                // the instructions lifted into this statement.
+                while let Some(&insn) = insns.peek() {
+                    if insn > addr {
+                        break;
+                    }
+                    insns.next();
+                    before.extend(self.get_comment_lines(insn, CommentType::Pre));
+                    if let Some(comment) = self.get_comment(insn, CommentType::Inline) {
+                        inline.push(comment.replace('\n', " "));
+                    }
+                    after.extend(self.get_comment_lines(insn, CommentType::Post));
+                }
+
+                lines.extend(before);
+                if inline.is_empty() {
+                    lines.push(format!("    {}", statement));
+                } else {
+                    lines.push(format!("    {}  // {}", statement, inline.join("; ")));
+                }
+                lines.extend(after);
+            }
+
+            // instructions without a statement, like a trailing NOP,
+            // still render their comments.
+            for insn in insns {
+                for typ in [CommentType::Pre, CommentType::Inline, CommentType::Post].iter() {
+                    lines.extend(self.get_comment_lines(insn, *typ));
+                }
+            }
+        }
+
+        Ok(lines.into_iter().map(|line| line + "\n").collect())
+    }
+}
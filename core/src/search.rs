@@ -0,0 +1,101 @@
+//! Search the mapped sections for byte patterns, such as signatures or
+//!  cryptographic constants.
+//!
+//! Matches do not span sections.
+use failure::{Error, Fail};
+use log::warn;
+use regex::bytes::Regex;
+
+use super::{arch::RVA, workspace::Workspace};
+
+#[derive(Debug, Fail)]
+pub enum SearchError {
+    #[fail(display = "The pattern and mask must have the same, non-zero length")]
+    InvalidMask,
+}
+
+impl Workspace {
+    /// invoke the given callback with the address and contents of each
+    /// readable section.
+    fn scan_sections<F>(&self, mut f: F)
+    where
+        F: FnMut(RVA, &[u8]),
+    {
+        for section in self.module.sections.iter() {
+            match self.read_bytes(section.addr, section.size as usize) {
+                Ok(buf) => f(section.addr, &buf),
+                Err(e) => warn!("failed to read section {}: {}", section.name, e),
+            }
+        }
+    }
+
+    /// Find the addresses at which the given masked byte pattern matches.
+    ///
+    /// Only the bits set in the mask are compared,
+    ///  so a mask byte of `0x00` is a wildcard.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    ///
+    /// // 0: E8 00 00 00 00  CALL $+5
+    /// // 5: E8 10 00 00 00  CALL $+0x15
+    /// // A: C3              RETN
+    /// let ws = test::get_shellcode32_workspace(b"\xE8\x00\x00\x00\x00\xE8\x10\x00\x00\x00\xC3");
+    /// assert_eq!(
+    ///     ws.search_bytes(b"\xE8\x00\x00\x00\x00", b"\xFF\x00\x00\x00\x00").unwrap(),
+    ///     vec![RVA(0x0), RVA(0x5)]
+    /// );
+    /// assert_eq!(
+    ///     ws.search_bytes(b"\xE8\x10", b"\xFF\xFF").unwrap(),
+    ///     vec![RVA(0x5)]
+    /// );
+    /// assert!(ws.search_bytes(b"\xE8", b"").is_err());
+    /// ```
+    ///
+    /// Errors:
+    ///
+    ///   - InvalidMask - if the pattern and mask lengths differ, or are zero.
+    pub fn search_bytes(&self, pattern: &[u8], mask: &[u8]) -> Result<Vec<RVA>, Error> {
+        if pattern.is_empty() || pattern.len() != mask.len() {
+            return Err(SearchError::InvalidMask.into());
+        }
+
+        let mut matches = vec![];
+        self.scan_sections(|addr, buf| {
+            for (offset, window) in buf.windows(pattern.len()).enumerate() {
+                if window
+                    .iter()
+                    .zip(pattern.iter().zip(mask.iter()))
+                    .all(|(&b, (&p, &m))| b & m == p & m)
+                {
+                    matches.push(addr + offset);
+                }
+            }
+        });
+
+        Ok(matches)
+    }
+
+    /// Find the addresses at which the given regular expression matches.
+    /// Matches do not overlap.
+    ///
+    /// ```
+    /// use regex::bytes::Regex;
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    ///
+    /// let ws = test::get_shellcode32_workspace(b"\xC3\x00expand 32-byte k\x00");
+    /// let re = Regex::new("expand (16|32)-byte k").unwrap();
+    /// assert_eq!(ws.search_regex(&re), vec![RVA(0x2)]);
+    /// ```
+    pub fn search_regex(&self, re: &Regex) -> Vec<RVA> {
+        let mut matches = vec![];
+        self.scan_sections(|addr, buf| {
+            for mat in re.find_iter(buf) {
+                matches.push(addr + mat.start());
+            }
+        });
+        matches
+    }
+}
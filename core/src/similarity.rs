@@ -0,0 +1,284 @@
+//! Compute structural signatures of functions, to find duplicated,
+//!  inlined, or library routines that are similar but not identical.
+//!
+//! Each function has two signatures:
+//!
+//!   - a machoc hash of its control flow graph, which matches functions with
+//!     the same shape regardless of their instructions, and
+//!   - a MinHash of its mnemonic trigrams, which estimates the Jaccard
+//!     similarity of the instructions of two functions.
+//!
+//! Both ignore operands, so they don't depend on where the function or its
+//!  data is located.
+use std::collections::{BTreeMap, HashMap, HashSet};
+
+use failure::Error;
+use zydis;
+
+use super::{arch::RVA, workspace::Workspace};
+
+/// the number of hash functions in a MinHash signature.
+/// the error of a similarity estimate is about `1 / sqrt(MINHASH_SIZE)`.
+pub const MINHASH_SIZE: usize = 64;
+
+/// the number of consecutive mnemonics in each feature of a MinHash.
+const NGRAM_SIZE: usize = 3;
+
+/// MurmurHash3, x86 32-bit variant.
+///
+/// ```
+/// use lancelot::similarity::murmur3;
+///
+/// assert_eq!(murmur3(b"", 0), 0);
+/// assert_eq!(murmur3(b"hello", 0), 0x248B_FA47);
+/// ```
+pub fn murmur3(buf: &[u8], seed: u32) -> u32 {
+    const C1: u32 = 0xCC9E_2D51;
+    const C2: u32 = 0x1B87_3593;
+
+    let mix = |k: u32| k.wrapping_mul(C1).rotate_left(15).wrapping_mul(C2);
+
+    let mut h = seed;
+    let chunks = buf.chunks_exact(4);
+    let tail = chunks.remainder();
+    for chunk in chunks {
+        let k = u32::from(chunk[0]) | u32::from(chunk[1]) << 8 | u32::from(chunk[2]) << 16 | u32::from(chunk[3]) << 24;
+        h ^= mix(k);
+        h = h.rotate_left(13).wrapping_mul(5).wrapping_add(0xE654_6B64);
+    }
+
+    if !tail.is_empty() {
+        let k = tail
+            .iter()
+            .enumerate()
+            .fold(0u32, |k, (i, &b)| k | u32::from(b) << (8 * i));
+        h ^= mix(k);
+    }
+
+    h ^= buf.len() as u32;
+    h ^= h >> 16;
+    h = h.wrapping_mul(0x85EB_CA6B);
+    h ^= h >> 13;
+    h = h.wrapping_mul(0xC2B2_AE35);
+    h ^= h >> 16;
+    h
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct FunctionSignature {
+    pub rva:     RVA,
+    /// the machoc hash of the control flow graph.
+    pub machoc:  u32,
+    /// the minimum hash of the mnemonic trigrams, under each hash function.
+    pub minhash: Vec<u32>,
+}
+
+impl FunctionSignature {
+    /// Estimate the Jaccard similarity of the instructions of two functions,
+    ///  from 0.0 to 1.0.
+    pub fn similarity(&self, other: &FunctionSignature) -> f64 {
+        let same = self
+            .minhash
+            .iter()
+            .zip(other.minhash.iter())
+            .filter(|(a, b)| a == b)
+            .count();
+        same as f64 / MINHASH_SIZE as f64
+    }
+}
+
+impl Workspace {
+    /// Compute the signature of the function that starts at the given address.
+    ///
+    /// The machoc hash is the murmur3 hash of a description of each basic
+    ///  block, numbered in address order, like `1:c,2,3;`: its number,
+    ///  `c,` if it contains a call, and the numbers of its successors.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    ///
+    /// // 0: B8 01 00 00 00  MOV EAX, 1
+    /// // 5: 85 C0           TEST EAX, EAX
+    /// // 7: 74 01           JZ 0xA
+    /// // 9: 40              INC EAX
+    /// // A: C3              RETN
+    /// let mut ws1 = test::get_shellcode32_workspace(b"\xB8\x01\x00\x00\x00\x85\xC0\x74\x01\x40\xC3");
+    /// ws1.make_function(RVA(0x0)).unwrap();
+    /// ws1.analyze().unwrap();
+    ///
+    /// // same as above, but with DEC EAX
+    /// let mut ws2 = test::get_shellcode32_workspace(b"\xB8\x01\x00\x00\x00\x85\xC0\x74\x01\x48\xC3");
+    /// ws2.make_function(RVA(0x0)).unwrap();
+    /// ws2.analyze().unwrap();
+    ///
+    /// let sig1 = ws1.get_function_signature(RVA(0x0)).unwrap();
+    /// let sig2 = ws2.get_function_signature(RVA(0x0)).unwrap();
+    /// assert_eq!(sig1.machoc, sig2.machoc);
+    /// assert_eq!(sig1.similarity(&sig1), 1.0);
+    /// assert!(sig1.similarity(&sig2) < 1.0);
+    /// ```
+    ///
+    /// Errors: same as `get_basic_blocks` and `read_insn`.
+    pub fn get_function_signature(&self, rva: RVA) -> Result<FunctionSignature, Error> {
+        let mut bbs = self.get_basic_blocks(rva)?;
+        bbs.sort_by(|a, b| a.addr.cmp(&b.addr));
+
+        let numbers: HashMap<RVA, usize> = bbs.iter().enumerate().map(|(i, bb)| (bb.addr, i + 1)).collect();
+
+        let mut machoc = String::new();
+        let mut features: HashSet<Vec<u8>> = HashSet::new();
+        for bb in bbs.iter() {
+            let mut mnemonics: Vec<zydis::Mnemonic> = vec![];
+            for &insn in bb.insns.iter() {
+                mnemonics.push(self.read_insn(insn)?.mnemonic);
+            }
+
+            machoc.push_str(&format!("{}:", numbers[&bb.addr]));
+            if mnemonics.contains(&zydis::Mnemonic::CALL) {
+                machoc.push_str("c,");
+            }
+            let mut successors: Vec<usize> = bb.successors.iter().filter_map(|s| numbers.get(s)).cloned().collect();
+            successors.sort();
+            let successors: Vec<String> = successors.iter().map(|s| s.to_string()).collect();
+            machoc.push_str(&successors.join(","));
+            machoc.push(';');
+
+            // short blocks contribute a single, shorter feature.
+            let ids: Vec<u8> = mnemonics
+                .iter()
+                .flat_map(|&m| (m as u32).to_le_bytes().to_vec())
+                .collect();
+            let size = 4 * std::cmp::min(NGRAM_SIZE, mnemonics.len());
+            if size > 0 {
+                for ngram in ids.windows(size).step_by(4) {
+                    features.insert(ngram.to_vec());
+                }
+            }
+        }
+
+        let minhash = (0..MINHASH_SIZE as u32)
+            .map(|seed| {
+                features
+                    .iter()
+                    .map(|feature| murmur3(feature, seed))
+                    .min()
+                    .unwrap_or(u32::max_value())
+            })
+            .collect();
+
+        Ok(FunctionSignature {
+            rva,
+            machoc: murmur3(machoc.as_bytes(), 0),
+            minhash,
+        })
+    }
+}
+
+/// the signatures of all the functions in a workspace,
+/// for nearest-neighbor queries.
+pub struct SimilarityIndex {
+    signatures: BTreeMap<RVA, FunctionSignature>,
+}
+
+impl SimilarityIndex {
+    /// Compute the signatures of all the functions in the given workspace.
+    ///  Functions whose signature can't be computed are skipped.
+    pub fn new(ws: &Workspace) -> SimilarityIndex {
+        let signatures = ws
+            .get_functions()
+            .filter_map(|&rva| ws.get_function_signature(rva).ok())
+            .map(|sig| (sig.rva, sig))
+            .collect();
+
+        SimilarityIndex { signatures }
+    }
+
+    pub fn get_signature(&self, rva: RVA) -> Option<&FunctionSignature> {
+        self.signatures.get(&rva)
+    }
+
+    /// Find the functions most similar to the given signature, which may come
+    ///  from another workspace, with at least the given similarity.
+    ///
+    /// The results are sorted by similarity, descending, then by address.
+    pub fn find_similar(&self, signature: &FunctionSignature, threshold: f64) -> Vec<(RVA, f64)> {
+        let mut matches: Vec<(RVA, f64)> = self
+            .signatures
+            .values()
+            .map(|other| (other.rva, signature.similarity(other)))
+            .filter(|&(_, similarity)| similarity >= threshold)
+            .collect();
+
+        // similarities are never NaN, so they're totally ordered.
+        matches.sort_by(|a, b| b.1.partial_cmp(&a.1).unwrap().then_with(|| a.0.cmp(&b.0)));
+        matches
+    }
+
+    /// Find the given number of functions most similar to the function at
+    ///  the given address, excluding itself.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::similarity::SimilarityIndex;
+    ///
+    /// // 0: 55              PUSH EBP
+    /// // 1: 8B EC           MOV EBP, ESP
+    /// // 3: 33 C0           XOR EAX, EAX
+    /// // 5: 5D              POP EBP
+    /// // 6: C3              RETN
+    /// // 7: 55              PUSH EBP
+    /// // 8: 8B EC           MOV EBP, ESP
+    /// // A: 33 C9           XOR ECX, ECX
+    /// // C: 5D              POP EBP
+    /// // D: C3              RETN
+    /// // E: 90              NOP
+    /// // F: C3              RETN
+    /// let mut ws = test::get_shellcode32_workspace(
+    ///     b"\x55\x8B\xEC\x33\xC0\x5D\xC3\x55\x8B\xEC\x33\xC9\x5D\xC3\x90\xC3",
+    /// );
+    /// ws.make_function(RVA(0x0)).unwrap();
+    /// ws.make_function(RVA(0x7)).unwrap();
+    /// ws.make_function(RVA(0xE)).unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// let index = SimilarityIndex::new(&ws);
+    /// let nearest = index.find_nearest(RVA(0x0), 1);
+    /// assert_eq!(nearest, vec![(RVA(0x7), 1.0)]);
+    /// assert_eq!(index.get_machoc_groups(), vec![vec![RVA(0x0), RVA(0x7), RVA(0xE)]]);
+    /// ```
+    pub fn find_nearest(&self, rva: RVA, count: usize) -> Vec<(RVA, f64)> {
+        let signature = match self.signatures.get(&rva) {
+            Some(signature) => signature,
+            None => return vec![],
+        };
+
+        self.find_similar(signature, 0.0)
+            .into_iter()
+            .filter(|&(other, _)| other != rva)
+            .take(count)
+            .collect()
+    }
+
+    /// Group the functions with the same machoc hash,
+    ///  omitting functions with a unique hash.
+    ///
+    /// The groups and their members are sorted by address.
+    pub fn get_machoc_groups(&self) -> Vec<Vec<RVA>> {
+        let mut groups: HashMap<u32, Vec<RVA>> = HashMap::new();
+        for signature in self.signatures.values() {
+            groups
+                .entry(signature.machoc)
+                .or_insert_with(Vec::new)
+                .push(signature.rva);
+        }
+
+        let mut groups: Vec<Vec<RVA>> = groups.into_iter().map(|(_, g)| g).filter(|g| g.len() > 1).collect();
+        for group in groups.iter_mut() {
+            group.sort();
+        }
+        groups.sort();
+        groups
+    }
+}
@@ -0,0 +1,91 @@
+//! Statistics over the bytes of a region, used to spot packed, compressed,
+//!  or encrypted data.
+use failure::Error;
+
+use super::{arch::RVA, workspace::Workspace};
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct ByteStats {
+    /// Shannon entropy, in bits per byte, from 0.0 to 8.0.
+    pub entropy:         f64,
+    /// Pearson's chi-square statistic against a uniform distribution of
+    /// byte values. Random data scores near 255; structured data, much
+    /// higher.
+    pub chi_square:      f64,
+    /// The fraction of bytes that are printable ASCII, from 0.0 to 1.0.
+    pub printable_ratio: f64,
+}
+
+impl ByteStats {
+    /// Compute the statistics of the given buffer.
+    /// An empty buffer has no entropy and nothing printable.
+    ///
+    /// ```
+    /// use lancelot::stats::ByteStats;
+    ///
+    /// let stats = ByteStats::from_bytes(b"AAAA");
+    /// assert_eq!(stats.entropy, 0.0);
+    /// assert_eq!(stats.printable_ratio, 1.0);
+    ///
+    /// let buf: Vec<u8> = (0..=255).collect();
+    /// let stats = ByteStats::from_bytes(&buf);
+    /// assert_eq!(stats.entropy, 8.0);
+    /// assert_eq!(stats.chi_square, 0.0);
+    /// assert_eq!(stats.printable_ratio, 95.0 / 256.0);
+    /// ```
+    pub fn from_bytes(buf: &[u8]) -> ByteStats {
+        if buf.is_empty() {
+            return ByteStats {
+                entropy:         0.0,
+                chi_square:      0.0,
+                printable_ratio: 0.0,
+            };
+        }
+
+        let mut counts = [0usize; 256];
+        for &b in buf.iter() {
+            counts[b as usize] += 1;
+        }
+
+        let total = buf.len() as f64;
+        let expected = total / 256.0;
+
+        let mut entropy = 0.0;
+        let mut chi_square = 0.0;
+        for &count in counts.iter() {
+            if count > 0 {
+                let p = count as f64 / total;
+                entropy -= p * p.log2();
+            }
+            let delta = count as f64 - expected;
+            chi_square += delta * delta / expected;
+        }
+
+        let printable: usize = counts[0x20..=0x7E].iter().sum();
+
+        ByteStats {
+            entropy,
+            chi_square,
+            printable_ratio: printable as f64 / total,
+        }
+    }
+}
+
+impl Workspace {
+    /// Compute the statistics of the given region.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    ///
+    /// let ws = test::get_shellcode32_workspace(b"\x00\x00hello");
+    /// assert_eq!(ws.get_stats(RVA(0x2), 5).unwrap().printable_ratio, 1.0);
+    /// assert_eq!(ws.get_stats(RVA(0x0), 2).unwrap().entropy, 0.0);
+    /// assert!(ws.get_stats(RVA(0x1000), 2).is_err());
+    /// ```
+    ///
+    /// Errors: same as `read_bytes`.
+    pub fn get_stats(&self, rva: RVA, length: usize) -> Result<ByteStats, Error> {
+        Ok(ByteStats::from_bytes(&self.read_bytes(rva, length)?))
+    }
+}
@@ -0,0 +1,21 @@
+use super::arch::RVA;
+
+#[derive(Debug, Copy, Clone, Hash, PartialEq, Eq)]
+pub enum StringEncoding {
+    Ascii,
+    // UTF-16LE, as used by the "W" Windows APIs.
+    Utf16,
+}
+
+/// RecoveredString is a string found somewhere in the module,
+///  along with how it was found and which instructions reference it.
+#[derive(Debug, Clone, PartialEq)]
+pub struct RecoveredString {
+    pub rva:        RVA,
+    pub encoding:   StringEncoding,
+    pub text:       String,
+    /// the method that recovered the string, like "static scan".
+    pub source:     String,
+    /// RVAs of instructions that reference the start of the string.
+    pub references: Vec<RVA>,
+}
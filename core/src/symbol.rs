@@ -0,0 +1,154 @@
+//! Track where each symbol name came from, so that when sources disagree,
+//!  the most trustworthy name is displayed and the others remain available.
+//!
+//! Sources are ordered by priority, lowest first. A name replaces the current
+//!  symbol only if its source has a strictly higher priority, so among names
+//!  from the same source the first one found wins. The exception is
+//!  `Workspace::rename_symbol`, which always replaces the current symbol.
+use regex::Regex;
+
+use super::{arch::RVA, workspace::Workspace};
+
+#[derive(Debug, Copy, Clone, Hash, PartialEq, Eq, PartialOrd, Ord)]
+pub enum SymbolSource {
+    /// guessed from the contents of the code, like a referenced string.
+    Heuristic,
+    /// chosen by an analysis pass, like `entry`.
+    Analysis,
+    /// recognized by FLIRT, lancelot signatures, or function ID.
+    Signature,
+    /// the name of an imported function.
+    Import,
+    /// the name of an exported function.
+    Export,
+    /// from debug information, like a map file.
+    DebugInfo,
+    /// chosen by the user.
+    User,
+}
+
+impl SymbolSource {
+    pub fn name(self) -> &'static str {
+        match self {
+            SymbolSource::Heuristic => "heuristic",
+            SymbolSource::Analysis => "analysis",
+            SymbolSource::Signature => "signature",
+            SymbolSource::Import => "import",
+            SymbolSource::Export => "export",
+            SymbolSource::DebugInfo => "debug info",
+            SymbolSource::User => "user",
+        }
+    }
+
+    pub fn from_name(name: &str) -> Option<SymbolSource> {
+        match name {
+            "heuristic" => Some(SymbolSource::Heuristic),
+            "analysis" => Some(SymbolSource::Analysis),
+            "signature" => Some(SymbolSource::Signature),
+            "import" => Some(SymbolSource::Import),
+            "export" => Some(SymbolSource::Export),
+            "debug info" => Some(SymbolSource::DebugInfo),
+            "user" => Some(SymbolSource::User),
+            _ => None,
+        }
+    }
+}
+
+/// a name proposed for an address by some source.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct SymbolCandidate {
+    pub name:   String,
+    pub source: SymbolSource,
+}
+
+/// split an import name like `kernel32.dll!CreateFileA` into its module and
+/// function name.
+fn split_module(name: &str) -> (Option<&str>, &str) {
+    match name.rfind('!') {
+        Some(i) => (Some(&name[..i]), &name[i + 1..]),
+        None => (None, name),
+    }
+}
+
+/// strip the extension from the given module name, like `kernel32.dll`.
+fn module_stem(module: &str) -> &str {
+    match module.rfind('.') {
+        Some(i) => &module[..i],
+        None => module,
+    }
+}
+
+impl Workspace {
+    /// Find the symbols that match the given wildcard pattern,
+    ///  where `*` matches any sequence of characters and `?` matches any one
+    ///  character. Import names like `kernel32.dll!CreateFileA` match either
+    ///  with or without their module.
+    ///
+    /// The results are sorted by address.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    ///
+    /// let mut ws = test::get_shellcode32_workspace(b"\x00\x00\x00\x00");
+    /// ws.make_symbol(RVA(0x0), "kernel32.dll!CreateFileA").unwrap();
+    /// ws.make_symbol(RVA(0x1), "kernel32.dll!CreateFileW").unwrap();
+    /// ws.make_symbol(RVA(0x2), "ws2_32.dll!connect").unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// let rvas: Vec<RVA> = ws.find_symbols("CreateFile*").iter().map(|(rva, _)| *rva).collect();
+    /// assert_eq!(rvas, vec![RVA(0x0), RVA(0x1)]);
+    /// assert_eq!(ws.find_symbols("ws2_32.dll!conn?ct"), vec![(RVA(0x2), "ws2_32.dll!connect")]);
+    /// assert!(ws.find_symbols("Create").is_empty());
+    /// ```
+    pub fn find_symbols(&self, pattern: &str) -> Vec<(RVA, &str)> {
+        let pattern = regex::escape(pattern).replace("\\*", ".*").replace("\\?", ".");
+        // the pattern is escaped, so it is always a valid regex.
+        let re = Regex::new(&format!("^{}$", pattern)).unwrap();
+
+        let mut symbols: Vec<(RVA, &str)> = self
+            .analysis
+            .symbols
+            .iter()
+            .filter(|(_, name)| re.is_match(name) || re.is_match(split_module(name).1))
+            .map(|(&rva, name)| (rva, name.as_str()))
+            .collect();
+        symbols.sort();
+        symbols
+    }
+
+    /// Find the symbols imported from the given module, like `kernel32.dll`.
+    ///  The module name is case-insensitive, and the extension is optional.
+    ///
+    /// The results are sorted by address.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    ///
+    /// let mut ws = test::get_shellcode32_workspace(b"\x00\x00\x00\x00");
+    /// ws.make_symbol(RVA(0x0), "KERNEL32.dll!CreateFileA").unwrap();
+    /// ws.make_symbol(RVA(0x1), "ws2_32.dll!connect").unwrap();
+    /// ws.make_symbol(RVA(0x2), "entry").unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// assert_eq!(ws.get_module_symbols("kernel32"), vec![(RVA(0x0), "KERNEL32.dll!CreateFileA")]);
+    /// assert_eq!(ws.get_module_symbols("WS2_32.DLL"), vec![(RVA(0x1), "ws2_32.dll!connect")]);
+    /// ```
+    pub fn get_module_symbols(&self, module: &str) -> Vec<(RVA, &str)> {
+        let module = module_stem(module).to_lowercase();
+
+        let mut symbols: Vec<(RVA, &str)> = self
+            .analysis
+            .symbols
+            .iter()
+            .filter(|(_, name)| match split_module(name).0 {
+                Some(m) => module_stem(m).to_lowercase() == module,
+                None => false,
+            })
+            .map(|(&rva, name)| (rva, name.as_str()))
+            .collect();
+        symbols.sort();
+        symbols
+    }
+}
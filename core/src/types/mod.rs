@@ -0,0 +1,303 @@
+//! Storage for function prototypes, structures, and typedefs,
+//!  so that analysis passes share a single source of type information.
+//!
+//! Types are referenced by name, like `HANDLE` or `const char *`,
+//!  and are not otherwise parsed.
+//!
+//! A library can be loaded from a JSON document with the layout:
+//!
+//! ```json
+//! {
+//!   "typedefs": {"HMODULE": "HANDLE", "HANDLE": "void *"},
+//!   "structs": [{"name": "POINT", "fields": [{"name": "x", "type": "LONG"}]}],
+//!   "prototypes": [{
+//!     "name": "LoadLibraryA",
+//!     "return": "HMODULE",
+//!     "convention": "stdcall",
+//!     "arguments": [{"name": "lpLibFileName", "type": "LPCSTR"}]
+//!   }]
+//! }
+//! ```
+//!
+//! Arguments may have a `"direction"` of `in` (the default), `out`, or `inout`.
+use std::collections::HashMap;
+
+use failure::{Error, Fail};
+use rust_embed::RustEmbed;
+use serde_json::{self, Value};
+
+use super::function::CallingConvention;
+
+#[derive(Debug, Fail)]
+pub enum TypeError {
+    #[fail(display = "Invalid type library document")]
+    InvalidDocument,
+}
+
+/// whether the callee reads or writes an argument, like the SAL `_In_` and
+/// `_Out_` annotations. pointers to buffers the callee fills are `Out`.
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum Direction {
+    In,
+    Out,
+    InOut,
+}
+
+impl Default for Direction {
+    fn default() -> Direction {
+        Direction::In
+    }
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct Argument {
+    pub name:      String,
+    pub typ:       String,
+    pub direction: Direction,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct Prototype {
+    pub name:               String,
+    pub return_type:        String,
+    pub calling_convention: CallingConvention,
+    pub arguments:          Vec<Argument>,
+}
+
+impl Prototype {
+    /// Compute the number of bytes of arguments that the callee pops from the
+    /// stack on a 32-bit system, as with `RETN 0x10`.
+    ///
+    /// Each argument is assumed to occupy a single 4-byte stack slot.
+    ///
+    /// ```
+    /// use lancelot::types::TypeLibrary;
+    ///
+    /// let types = TypeLibrary::windows().unwrap();
+    /// assert_eq!(types.get_prototype("CreateFileA").unwrap().get_stack_cleanup(), 0x1C);
+    /// // the caller cleans up after a `cdecl` function.
+    /// assert_eq!(types.get_prototype("memcpy").unwrap().get_stack_cleanup(), 0x0);
+    /// ```
+    pub fn get_stack_cleanup(&self) -> usize {
+        let stack_arguments = match self.calling_convention {
+            CallingConvention::Stdcall => self.arguments.len(),
+            // the first two arguments are passed in ECX and EDX.
+            CallingConvention::Fastcall => self.arguments.len().saturating_sub(2),
+            // `this` is passed in ECX.
+            CallingConvention::Thiscall => self.arguments.len().saturating_sub(1),
+            CallingConvention::Cdecl | CallingConvention::Win64 | CallingConvention::Unknown => 0,
+        };
+        stack_arguments * 4
+    }
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct Field {
+    pub name: String,
+    pub typ:  String,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct Struct {
+    pub name:   String,
+    pub fields: Vec<Field>,
+}
+
+#[derive(RustEmbed)]
+#[folder = "$CARGO_MANIFEST_DIR/src/types/data"]
+struct Assets;
+
+#[derive(Default)]
+pub struct TypeLibrary {
+    // TODO: FNV
+    prototypes: HashMap<String, Prototype>,
+    structs:    HashMap<String, Struct>,
+    typedefs:   HashMap<String, String>,
+}
+
+fn calling_convention_from_name(name: &str) -> CallingConvention {
+    match name {
+        "cdecl" => CallingConvention::Cdecl,
+        "stdcall" => CallingConvention::Stdcall,
+        "fastcall" => CallingConvention::Fastcall,
+        "thiscall" => CallingConvention::Thiscall,
+        "win64" => CallingConvention::Win64,
+        _ => CallingConvention::Unknown,
+    }
+}
+
+fn get_str<'a>(v: &'a Value, key: &str) -> Result<&'a str, Error> {
+    v.get(key)
+        .and_then(Value::as_str)
+        .ok_or_else(|| TypeError::InvalidDocument.into())
+}
+
+/// parse an array of `{"name": ..., "type": ...}` objects.
+fn get_pairs(v: &Value, key: &str) -> Result<Vec<(String, String)>, Error> {
+    match v.get(key).and_then(Value::as_array) {
+        Some(pairs) => pairs
+            .iter()
+            .map(|pair| Ok((get_str(pair, "name")?.to_string(), get_str(pair, "type")?.to_string())))
+            .collect(),
+        None => Err(TypeError::InvalidDocument.into()),
+    }
+}
+
+fn direction_from_name(name: &str) -> Result<Direction, Error> {
+    match name {
+        "in" => Ok(Direction::In),
+        "out" => Ok(Direction::Out),
+        "inout" => Ok(Direction::InOut),
+        _ => Err(TypeError::InvalidDocument.into()),
+    }
+}
+
+/// parse the arguments of a prototype, which are `{"name": ..., "type": ...}`
+/// objects with an optional `"direction"` of `in` (the default), `out`, or
+/// `inout`.
+fn get_arguments(proto: &Value) -> Result<Vec<Argument>, Error> {
+    match proto.get("arguments").and_then(Value::as_array) {
+        Some(arguments) => arguments
+            .iter()
+            .map(|arg| {
+                Ok(Argument {
+                    name:      get_str(arg, "name")?.to_string(),
+                    typ:       get_str(arg, "type")?.to_string(),
+                    direction: match arg.get("direction") {
+                        Some(_) => direction_from_name(get_str(arg, "direction")?)?,
+                        None => Direction::default(),
+                    },
+                })
+            })
+            .collect(),
+        None => Err(TypeError::InvalidDocument.into()),
+    }
+}
+
+impl TypeLibrary {
+    pub fn new() -> TypeLibrary {
+        Default::default()
+    }
+
+    /// Load the type library bundled with lancelot that describes
+    ///  commonly used Windows APIs.
+    ///
+    /// ```
+    /// use lancelot::types::{Direction, TypeLibrary};
+    /// use lancelot::function::CallingConvention;
+    ///
+    /// let types = TypeLibrary::windows().unwrap();
+    /// let proto = types.get_prototype("CreateFileA").unwrap();
+    /// assert_eq!(proto.return_type, "HANDLE");
+    /// assert_eq!(proto.calling_convention, CallingConvention::Stdcall);
+    /// assert_eq!(proto.arguments[0].name, "lpFileName");
+    /// assert_eq!(proto.arguments[0].direction, Direction::In);
+    ///
+    /// let proto = types.get_prototype("ReadFile").unwrap();
+    /// assert_eq!(proto.arguments[3].direction, Direction::Out);
+    /// assert_eq!(types.resolve_typedef("HMODULE"), "void *");
+    /// assert_eq!(types.get_struct("PROCESS_INFORMATION").unwrap().fields.len(), 4);
+    /// ```
+    pub fn windows() -> Result<TypeLibrary, Error> {
+        let doc: Value = serde_json::from_slice(&Assets::get("windows.json").unwrap())?;
+        TypeLibrary::from_json(&doc)
+    }
+
+    /// Parse a type library from the given JSON document.
+    ///
+    /// Errors:
+    ///
+    ///   - InvalidDocument - if the document is missing expected fields.
+    pub fn from_json(doc: &Value) -> Result<TypeLibrary, Error> {
+        let mut types = TypeLibrary::new();
+
+        if let Some(typedefs) = doc.get("typedefs").and_then(Value::as_object) {
+            for (name, target) in typedefs.iter() {
+                let target = target.as_str().ok_or(TypeError::InvalidDocument)?;
+                types.add_typedef(name, target);
+            }
+        }
+
+        if let Some(structs) = doc.get("structs").and_then(Value::as_array) {
+            for s in structs.iter() {
+                types.add_struct(Struct {
+                    name:   get_str(s, "name")?.to_string(),
+                    fields: get_pairs(s, "fields")?
+                        .into_iter()
+                        .map(|(name, typ)| Field { name, typ })
+                        .collect(),
+                });
+            }
+        }
+
+        if let Some(prototypes) = doc.get("prototypes").and_then(Value::as_array) {
+            for proto in prototypes.iter() {
+                types.add_prototype(Prototype {
+                    name:               get_str(proto, "name")?.to_string(),
+                    return_type:        get_str(proto, "return")?.to_string(),
+                    calling_convention: calling_convention_from_name(get_str(proto, "convention")?),
+                    arguments:          get_arguments(proto)?,
+                });
+            }
+        }
+
+        Ok(types)
+    }
+
+    /// Add the contents of the given library to this one,
+    ///  replacing any existing definitions with the same name.
+    pub fn merge(&mut self, other: TypeLibrary) {
+        self.prototypes.extend(other.prototypes);
+        self.structs.extend(other.structs);
+        self.typedefs.extend(other.typedefs);
+    }
+
+    pub fn add_prototype(&mut self, proto: Prototype) {
+        self.prototypes.insert(proto.name.clone(), proto);
+    }
+
+    pub fn add_struct(&mut self, s: Struct) {
+        self.structs.insert(s.name.clone(), s);
+    }
+
+    pub fn add_typedef(&mut self, name: &str, target: &str) {
+        self.typedefs.insert(name.to_string(), target.to_string());
+    }
+
+    pub fn get_prototype(&self, name: &str) -> Option<&Prototype> {
+        self.prototypes.get(name)
+    }
+
+    pub fn get_struct(&self, name: &str) -> Option<&Struct> {
+        self.structs.get(name)
+    }
+
+    /// Follow the chain of typedefs from the given name to the underlying
+    /// type. If the name is not a typedef, it is returned unchanged.
+    ///
+    /// ```
+    /// use lancelot::types::TypeLibrary;
+    ///
+    /// let mut types = TypeLibrary::new();
+    /// types.add_typedef("HMODULE", "HANDLE");
+    /// types.add_typedef("HANDLE", "void *");
+    /// assert_eq!(types.resolve_typedef("HMODULE"), "void *");
+    /// assert_eq!(types.resolve_typedef("int"), "int");
+    ///
+    /// // cycles are broken, rather than followed forever.
+    /// types.add_typedef("A", "B");
+    /// types.add_typedef("B", "A");
+    /// types.resolve_typedef("A");
+    /// ```
+    pub fn resolve_typedef<'a>(&'a self, name: &'a str) -> &'a str {
+        let mut name = name;
+        // bound the chain, in case of cycles.
+        for _ in 0..self.typedefs.len() {
+            match self.typedefs.get(name) {
+                Some(target) => name = target,
+                None => break,
+            }
+        }
+        name
+    }
+}
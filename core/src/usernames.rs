@@ -0,0 +1,141 @@
+//! Names supplied by the user, which are applied when a workspace is loaded
+//!  and take precedence over the names found by analysis.
+//!
+//! A names file has one entry per line. An entry is either a virtual address
+//!  and a name, or a regular expression between slashes and a replacement,
+//!  which renames each existing symbol that matches the expression:
+//!
+//! ```text
+//! # comments and blank lines are ignored.
+//! 0x401000 main
+//! /^kernel32\.dll!(.*)A$/ $1
+//! ```
+//!
+//! The replacement may reference capture groups, like `$1`.
+use failure::{Error, Fail};
+use log::debug;
+use regex::Regex;
+
+use super::{
+    arch::{RVA, VA},
+    workspace::Workspace,
+};
+
+#[derive(Debug, Fail)]
+pub enum UserNamesError {
+    #[fail(display = "Invalid names file entry on line {}", _0)]
+    InvalidEntry(usize),
+}
+
+#[derive(Debug, Default)]
+pub struct UserNames {
+    addresses: Vec<(VA, String)>,
+    patterns:  Vec<(Regex, String)>,
+}
+
+impl UserNames {
+    pub fn new() -> UserNames {
+        UserNames::default()
+    }
+
+    pub fn add_address(&mut self, va: VA, name: &str) {
+        self.addresses.push((va, name.to_string()));
+    }
+
+    pub fn add_pattern(&mut self, pattern: Regex, replacement: &str) {
+        self.patterns.push((pattern, replacement.to_string()));
+    }
+
+    /// Parse the given names file.
+    ///
+    /// Errors:
+    ///
+    ///   - InvalidEntry - if a line is not a valid entry, with its line number.
+    pub fn parse(s: &str) -> Result<UserNames, Error> {
+        let mut names = UserNames::new();
+
+        for (i, line) in s.lines().enumerate() {
+            let line = line.trim();
+            if line.is_empty() || line.starts_with('#') {
+                continue;
+            }
+            let invalid = || UserNamesError::InvalidEntry(i + 1);
+
+            if line.starts_with('/') {
+                let end = line.rfind('/').filter(|&end| end > 0).ok_or_else(invalid)?;
+                let pattern = Regex::new(&line[1..end]).map_err(|_| invalid())?;
+                let replacement = line[end + 1..].trim();
+                if replacement.is_empty() {
+                    return Err(invalid().into());
+                }
+                names.add_pattern(pattern, replacement);
+            } else {
+                let mut parts = line.splitn(2, char::is_whitespace);
+                let address = parts.next().ok_or_else(invalid)?;
+                let name = parts.next().map(str::trim).filter(|name| !name.is_empty());
+                let name = name.ok_or_else(invalid)?;
+
+                let address = address.trim_start_matches("0x").trim_start_matches("0X");
+                let va = u64::from_str_radix(address, 16).map_err(|_| invalid())?;
+                names.add_address(VA::from(va), name);
+            }
+        }
+
+        Ok(names)
+    }
+
+    /// Apply the names to the given workspace, replacing existing symbols,
+    ///  and return the number of names applied.
+    ///
+    /// Patterns are applied first, so that an explicit address wins.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::usernames::UserNames;
+    ///
+    /// let mut ws = test::get_shellcode32_workspace(b"\x90\x90\xC3");
+    /// ws.make_symbol(RVA(0x0), "kernel32.dll!CreateFileA").unwrap();
+    /// ws.make_symbol(RVA(0x1), "sub_1").unwrap();
+    /// ws.analyze().unwrap();
+    ///
+    /// let names = UserNames::parse("
+    ///     # user names
+    ///     /^kernel32\\.dll!(.*)A$/ $1
+    ///     0x1 main
+    /// ").unwrap();
+    /// assert_eq!(names.apply(&mut ws).unwrap(), 2);
+    /// assert_eq!(ws.get_symbol(RVA(0x0)).unwrap(), "CreateFile");
+    /// assert_eq!(ws.get_symbol(RVA(0x1)).unwrap(), "main");
+    ///
+    /// assert!(UserNames::parse("0x1").is_err());
+    /// ```
+    pub fn apply(&self, ws: &mut Workspace) -> Result<usize, Error> {
+        let mut names: Vec<(RVA, String)> = vec![];
+
+        for (pattern, replacement) in self.patterns.iter() {
+            let mut symbols: Vec<(&RVA, &String)> = ws.analysis.symbols.iter().collect();
+            symbols.sort();
+            for (&rva, name) in symbols.into_iter() {
+                if pattern.is_match(name) {
+                    names.push((rva, pattern.replace(name, replacement.as_str()).to_string()));
+                }
+            }
+        }
+
+        for (va, name) in self.addresses.iter() {
+            match ws.rva(*va) {
+                Some(rva) => names.push((rva, name.clone())),
+                None => debug!("user name at unmapped address: {}: {}", va, name),
+            }
+        }
+
+        for (rva, name) in names.iter() {
+            debug!("user name: {}: {}", rva, name);
+            ws.rename_symbol(*rva, name)?;
+        }
+        ws.analyze()?;
+
+        Ok(names.len())
+    }
+}
@@ -169,3 +169,20 @@ pub fn read_file(filename: &str) -> Result<Vec<u8>, Error> {
 
     Ok(buf)
 }
+
+/// Format a constant as hex, with a leading minus sign if it's negative.
+///
+/// ```
+/// use lancelot::util::format_constant;
+///
+/// assert_eq!(format_constant(0x10), "0x10");
+/// assert_eq!(format_constant(-0x4), "-0x4");
+/// assert_eq!(format_constant(std::i64::MIN), "-0x8000000000000000");
+/// ```
+pub fn format_constant(v: i64) -> String {
+    if v < 0 {
+        format!("-{:#x}", -(v as i128))
+    } else {
+        format!("{:#x}", v)
+    }
+}
@@ -1,4 +1,7 @@
-use std::collections::{HashMap, VecDeque};
+use std::{
+    collections::{BTreeMap, HashMap, VecDeque},
+    time::Instant,
+};
 
 use byteorder::{ByteOrder, LittleEndian};
 use failure::{Error, Fail};
@@ -6,11 +9,15 @@ use log::{info, warn};
 use zydis::{self, Decoder};
 
 use super::{
-    analysis::Analysis,
+    analysis::{self, listener::Progress, Analysis, AnalysisListener, Analyzer},
     arch::{Arch, RVA, VA},
     basicblock::BasicBlock,
     config::Config,
-    loader::{self, LoadedModule, Loader, Permissions},
+    format::{self, AddressFormatter},
+    loader::{self, LoadedModule, Loader, Permissions, Platform, Section},
+    patch::Patch,
+    types::TypeLibrary,
+    usernames::UserNames,
     util,
     xref::XrefType,
 };
@@ -25,6 +32,14 @@ pub enum WorkspaceError {
     BufferOverrun,
     #[fail(display = "The instruction at the given address is invalid")]
     InvalidInstruction,
+    /// a read of `length` bytes at `rva` touched unmapped memory.
+    /// `nearest` is the section closest to `rva`, if there are any sections.
+    #[fail(display = "Read of {:#x} bytes at {} is out of bounds", length, rva)]
+    OutOfBounds {
+        rva:     RVA,
+        length:  usize,
+        nearest: Option<Section>,
+    },
 }
 
 pub struct WorkspaceBuilder {
@@ -38,6 +53,14 @@ pub struct WorkspaceBuilder {
 
     /// when true, the analysis failures should fail the loading of the module.
     strict_mode: bool,
+
+    listeners: Vec<Box<dyn AnalysisListener>>,
+
+    /// analyzers provided by the user, run after those suggested by the loader.
+    analyzers: Vec<Box<dyn Analyzer>>,
+
+    /// names provided by the user, applied after all analyzers.
+    user_names: Option<UserNames>,
 }
 
 impl WorkspaceBuilder {
@@ -70,6 +93,98 @@ impl WorkspaceBuilder {
         WorkspaceBuilder { config, ..self }
     }
 
+    /// Run the given analyzer after those suggested by the loader.
+    /// This is how external code can extend the analysis of a workspace;
+    ///  to act on each function or xref as it is found, use `with_listener`.
+    ///
+    /// ```
+    /// use lancelot::arch::*;
+    /// use lancelot::loader::Platform;
+    /// use lancelot::loaders::sc::ShellcodeLoader;
+    /// use lancelot::analysis::StringAnalyzer;
+    /// use lancelot::workspace::Workspace;
+    ///
+    /// let ws = Workspace::from_bytes("foo.bin", b"\xC3hello world\x00")
+    ///     .with_loader(Box::new(ShellcodeLoader::new(Platform::Windows, Arch::X32)))
+    ///     .with_analyzer(Box::new(StringAnalyzer::new()))
+    ///     .load()
+    ///     .unwrap();
+    /// assert_eq!(ws.get_string(RVA(0x1)).unwrap().text, "hello world");
+    /// ```
+    pub fn with_analyzer(self: WorkspaceBuilder, analyzer: Box<dyn Analyzer>) -> WorkspaceBuilder {
+        let mut analyzers = self.analyzers;
+        analyzers.push(analyzer);
+        WorkspaceBuilder { analyzers, ..self }
+    }
+
+    /// Don't run the analyzer with the given name, like "orphan function
+    /// analyzer", even if the loader suggests it.
+    /// This updates `config.analysis.disabled_analyzers`,
+    ///  so call it after `with_config`.
+    pub fn disable_analyzer(self: WorkspaceBuilder, name: &str) -> WorkspaceBuilder {
+        info!("disabling analyzer: {}", name);
+        let mut config = self.config;
+        config.analysis.disabled_analyzers.insert(name.to_string());
+        WorkspaceBuilder { config, ..self }
+    }
+
+    /// Run the optional analyzer with the given name, like "string analyzer",
+    ///  in addition to those suggested by the loader.
+    /// This updates `config.analysis.enabled_analyzers`,
+    ///  so call it after `with_config`.
+    ///
+    /// ```
+    /// use lancelot::arch::*;
+    /// use lancelot::loader::Platform;
+    /// use lancelot::loaders::sc::ShellcodeLoader;
+    /// use lancelot::workspace::Workspace;
+    ///
+    /// let ws = Workspace::from_bytes("foo.bin", b"\xC3hello world\x00")
+    ///     .with_loader(Box::new(ShellcodeLoader::new(Platform::Windows, Arch::X32)))
+    ///     .enable_analyzer("string analyzer")
+    ///     .load()
+    ///     .unwrap();
+    /// assert_eq!(ws.get_string(RVA(0x1)).unwrap().text, "hello world");
+    /// ```
+    pub fn enable_analyzer(self: WorkspaceBuilder, name: &str) -> WorkspaceBuilder {
+        info!("enabling analyzer: {}", name);
+        let mut config = self.config;
+        config.analysis.enabled_analyzers.insert(name.to_string());
+        WorkspaceBuilder { config, ..self }
+    }
+
+    /// Apply the given user names after analysis,
+    ///  so that they take precedence over the names found by the analyzers.
+    ///
+    /// ```
+    /// use lancelot::arch::*;
+    /// use lancelot::loader::Platform;
+    /// use lancelot::loaders::sc::ShellcodeLoader;
+    /// use lancelot::usernames::UserNames;
+    /// use lancelot::workspace::Workspace;
+    ///
+    /// let ws = Workspace::from_bytes("foo.bin", b"\xC3")
+    ///     .with_loader(Box::new(ShellcodeLoader::new(Platform::Windows, Arch::X32)))
+    ///     .with_user_names(UserNames::parse("0x0 main").unwrap())
+    ///     .load()
+    ///     .unwrap();
+    /// assert_eq!(ws.get_symbol(RVA(0x0)).unwrap(), "main");
+    /// ```
+    pub fn with_user_names(self: WorkspaceBuilder, names: UserNames) -> WorkspaceBuilder {
+        WorkspaceBuilder {
+            user_names: Some(names),
+            ..self
+        }
+    }
+
+    /// Register a listener before loading,
+    ///  so that it is notified of the artifacts found by the initial analysis.
+    pub fn with_listener(self: WorkspaceBuilder, listener: Box<dyn AnalysisListener>) -> WorkspaceBuilder {
+        let mut listeners = self.listeners;
+        listeners.push(listener);
+        WorkspaceBuilder { listeners, ..self }
+    }
+
     /// Construct a workspace with the given builder configuration.
     ///
     /// This invokes the loaders, analyzers, and another other logic,
@@ -112,8 +227,7 @@ impl WorkspaceBuilder {
     pub fn load(self: WorkspaceBuilder) -> Result<Workspace, Error> {
         // if the user provided a loader, use that.
         // otherwise, use the default detected loader.
-        let (ldr, module, analyzers) = match self.loader {
-            // TODO: let users specify analyzers via builder
+        let (ldr, module, mut analyzers) = match self.loader {
             Some(ldr) => {
                 let (module, analyzers) = ldr.load(&self.config, &self.buf)?;
                 (ldr, module, analyzers)
@@ -137,26 +251,77 @@ impl WorkspaceBuilder {
             filename: self.filename,
             buf: self.buf,
 
+            config: self.config,
+
             loader: ldr,
             module,
 
             decoder,
 
             analysis,
+
+            patches: BTreeMap::new(),
+
+            formatters: format::default_formatters(),
         };
 
+        for listener in self.listeners.into_iter() {
+            ws.add_listener(listener);
+        }
+
+        match ws.loader.get_plat() {
+            Platform::Windows => match TypeLibrary::windows() {
+                Ok(types) => ws.analysis.types.merge(types),
+                Err(e) => warn!("failed to load windows type library: {}", e),
+            },
+        }
+
+        let enabled_analyzers = &ws.config.analysis.enabled_analyzers;
+        analyzers.extend(
+            analysis::get_optional_analyzers()
+                .into_iter()
+                .filter(|analyzer| enabled_analyzers.contains(&analyzer.get_name())),
+        );
+        analyzers.extend(self.analyzers);
+
         if self.should_analyze {
-            for analyzer in analyzers.iter() {
-                info!("analyzing with {}", analyzer.get_name());
-                if let Err(e) = analyzer.analyze(&mut ws) {
+            let disabled_analyzers = &ws.config.analysis.disabled_analyzers;
+            let analyzers: Vec<&dyn Analyzer> = analyzers
+                .iter()
+                .filter(|analyzer| {
+                    if disabled_analyzers.contains(&analyzer.get_name()) {
+                        info!("skipping disabled analyzer: {}", analyzer.get_name());
+                        false
+                    } else {
+                        true
+                    }
+                })
+                .map(|analyzer| analyzer.as_ref())
+                .collect();
+            let analyzers = analysis::schedule_analyzers(analyzers)?;
+
+            let start = Instant::now();
+            for (i, analyzer) in analyzers.iter().enumerate() {
+                if let Err(e) = ws.run_analyzer(*analyzer) {
                     warn!("analyzer failed: {}: {}", analyzer.get_name(), e);
                     if self.strict_mode {
                         return Err(e);
                     }
                 }
+
+                ws.report_progress(&Progress {
+                    completed: i + 1,
+                    total:     analyzers.len(),
+                    elapsed:   start.elapsed(),
+                });
             }
         }
 
+        if let Some(names) = self.user_names {
+            let count = names.apply(&mut ws)?;
+            info!("applied {} user names", count);
+        }
+
         Ok(ws)
     }
 }
@@ -166,6 +331,8 @@ pub struct Workspace {
     pub filename: String,
     // raw bytes of the file
     pub buf: Vec<u8>,
+    // the configuration used to load the file
+    pub config: Config,
 
     pub loader: Box<dyn Loader>,
     pub module: LoadedModule,
@@ -174,6 +341,12 @@ pub struct Workspace {
 
     // pub only so that we can split the impl
     pub analysis: Analysis,
+
+    // pub only so that we can split the impl, see `patch`
+    pub patches: BTreeMap<RVA, Patch>,
+
+    // pub only so that we can split the impl, see `format`
+    pub formatters: Vec<Box<dyn AddressFormatter>>,
 }
 
 impl Workspace {
@@ -188,6 +361,9 @@ impl Workspace {
             loader:         None,
             should_analyze: true,
             strict_mode:    false,
+            listeners:      vec![],
+            analyzers:      vec![],
+            user_names:     None,
         }
     }
 
@@ -199,22 +375,45 @@ impl Workspace {
             loader:         None,
             should_analyze: true,
             strict_mode:    false,
+            listeners:      vec![],
+            analyzers:      vec![],
+            user_names:     None,
         })
     }
 
+    /// Describe a failed read of `length` bytes at the given RVA,
+    ///  including the closest section, to help attribute the failure.
+    fn out_of_bounds(&self, rva: RVA, length: usize) -> Error {
+        let nearest = self
+            .module
+            .sections
+            .iter()
+            .min_by_key(|section| {
+                if rva < section.addr {
+                    (section.addr - rva).0
+                } else if rva >= section.end() {
+                    (rva - section.end()).0
+                } else {
+                    0
+                }
+            })
+            .cloned();
+
+        WorkspaceError::OutOfBounds { rva, length, nearest }.into()
+    }
+
     /// Read bytes from the given RVA.
     ///
     /// Errors:
     ///
-    ///   - InvalidAddress - if the address is not mapped.
-    ///   - BufferOverrun - if the requested region runs beyond the matching
-    ///     section.
+    ///   - OutOfBounds - if any of the requested region is not mapped.
     ///
     /// Example:
     ///
     /// ```
     /// use lancelot::test;
     /// use lancelot::arch::RVA;
+    /// use lancelot::workspace::WorkspaceError;
     ///
     /// let ws = test::get_shellcode32_workspace(b"\xEB\xFE");
     /// assert_eq!(ws.read_bytes(RVA(0x0), 0x1).unwrap(), b"\xEB");
@@ -224,20 +423,29 @@ impl Workspace {
     /// assert!(ws.read_bytes(RVA(0x0), 0x1000).is_ok(), "read page");
     /// assert!(ws.read_bytes(RVA(0x0), 0x1001).is_err(), "read more than a page");
     /// assert!(ws.read_bytes(RVA(0x1), 0x1000).is_err(), "read unaligned page");
+    ///
+    /// match ws.read_bytes(RVA(0x1000), 0x10).unwrap_err().downcast::<WorkspaceError>() {
+    ///     Ok(WorkspaceError::OutOfBounds { rva, length, nearest }) => {
+    ///         assert_eq!(rva, RVA(0x1000));
+    ///         assert_eq!(length, 0x10);
+    ///         assert_eq!(nearest.unwrap().name, "raw");
+    ///     }
+    ///     _ => panic!("expected out of bounds error"),
+    /// }
     /// ```
     pub fn read_bytes(&self, rva: RVA, length: usize) -> Result<Vec<u8>, Error> {
         self.module
             .address_space
             .slice(rva, rva + length)
-            .map_err(|_| WorkspaceError::InvalidAddress.into())
+            .map_err(|_| self.out_of_bounds(rva, length))
     }
 
     pub fn read_bytes_into<'a>(&self, rva: RVA, buf: &'a mut [u8]) -> Result<&'a [u8], Error> {
+        let length = buf.len();
         self.module
             .address_space
             .slice_into(rva, buf)
-            .map_err(|_| WorkspaceError::InvalidAddress.into())
-            .and_then(Ok)
+            .map_err(|_| self.out_of_bounds(rva, length))
     }
 
     /// Is the given range mapped?
@@ -301,7 +509,7 @@ impl Workspace {
         self.module
             .address_space
             .slice_into(rva, &mut buf)
-            .map_err(|_| WorkspaceError::InvalidAddress.into())
+            .map_err(|_| self.out_of_bounds(rva, 1))
             .and_then(|buf| Ok(buf[0]))
     }
 
@@ -324,7 +532,7 @@ impl Workspace {
         self.module
             .address_space
             .slice_into(rva, &mut buf)
-            .map_err(|_| WorkspaceError::InvalidAddress.into())
+            .map_err(|_| self.out_of_bounds(rva, 2))
             .and_then(|buf| Ok(LittleEndian::read_u16(buf)))
     }
 
@@ -334,7 +542,7 @@ impl Workspace {
         self.module
             .address_space
             .slice_into(rva, &mut buf)
-            .map_err(|_| WorkspaceError::InvalidAddress.into())
+            .map_err(|_| self.out_of_bounds(rva, 4))
             .and_then(|buf| Ok(LittleEndian::read_u32(buf)))
     }
 
@@ -344,7 +552,7 @@ impl Workspace {
         self.module
             .address_space
             .slice_into(rva, &mut buf)
-            .map_err(|_| WorkspaceError::InvalidAddress.into())
+            .map_err(|_| self.out_of_bounds(rva, 8))
             .and_then(|buf| Ok(LittleEndian::read_u64(buf)))
     }
 
@@ -354,7 +562,7 @@ impl Workspace {
         self.module
             .address_space
             .slice_into(rva, &mut buf)
-            .map_err(|_| WorkspaceError::InvalidAddress.into())
+            .map_err(|_| self.out_of_bounds(rva, 4))
             .and_then(|buf| Ok(LittleEndian::read_i32(buf)))
     }
 
@@ -364,7 +572,7 @@ impl Workspace {
         self.module
             .address_space
             .slice_into(rva, &mut buf)
-            .map_err(|_| WorkspaceError::InvalidAddress.into())
+            .map_err(|_| self.out_of_bounds(rva, 8))
             .and_then(|buf| Ok(LittleEndian::read_i64(buf)))
     }
 
@@ -403,7 +611,7 @@ impl Workspace {
     ///
     /// Errors:
     ///
-    ///   - InvalidAddress - if the address is not mapped.
+    ///   - OutOfBounds - if the address is not mapped.
     ///   - InvalidInstruction - if an instruction cannot be decoded.
     ///
     /// Example:
@@ -444,12 +652,12 @@ impl Workspace {
             // when the target is not mapped at all.
             if buflen == 0x10 {
                 if !self.module.address_space.probe(rva) {
-                    return Err(WorkspaceError::InvalidAddress.into());
+                    return Err(self.out_of_bounds(rva, 1));
                 }
             }
         }
 
-        Err(WorkspaceError::InvalidAddress.into())
+        Err(self.out_of_bounds(rva, 1))
     }
 
     /// Read a utf-8 encoded string at the given RVA.
@@ -457,7 +665,7 @@ impl Workspace {
     ///
     /// Errors:
     ///
-    ///   - InvalidAddress - if the address is not mapped.
+    ///   - OutOfBounds - if the address is not mapped.
     ///   - std::str::Utf8Error - if the data is not valid utf8.
     ///
     /// Example:
@@ -477,11 +685,8 @@ impl Workspace {
         // section. note: max read size is 0x1000 bytes.
         if self.module.address_space.slice_into(rva, &mut buf).is_err() {
             // read until the end of the section.
-            self.module
-                .sections
-                .iter()
-                .find(|section| section.contains(rva))
-                .ok_or_else(|| WorkspaceError::InvalidAddress.into())
+            self.get_section(rva)
+                .ok_or_else(|| self.out_of_bounds(rva, 1))
                 .and_then(|section| {
                     let size: usize = (section.end() - rva).into();
                     let size = std::cmp::min(size, 0x1000);
@@ -506,6 +711,26 @@ impl Workspace {
         self.module.base_address.va(rva)
     }
 
+    /// Fetch the section that contains the given address, if any.
+    ///
+    /// This can be used to group or filter addresses by memory region,
+    ///  such as finding xrefs from `.text` into `.rsrc`.
+    ///
+    /// ```
+    /// use lancelot::test;
+    /// use lancelot::arch::RVA;
+    /// use lancelot::loader::Permissions;
+    ///
+    /// let ws = test::get_shellcode32_workspace(b"\x90\xC3");
+    /// let section = ws.get_section(RVA(0x1)).unwrap();
+    /// assert_eq!(section.name, "raw");
+    /// assert_eq!(section.perms, Permissions::RWX);
+    /// assert!(ws.get_section(RVA(0x2)).is_none());
+    /// ```
+    pub fn get_section(&self, rva: RVA) -> Option<&Section> {
+        self.module.sections.iter().find(|section| section.contains(rva))
+    }
+
     // API:
     //   get_insn
     //   get_xrefs_to
@@ -0,0 +1,31 @@
+//! Helpers for inspecting the x86 instructions decoded by zydis,
+//!  shared by the lifters and the analyzers.
+use zydis;
+
+/// the lowercase name of the given register, like `eax`, or `None` for
+/// `Register::NONE`.
+pub fn register_name(register: zydis::Register) -> Option<String> {
+    if register == zydis::Register::NONE {
+        None
+    } else {
+        register.get_string().map(|s| s.to_lowercase())
+    }
+}
+
+/// the lowercase name of the given mnemonic, like `mov`.
+pub fn mnemonic_name(mnemonic: zydis::Mnemonic) -> String {
+    mnemonic.get_string().unwrap_or("?").to_lowercase()
+}
+
+/// the explicit operands of the given instruction.
+pub fn get_operands(insn: &zydis::DecodedInstruction) -> Vec<&zydis::DecodedOperand> {
+    insn.operands
+        .iter()
+        .filter(|op| op.visibility == zydis::OperandVisibility::EXPLICIT)
+        .collect()
+}
+
+/// is the given mnemonic a conditional jump, like `JNZ`?
+pub fn is_conditional_jump(mnemonic: zydis::Mnemonic) -> bool {
+    mnemonic != zydis::Mnemonic::JMP && mnemonic_name(mnemonic).starts_with('j')
+}
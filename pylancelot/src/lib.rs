@@ -26,6 +26,9 @@ macro_rules! pyo3_try {
                     Ok(lancelot::workspace::WorkspaceError::InvalidInstruction) => {
                         pyo3::exceptions::LookupError::py_err("invalid instruction")
                     }
+                    Ok(e @ lancelot::workspace::WorkspaceError::OutOfBounds { .. }) => {
+                        pyo3::exceptions::LookupError::py_err(e.to_string())
+                    }
                     Ok(_) => {
                         // default case: value error
                         pyo3::exceptions::ValueError::py_err(name)